@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/certs"
+	"github.com/ghodss/yaml"
+)
+
+const genCertsUsage = `Usage: k8s-injector gen-certs [--service NAME] [--namespace NAMESPACE] [--san NAMES] [-o pem|secret]
+
+Generates a CA and a serving certificate for <service>.<namespace>.svc, the name the API server calls the
+webhook by. With -o pem, ca.pem, ca-key.pem, cert.pem and key.pem are written to --out-dir. With -o secret, a
+kubernetes.io/tls Secret is printed, and the caBundle of the webhook configurations is printed on stderr. To
+renew the serving certificate without changing the caBundle, pass the CA of a previous run with --ca-cert and
+--ca-key. For example:
+
+  k8s-injector gen-certs -o secret | kubectl apply -f -
+
+`
+
+// Output formats of gen-certs
+const (
+	outputPEM    = "pem"
+	outputSecret = "secret"
+)
+
+// genCerts is the gen-certs command
+func genCerts(args []string) error {
+	fs := flag.NewFlagSet("gen-certs", flag.ContinueOnError)
+	service := fs.String("service", "k8s-injector", "The name of the Service of the webhook")
+	namespace := fs.String("namespace", "kube-system", "The namespace of the Service of the webhook")
+	sans := fs.String("san", "", "Comma-separated list of more DNS names and IP addresses of the serving certificate")
+	validity := fs.Duration("validity", certs.DefaultValidity, "How long the serving certificate is valid for")
+	caValidity := fs.Duration("ca-validity", certs.DefaultCAValidity, "How long a generated CA is valid for")
+	caCertFile := fs.String("ca-cert", "", "The PEM certificate of an existing CA to sign with")
+	caKeyFile := fs.String("ca-key", "", "The PEM key of an existing CA to sign with")
+	output := fs.String("o", outputPEM, "The output, pem files or a secret manifest")
+	outDir := fs.String("out-dir", ".", "The directory to write the pem files to")
+	force := fs.Bool("force", false, "Overwrite existing pem files")
+	secretName := fs.String("secret-name", "k8s-injector-tls", "The name of the Secret")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), genCertsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *output != outputPEM && *output != outputSecret {
+		return fmt.Errorf("invalid output passed: %s Should be one of: %s, %s", *output, outputPEM, outputSecret)
+	}
+	if (*caCertFile == "") != (*caKeyFile == "") {
+		return fmt.Errorf("--ca-cert and --ca-key go together")
+	}
+
+	opts := certs.Options{
+		Service:    *service,
+		Namespace:  *namespace,
+		Validity:   *validity,
+		CAValidity: *caValidity,
+	}
+	if *sans != "" {
+		opts.SANs = strings.Split(*sans, ",")
+	}
+	if *caCertFile != "" {
+		var err error
+		if opts.CACert, err = ioutil.ReadFile(*caCertFile); err != nil {
+			return err
+		}
+		if opts.CAKey, err = ioutil.ReadFile(*caKeyFile); err != nil {
+			return err
+		}
+	}
+	bundle, err := certs.Generate(opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *output == outputSecret {
+		out, err := yaml.Marshal(bundle.Secret(*secretName, *namespace))
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(out); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Set the caBundle of the webhooks of the k8s-injector webhook configurations to:\n\ncaBundle: %s\n", base64.StdEncoding.EncodeToString(bundle.CACert))
+		return nil
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"ca.pem", bundle.CACert, 0644},
+		{"ca-key.pem", bundle.CAKey, 0600},
+		{"cert.pem", bundle.Cert, 0644},
+		{"key.pem", bundle.Key, 0600},
+	}
+	// A given CA is not written again
+	if *caCertFile != "" {
+		files = files[2:]
+	}
+	// Nothing is written unless every file can be, so that a CA never ends up without its certificate
+	for _, file := range files {
+		path := filepath.Join(*outDir, file.name)
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists, pass --force to overwrite it", path)
+		}
+	}
+	for _, file := range files {
+		path := filepath.Join(*outDir, file.name)
+		if err := writeFile(path, file.data, file.mode); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file of the given mode that then replaces path, so an existing file
+// never keeps its own mode or ends up half written
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	injectpkg "github.com/dungdev1/k8s-injector/pkg/inject"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const injectUsage = `Usage: k8s-injector inject [-f FILE]... --configmap FILE... [--namespace NAMESPACE]
+
+Runs the Pods and the pod templates of workloads in manifests through the same mutation as the webhook, with
+the injection configs of the given ConfigMaps, and prints the mutated manifests. Other objects are printed as
+they are. The namespaces of the manifests are taken as enabled for injection. What was injected into each
+object is reported on stderr. For example:
+
+  k8s-injector inject -f deploy.yaml --configmap config/configmap.yaml | kubectl apply -f -
+
+`
+
+// inject is the inject command
+func inject(args []string) error {
+	fs := flag.NewFlagSet("inject", flag.ContinueOnError)
+	var files, configMaps filesFlag
+	fs.Var(&files, "f", "A manifest to inject, - for stdin (default -). Can be given several times")
+	fs.Var(&configMaps, "configmap", "A manifest of injection config ConfigMaps. Can be given several times")
+	namespace := fs.String("namespace", metav1.NamespaceDefault, "The namespace of objects that have none")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), injectUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if len(configMaps) == 0 {
+		return fmt.Errorf("no --configmap given")
+	}
+	if len(files) == 0 {
+		files = filesFlag{"-"}
+	}
+	// Load errors and decisions are reported on stderr, the logs of the webhook would only repeat them
+	log.Logger = zerolog.Nop()
+
+	ctx := context.Background()
+	cfms, err := injectpkg.ReadConfigMaps(configMaps)
+	if err != nil {
+		return err
+	}
+	snapshot, err := injectpkg.Snapshot(ctx, cfms, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	var objs []map[string]interface{}
+	for _, file := range files {
+		docs, err := injectpkg.ReadDocuments(file)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, docs...)
+	}
+	if err := injectpkg.Objects(ctx, objs, *namespace, snapshot, os.Stderr); err != nil {
+		return err
+	}
+	return injectpkg.WriteDocuments(os.Stdout, objs)
+}
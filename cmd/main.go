@@ -2,121 +2,296 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"strings"
+	"reflect"
 	"syscall"
 	"time"
 
+	"github.com/dungdev1/k8s-injector/pkg/audit"
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/events"
+	"github.com/dungdev1/k8s-injector/pkg/injectionconfig"
+	"github.com/dungdev1/k8s-injector/pkg/leader"
 	webhook "github.com/dungdev1/k8s-injector/pkg/server"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
 	watcherpkg "github.com/dungdev1/k8s-injector/pkg/watcher"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 )
 
 const timeFormat = "02/01/2006 15:04:05"
 
 var mainConfig config.Config
 
-func describe(i interface{}) {
-	fmt.Printf("%v type %T\n", i, i)
+// commands are run instead of the webhook server when named by the first argument
+var commands = map[string]func(args []string) error{
+	"gen-certs":        genCerts,
+	"inject":           inject,
+	"migrate-config":   migrateConfig,
+	"render-manifests": renderManifests,
+	"validate-config":  validateConfig,
 }
 
-func init() {
+func setup() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: timeFormat, NoColor: true})
 
 	err := config.ParseCliArgs(&mainConfig)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to parse cli args")
 	}
-
-	var x interface{}
-	x = 5
-	describe(x)
-
-	switch strings.ToLower(mainConfig.LogLevel) {
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	if mainConfig.LogFormat == config.LogFormatJSON {
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	}
+
+	setLogLevel(mainConfig.LogLevel)
 }
 
-var InjConfigs = map[string]*config.InjectionConfig{}
+// setLogLevel sets the level of every logger, level was already validated by config.ParseCliArgs
+func setLogLevel(level string) {
+	l, _ := config.ParseLogLevel(level)
+	zerolog.SetGlobalLevel(l)
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				if err != flag.ErrHelp {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	setup()
 
-	// Start web server
-	webhook := webhook.NewWebhookServer()
+	// Both were already validated by config.ParseCliArgs
+	tlsMinVersion, _ := config.ParseTLSVersion(mainConfig.TLSMinVersion)
+	tlsCipherSuites, _ := config.ParseTLSCipherSuites(mainConfig.TLSCipherSuites)
+	serverOptions := webhook.ServerOptions{
+		ReadTimeout:     mainConfig.ReadTimeout,
+		WriteTimeout:    mainConfig.WriteTimeout,
+		MaxRequestBytes: mainConfig.MaxRequestBytes,
+		TLSMinVersion:   tlsMinVersion,
+		TLSCipherSuites: tlsCipherSuites,
+	}
+	if mainConfig.ClientCAFile != "" {
+		clientCAs, err := webhook.LoadClientCAs(mainConfig.ClientCAFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load client CAs")
+		}
+		serverOptions.ClientCAs = clientCAs
+		serverOptions.ClientAllowedNames = mainConfig.ClientAllowedNames
+	}
 
-	// Start up the watcher, and get configMaps
-	watcher, err := watcherpkg.NewK8sWatcher(mainConfig.ConfigmapNamespace, mainConfig.ConfigMapName, mainConfig.MasterURL, mainConfig.KubeConfig)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    mainConfig.TracingExporter,
+		Endpoint:    mainConfig.TracingEndpoint,
+		Insecure:    mainConfig.TracingInsecure,
+		SampleRatio: mainConfig.TracingSampleRatio,
+	})
 	if err != nil {
-		panic(err.Error())
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
 	}
+
+	// Start web server
+	webhook := webhook.NewWebhookServer()
+	webhook.DebugEndpoints = mainConfig.DebugEndpoints
+	webhook.Options = serverOptions
+	// The process exits from the SIGTERM handler, which closes the audit log itself for its records to be flushed
+	var auditor *audit.Logger
+	if mainConfig.AuditLogPath != "" {
+		auditor, err = audit.NewLogger(mainConfig.AuditLogPath, mainConfig.AuditLogMaxSize, mainConfig.AuditLogMaxBackups, mainConfig.AuditLogIncludePatch)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create audit logger")
+		}
+		webhook.Auditor = auditor
+	}
+
+	webhook.Snapshots.SetExclusions(mainConfig.ExcludeNamespaces, mainConfig.ExcludePods)
+
 	ctx := context.Background()
+	var namespaceEventChan chan watcherpkg.NamespaceEvent
+	cfmEventChan := make(chan interface{})
+	var listers []watcherpkg.SourceLister
 
-	namespaces := make(map[string]bool)
+	// The cluster is not needed when both the configs and the enabled namespaces are given locally
+	var watcher *watcherpkg.K8sWatcher
+	// watchNamespaces (re)starts the namespace watcher, events of a stopped watcher are never received
+	stopNamespaceWatcher := func() {}
+	watchNamespaces := func(label map[string]string) {
+		var nsCtx context.Context
+		nsCtx, stopNamespaceWatcher = context.WithCancel(ctx)
+		nsEvents := make(chan watcherpkg.NamespaceEvent)
+		namespaceEventChan = nsEvents
+		go runWatcher("Namespace", func() error {
+			return watcher.WatchNamespace(nsCtx, label, nsEvents)
+		})
+	}
+	offline := mainConfig.ConfigDir != "" && len(mainConfig.EnabledNamespaces) != 0 && !mainConfig.InjectionConfigCRD
+	if offline {
+		log.Info().Msg("Running without a cluster, configs and namespaces are only read locally")
+	} else {
+		// Start up the watcher, and get configMaps
+		watcher, err = watcherpkg.NewK8sWatcher(mainConfig.ConfigmapNamespace, mainConfig.ConfigMapName, mainConfig.MasterURL, mainConfig.KubeConfig)
+		if err != nil {
+			panic(err.Error())
+		}
+		webhook.Watchers = watcher
+		watcher.ResyncPeriod = mainConfig.ResyncPeriod
+		watcher.AllNamespaces = mainConfig.ConfigMapAllNamespaces
+		watcher.Decoding = mainConfig.PayloadDecoding
+	}
 
-	namespaceEventChan := make(chan watcherpkg.NamespaceEvent)
-	go func() {
-		for {
-			err = watcher.WatchNamespace(ctx, mainConfig.WebhookEnableLabel, namespaceEventChan)
+	leaderOptions := leader.Options{Enabled: mainConfig.LeaderElect && !offline}
+	var leaderClient kubernetes.Interface
+	if watcher != nil {
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to get the leader election identity")
+		}
+		leaderClient = watcher.Client()
+		leaderOptions.Namespace = watcher.Namespace
+		leaderOptions.LeaseName = mainConfig.LeaderElectLeaseName
+		leaderOptions.Identity = identity
+		leaderOptions.LeaseDuration = mainConfig.LeaseDuration
+		leaderOptions.RenewDeadline = mainConfig.RenewDeadline
+		leaderOptions.RetryPeriod = mainConfig.RetryPeriod
+	}
+	elector := leader.NewElector(leaderClient, leaderOptions)
+	webhook.Leader = elector
+
+	var recorder *events.Recorder
+	if watcher != nil && mainConfig.Events {
+		recorder = events.NewRecorder(watcher.Client(), events.Options{Burst: mainConfig.EventBurst, QPS: float32(mainConfig.EventQPS)})
+		defer recorder.Shutdown()
+		recorder.Leading = elector.IsLeader
+		watcher.Events = recorder
+		webhook.Events = recorder
+	}
+
+	if mainConfig.ConfigDir != "" {
+		dir := &watcherpkg.DirSource{Dir: mainConfig.ConfigDir, PollInterval: mainConfig.ConfigDirPollInterval, Decoding: mainConfig.PayloadDecoding}
+		listers = append(listers, dir.List)
+		go runWatcher("Directory", func() error {
+			return dir.Watch(ctx, cfmEventChan)
+		})
+	} else {
+		listers = append(listers, watcher.ListConfigMaps)
+		go runWatcher("ConfigMap", func() error {
+			return watcher.WatchConfigMap(ctx, cfmEventChan)
+		})
+	}
+
+	if mainConfig.InjectionConfigCRD {
+		dynamicClient, err := watcher.DynamicClient()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create dynamic client")
+		}
+		injectionConfigs := injectionconfig.NewController(dynamicClient, watcher.Namespace, webhook.Snapshots)
+		injectionConfigs.ResyncPeriod = mainConfig.ResyncPeriod
+		listers = append(listers, injectionConfigs.List)
+		webhook.Injections = injectionConfigs
+		go runWatcher("InjectionConfig", func() error {
+			return injectionConfigs.Watch(ctx, cfmEventChan)
+		})
+		elector.Add("injectionconfig-status", injectionConfigs.RunStatusWriter)
+		// Every replica adds the pods it injected, whether it leads or not
+		go injectionConfigs.RunInjectedWriter(ctx)
+	}
+
+	reloader := &watcherpkg.Reloader{
+		Load:         watcherpkg.MergeListers(listers...),
+		Snapshots:    webhook.Snapshots,
+		DeletePolicy: mainConfig.ConfigMapDelete,
+	}
+	if mainConfig.ConfigDir == "" {
+		// ConfigMap Events count the keys dropped by merging too
+		reloader.Loaded = watcher.ConfigsLoaded
+	}
+	webhook.Reloads = reloader
+
+	if len(mainConfig.EnabledNamespaces) != 0 {
+		for _, ns := range mainConfig.EnabledNamespaces {
+			webhook.Snapshots.SetNamespace(ns, true)
+		}
+		log.Info().Msgf("Injection is enabled for namespaces %v", mainConfig.EnabledNamespaces)
+	} else {
+		watchNamespaces(mainConfig.WebhookEnableLabel)
+	}
+
+	// The settings of the config file that are safe to change at runtime are applied again on SIGHUP
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	reloadSettings := func() {
+		next, err := mainConfig.Reload()
+		if err != nil {
+			log.Error().Msgf("Failed to reload settings, keeping the current ones: %v", err)
+			return
+		}
+		// A level changed through the lifecycle server is kept unless the file changes it too
+		if next.LogLevel != mainConfig.LogLevel {
+			setLogLevel(next.LogLevel)
+		}
+		snapshot := webhook.Snapshots.SetExclusions(next.ExcludeNamespaces, next.ExcludePods)
+		if len(mainConfig.EnabledNamespaces) == 0 && !reflect.DeepEqual(next.WebhookEnableLabel, mainConfig.WebhookEnableLabel) {
+			namespaces, err := watcher.ListNamespaces(ctx, next.WebhookEnableLabel)
 			if err != nil {
-				switch err {
-				case watcherpkg.ErrWatcheChannelClosed:
-					log.Info().Msgf("Namespace watcher got error: %s, Restart Namespace watcher", err.Error())
-				default:
-					panic(err.Error())
-				}
+				log.Error().Msgf("Failed to list namespaces with the new webhook enable label, keeping %v: %v", mainConfig.WebhookEnableLabel, err)
+				next.WebhookEnableLabel = mainConfig.WebhookEnableLabel
+			} else {
+				stopNamespaceWatcher()
+				snapshot = webhook.Snapshots.SetNamespaces(namespaces)
+				watchNamespaces(next.WebhookEnableLabel)
+				log.Info().Msgf("Injection is now enabled by label %v for namespaces %v", next.WebhookEnableLabel, namespaces)
 			}
 		}
-	}()
+		if restart := mainConfig.ApplyRuntime(next); len(restart) != 0 {
+			log.Warn().Msgf("Settings %v changed but only take effect on restart", restart)
+		}
+		log.Info().Msgf("Reloaded settings (generation %d)", snapshot.Generation)
+	}
 
-	cfmEventChan := make(chan interface{})
 	go func() {
-		for {
-			err = watcher.WatchConfigMap(ctx, cfmEventChan)
-			if err != nil {
-				switch err {
-				case watcherpkg.ErrWatcheChannelClosed:
-					log.Info().Msgf("ConfigMap watcher got error: %s, Restart ConfigMap watcher", err.Error())
-				default:
-					panic(err.Error())
-				}
-			}
+		if err := elector.Run(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Failed to run leader election")
 		}
 	}()
 
+	// Events are handled as they come, a resync of the informers delivers many of them at once
 	go func() {
-		for range time.NewTicker(1 * time.Second).C {
+		for {
 			select {
 			case nsEvent := <-namespaceEventChan:
 				log.Info().Msg("Received namespace event")
 				if nsEvent.Type == watch.Added {
-					if namespaces[nsEvent.Namespace] {
+					if webhook.Snapshots.Load().Namespaces[nsEvent.Namespace] {
 						break
 					}
-					namespaces[nsEvent.Namespace] = true
-					log.Info().Msgf("Added namespace %q to namespace list: %v", nsEvent.Namespace, namespaces)
+					snapshot := webhook.Snapshots.SetNamespace(nsEvent.Namespace, true)
+					log.Info().Msgf("Added namespace %q to namespace list: %v (generation %d)", nsEvent.Namespace, snapshot.Namespaces, snapshot.Generation)
+					recorder.NamespaceInjection(nsEvent.Namespace, true, mainConfig.WebhookEnableLabel)
 				} else if nsEvent.Type == watch.Deleted {
-					delete(namespaces, nsEvent.Namespace)
-					log.Info().Msgf("Removed namespace %q from namespace list: %v", nsEvent.Namespace, namespaces)
+					if !webhook.Snapshots.Load().Namespaces[nsEvent.Namespace] {
+						break
+					}
+					snapshot := webhook.Snapshots.SetNamespace(nsEvent.Namespace, false)
+					log.Info().Msgf("Removed namespace %q from namespace list: %v (generation %d)", nsEvent.Namespace, snapshot.Namespaces, snapshot.Generation)
+					recorder.NamespaceInjection(nsEvent.Namespace, false, mainConfig.WebhookEnableLabel)
 				}
-				webhook.Namespaces = namespaces
 			case <-cfmEventChan:
-				log.Info().Msg("Received configmap event")
-				injConfigs, err := watcher.GetConfigMap(ctx)
-				if err != nil {
-					panic(err.Error())
-				}
-				log.Info().Msgf("Fetched configmap %q in namespace %q", watcher.CfmName, watcher.Namespace)
-				webhook.InjConfigs = injConfigs
+				log.Info().Msg("Received injection config event")
+				_ = reloader.Reload(ctx)
+			case <-reloadSignals:
+				log.Info().Msg("Received SIGHUP, reloading settings")
+				reloadSettings()
 			}
 		}
 	}()
@@ -130,6 +305,14 @@ func main() {
 		if err := webhook.Shutdown(); err != nil {
 			log.Fatal().Msgf("Failed to shutdown server: %v", err.Error())
 		}
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error().Msgf("Failed to flush traces: %v", err.Error())
+		}
+		if auditor != nil {
+			if err := auditor.Close(); err != nil {
+				log.Error().Msgf("Failed to close audit log: %v", err.Error())
+			}
+		}
 		os.Exit(0)
 	}()
 
@@ -146,3 +329,23 @@ func main() {
 	}
 	log.Info().Msgf("Started lifecycle server on port %v", mainConfig.LifecyclePort)
 }
+
+// runWatcher keeps a watcher running, restarting it with exponential backoff whenever its informer cache
+// cannot be synced. The backoff starts over once the watcher ran for longer than its cap.
+func runWatcher(name string, run func() error) {
+	initial := wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: 2 * time.Minute}
+	backoff := initial
+	for {
+		started := time.Now()
+		err := run()
+		if err == nil {
+			return
+		}
+		if time.Since(started) > initial.Cap {
+			backoff = initial
+		}
+		delay := backoff.Step()
+		log.Error().Msgf("%s watcher got error: %s, restarting in %s", name, err.Error(), delay)
+		time.Sleep(delay)
+	}
+}
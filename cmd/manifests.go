@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// filesFlag is a flag that can be given several times, each time naming a file or - for stdin
+type filesFlag []string
+
+func (f *filesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *filesFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
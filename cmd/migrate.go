@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/ghodss/yaml"
+)
+
+const migrateConfigUsage = `Usage: k8s-injector migrate-config [-f FILE] [--to VERSION]
+
+Rewrites the injection config payloads of a ConfigMap, or of a List of ConfigMaps, to another payload version
+and prints the result. Payloads already in that version are left as they are. For example:
+
+  kubectl -n kube-system get configmap k8s-injector -o yaml | k8s-injector migrate-config | kubectl apply -f -
+
+`
+
+// migrateConfig is the migrate-config command
+func migrateConfig(args []string) error {
+	fs := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	file := fs.String("f", "-", "The ConfigMap manifest to migrate, - for stdin")
+	to := fs.String("to", config.V1alpha1, "The payload apiVersion to migrate to, \"\" for unversioned payloads")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateConfigUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if _, err := config.MarshalInjectionConfig(&config.InjectionConfig{}, *to); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	manifest, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(manifest, &obj); err != nil {
+		return fmt.Errorf("could not read manifest: %v", err)
+	}
+
+	configMaps := []interface{}{obj}
+	if obj["kind"] == "List" {
+		configMaps, _ = obj["items"].([]interface{})
+	}
+	for _, item := range configMaps {
+		cfm, _ := item.(map[string]interface{})
+		if err := migrateConfigMap(cfm, *to); err != nil {
+			return err
+		}
+	}
+
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// migrateConfigMap rewrites the payloads of cfm that are not of version yet. Payloads are loaded strictly,
+// so that no field is dropped by the migration.
+func migrateConfigMap(cfm map[string]interface{}, version string) error {
+	if cfm == nil || cfm["kind"] != "ConfigMap" {
+		return fmt.Errorf("manifest is not a ConfigMap or a List of ConfigMaps")
+	}
+	meta, _ := cfm["metadata"].(map[string]interface{})
+	name := fmt.Sprintf("%v/%v", meta["namespace"], meta["name"])
+	data, _ := cfm["data"].(map[string]interface{})
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		payload, ok := data[key].(string)
+		if !ok {
+			return fmt.Errorf("ConfigMap %s: key %q does not hold a string", name, key)
+		}
+		current, err := config.PayloadVersion([]byte(payload))
+		if err != nil {
+			return fmt.Errorf("ConfigMap %s: key %q: %v", name, key, err)
+		}
+		if current == version {
+			fmt.Fprintf(os.Stderr, "ConfigMap %s: key %q is already %s\n", name, key, versionName(version))
+			continue
+		}
+		inj, err := config.LoadInjectionConfigStrict([]byte(payload))
+		if err != nil {
+			return fmt.Errorf("ConfigMap %s: key %q: %v", name, key, err)
+		}
+		migrated, err := config.MarshalInjectionConfig(inj, version)
+		if err != nil {
+			return err
+		}
+		data[key] = string(migrated)
+		fmt.Fprintf(os.Stderr, "ConfigMap %s: migrated key %q from %s to %s\n", name, key, versionName(current), versionName(version))
+	}
+	return nil
+}
+
+func versionName(version string) string {
+	if version == config.Unversioned {
+		return "unversioned"
+	}
+	return version
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/certs"
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	injectpkg "github.com/dungdev1/k8s-injector/pkg/inject"
+	"github.com/dungdev1/k8s-injector/pkg/install"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const renderManifestsUsage = `Usage: k8s-injector render-manifests --image IMAGE [--namespace NAMESPACE] [--values FILE] [flags]
+
+Prints the objects that install the injector: its ServiceAccount, Role, ClusterRole and their bindings, the
+Secret of a newly generated serving certificate, its Deployment and Service, and the webhook configurations with
+the matching caBundle. The injector is configured with its own defaults for the ports, the certificate files,
+the webhook enable label and the ConfigMap name, unless they are given. Flags can also be given in the YAML file
+of --values, keyed by flag name, the command line wins. The key of a generated CA is not kept: to render again
+with the same caBundle, create a CA with gen-certs and pass it with --ca-cert and --ca-key. The InjectionConfig
+CRD of config/injectionconfig-crd.yaml is installed separately. For example:
+
+  k8s-injector render-manifests --image registry.example.com/k8s-injector:v1 | kubectl apply -f -
+
+Namespaces are injected once labelled with the webhook enable label, such as:
+
+  kubectl label namespace default k8s-injection=enabled
+
+`
+
+// renderManifests is the render-manifests command
+func renderManifests(args []string) error {
+	fs := flag.NewFlagSet("render-manifests", flag.ContinueOnError)
+	values := fs.String("values", "", "YAML file of flags of this command, keyed by flag name")
+	name := fs.String("name", "k8s-injector", "The name of the objects, and of the Service the certificate is for")
+	namespace := fs.String("namespace", "kube-system", "The namespace to install the injector in")
+	image := fs.String("image", "", "The image of the injector")
+	pullPolicy := fs.String("image-pull-policy", string(corev1.PullIfNotPresent), "The pull policy of the image, Always, IfNotPresent or Never")
+	replicas := fs.Int("replicas", 1, "The number of replicas of the injector")
+	configMapName := fs.String("configmap-name", "k8s-injector", "Name of ConfigMap to load Injection Config from")
+	webhookEnableLabel := config.NewMapStringStringFlag()
+	fs.Var(&webhookEnableLabel, "webhook-enable-label", fmt.Sprintf("Label pair used to enable this webhook on namespace (default %s=%s)", config.WebhookEnableLabelKeyDefault, config.WebhookEnableLabelValueDefault))
+	tlsPort := fs.Int("tls-port", config.TLSPortDefault, "Webhook server port of the injector")
+	lifecyclePort := fs.Int("lifecycle-port", config.LifecyclePortDefault, "Health checking port of the injector")
+	certFile := fs.String("tls-cert-file", config.TLSCertFileDefault, "File the serving certificate is mounted as")
+	keyFile := fs.String("tls-key-file", config.TLSKeyFileDefault, "File the serving key is mounted as, in the directory of tls-cert-file")
+	allNamespaces := fs.Bool("configmap-all-namespaces", false, "Merge the ConfigMaps labelled app=k8s-injector of every namespace, and grant the injector to list them")
+	crd := fs.Bool("injection-config-crd", false, "Load InjectionConfig custom resources, grant the injector to list them and validate them")
+	validating := fs.Bool("validating-webhook", true, "Add the ValidatingWebhookConfiguration that rejects invalid injection configs")
+	failurePolicy := fs.String("failure-policy", string(admissionregistrationv1.Fail), "What the API server does with pods when the mutating webhook cannot be called, Fail or Ignore")
+	injectorArgs := fs.String("args", "", "Comma-separated list of more flags of the injector, such as --log-format=json")
+	validity := fs.Duration("validity", certs.DefaultValidity, "How long the serving certificate is valid for")
+	caCertFile := fs.String("ca-cert", "", "The PEM certificate of an existing CA to sign with, keeps the caBundle of a previous install")
+	caKeyFile := fs.String("ca-key", "", "The PEM key of an existing CA to sign with")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), renderManifestsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *values != "" {
+		if err := config.ApplyValuesFile(fs, *values, "values"); err != nil {
+			return err
+		}
+	}
+	if *image == "" {
+		return fmt.Errorf("no --image given")
+	}
+	switch corev1.PullPolicy(*pullPolicy) {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		return fmt.Errorf("invalid image-pull-policy passed: %s Should be one of: Always, IfNotPresent, Never", *pullPolicy)
+	}
+	switch admissionregistrationv1.FailurePolicyType(*failurePolicy) {
+	case admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+	default:
+		return fmt.Errorf("invalid failure-policy passed: %s Should be one of: Fail, Ignore", *failurePolicy)
+	}
+	if *replicas < 1 {
+		return fmt.Errorf("replicas must be positive, got %d", *replicas)
+	}
+	if (*caCertFile == "") != (*caKeyFile == "") {
+		return fmt.Errorf("--ca-cert and --ca-key go together")
+	}
+
+	certOpts := certs.Options{Service: *name, Namespace: *namespace, Validity: *validity}
+	if *caCertFile != "" {
+		var err error
+		if certOpts.CACert, err = ioutil.ReadFile(*caCertFile); err != nil {
+			return err
+		}
+		if certOpts.CAKey, err = ioutil.ReadFile(*caKeyFile); err != nil {
+			return err
+		}
+	}
+	bundle, err := certs.Generate(certOpts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	opts := install.Options{
+		Name:                   *name,
+		Namespace:              *namespace,
+		Image:                  *image,
+		ImagePullPolicy:        corev1.PullPolicy(*pullPolicy),
+		Replicas:               int32(*replicas),
+		ConfigMapName:          *configMapName,
+		WebhookEnableLabel:     webhookEnableLabel.ToMapStringString(),
+		TLSPort:                *tlsPort,
+		LifecyclePort:          *lifecyclePort,
+		CertFile:               *certFile,
+		KeyFile:                *keyFile,
+		ConfigMapAllNamespaces: *allNamespaces,
+		InjectionConfigCRD:     *crd,
+		ValidatingWebhook:      *validating,
+		FailurePolicy:          admissionregistrationv1.FailurePolicyType(*failurePolicy),
+		Certs:                  bundle,
+	}
+	if len(opts.WebhookEnableLabel) == 0 {
+		opts.WebhookEnableLabel = map[string]string{config.WebhookEnableLabelKeyDefault: config.WebhookEnableLabelValueDefault}
+	}
+	if *injectorArgs != "" {
+		opts.Args = strings.Split(*injectorArgs, ",")
+	}
+	objs, err := install.Render(opts)
+	if err != nil {
+		return err
+	}
+
+	docs := make([]map[string]interface{}, 0, len(objs))
+	for _, obj := range objs {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		docs = append(docs, withoutEmptyFields(doc))
+	}
+	return injectpkg.WriteDocuments(os.Stdout, docs)
+}
+
+// withoutEmptyFields drops the fields of a serialized object that are only there because they are not
+// omitempty, such as metadata.creationTimestamp and status
+func withoutEmptyFields(obj map[string]interface{}) map[string]interface{} {
+	for key, value := range obj {
+		switch v := value.(type) {
+		case nil:
+			delete(obj, key)
+		case map[string]interface{}:
+			if len(withoutEmptyFields(v)) == 0 {
+				delete(obj, key)
+			}
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					withoutEmptyFields(m)
+				}
+			}
+		}
+	}
+	return obj
+}
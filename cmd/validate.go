@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dungdev1/k8s-injector/pkg/validate"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const validateConfigUsage = `Usage: k8s-injector validate-config [-f FILE]... [--dir DIR]... [--pod FILE]... [-o text|json]
+
+Checks injection configs the way the injector loads them, and exits with a nonzero status when any of them is
+invalid. Every key of the ConfigMaps and every file of the directories is loaded strictly and checked against
+its path, then every config is trial-applied on its own and then together with the others to sample pods: the
+one the webhook trial-applies configs to and those given with --pod. Configs that add the same container,
+volume, env var, mount or port twice, or patch inside a path another config replaces, are reported as
+conflicts. For example:
+
+  k8s-injector validate-config -f config/configmap.yaml --pod deploy/pod.yaml
+
+`
+
+// Output formats of validate-config
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// validateConfig is the validate-config command
+func validateConfig(args []string) error {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	var files, dirs, pods filesFlag
+	fs.Var(&files, "f", "A manifest of injection config ConfigMaps, - for stdin. Can be given several times")
+	fs.Var(&dirs, "dir", "A directory of injection config files named like ConfigMap keys. Can be given several times")
+	fs.Var(&pods, "pod", "A Pod manifest or AdmissionReview to trial-apply the configs to. Can be given several times")
+	output := fs.String("o", outputText, "The output format, text or json")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), validateConfigUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *output != outputText && *output != outputJSON {
+		return fmt.Errorf("invalid output passed: %s Should be one of: %s, %s", *output, outputText, outputJSON)
+	}
+	if len(files) == 0 && len(dirs) == 0 {
+		return fmt.Errorf("no -f or --dir given")
+	}
+	// Every problem is part of the report, the logs of the injector would only repeat them
+	log.Logger = zerolog.Nop()
+
+	ctx := context.Background()
+	sources, err := validate.LoadSources(ctx, files, dirs)
+	if err != nil {
+		return err
+	}
+	samples, err := validate.SamplePods(pods)
+	if err != nil {
+		return err
+	}
+
+	report := validate.Sources(ctx, sources, samples)
+	if *output == outputJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+	} else {
+		validate.WriteReport(os.Stdout, report)
+	}
+	if !report.Valid {
+		return fmt.Errorf("the injection configs are not valid")
+	}
+	return nil
+}
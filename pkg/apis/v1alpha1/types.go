@@ -0,0 +1,69 @@
+// Package v1alpha1 holds the InjectionConfig custom resource, an alternative to path-keyed ConfigMap entries
+package v1alpha1
+
+import (
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "k8s-injector.io"
+	Version   = "v1alpha1"
+	Kind      = "InjectionConfig"
+	ListKind  = "InjectionConfigList"
+)
+
+// Resource is the InjectionConfig resource served by the API server once the CRD is installed
+var Resource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "injectionconfigs"}
+
+// Condition types written to the status of an InjectionConfig
+const (
+	// ConditionValid is true once the spec could be decoded and validated
+	ConditionValid = "Valid"
+	// ConditionActive is true while the config is part of the snapshot served to admissions
+	ConditionActive = "Active"
+	// ConditionFieldsIgnored is true while the object sets fields only honored in the injector's namespace
+	ConditionFieldsIgnored = "FieldsIgnored"
+)
+
+// InjectionConfig injects one config at a JSON patch path of the selected pods
+type InjectionConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InjectionConfigSpec   `json:"spec"`
+	Status InjectionConfigStatus `json:"status,omitempty"`
+}
+
+// InjectionConfigSpec mirrors config.InjectionConfig, plus where and to which pods it applies
+type InjectionConfigSpec struct {
+	// Path is the JSON patch path the config is added at, the same as a ConfigMap key with dots replaced by slashes
+	Path string `json:"path"`
+	// Priority orders configs defining the same path, the highest wins. Only objects in the injector's own
+	// namespace have one, the others have the lowest priority.
+	Priority int `json:"priority,omitempty"`
+	// Namespaces the config applies to, every enabled namespace when empty. Only objects in the injector's own
+	// namespace choose them, the others apply to their own namespace.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// PodSelector selects the pods the config applies to, every pod when empty
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	config.InjectionConfig `json:",inline"`
+}
+
+// InjectionConfigStatus is written by the injector
+type InjectionConfigStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	LastError          string             `json:"lastError,omitempty"`
+	// InjectedPods is the number of pods the config was injected into, summed over every replica
+	InjectedPods int64 `json:"injectedPods,omitempty"`
+}
+
+// DeepCopy copies the status, conditions included
+func (in *InjectionConfigStatus) DeepCopy() *InjectionConfigStatus {
+	out := *in
+	out.Conditions = append([]metav1.Condition(nil), in.Conditions...)
+	return &out
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StdoutPath selects stdout as the audit sink instead of a file
+const StdoutPath = "-"
+
+// Record is a single line of the audit trail, describing one admission decision
+type Record struct {
+	Time               time.Time       `json:"time"`
+	UID                string          `json:"uid"`
+	Operation          string          `json:"operation"`
+	Namespace          string          `json:"namespace"`
+	Pod                string          `json:"pod"`
+	SnapshotGeneration uint64          `json:"snapshotGeneration"`
+	ConfigsConsidered  []string        `json:"configsConsidered"`
+	ConfigsApplied     []string        `json:"configsApplied"`
+	Patch              json.RawMessage `json:"patch,omitempty"`
+	PatchSHA256        string          `json:"patchSHA256,omitempty"`
+	Warnings           []string        `json:"warnings,omitempty"`
+	Allowed            bool            `json:"allowed"`
+	DenyReason         string          `json:"denyReason,omitempty"`
+}
+
+// Logger writes Records as JSON lines to its sink
+type Logger struct {
+	mu           sync.Mutex
+	out          io.Writer
+	includePatch bool
+}
+
+// NewLogger creates a Logger writing to stdout when path is StdoutPath, or to a file at path that is rotated
+// once it grows beyond maxSizeMB, keeping at most maxBackups rotated files. Records hold the patch itself only
+// when includePatch is set, its hash otherwise.
+func NewLogger(path string, maxSizeMB int, maxBackups int, includePatch bool) (*Logger, error) {
+	if path == StdoutPath {
+		return &Logger{out: os.Stdout, includePatch: includePatch}, nil
+	}
+	out, err := newRotatingFile(path, int64(maxSizeMB)<<20, maxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log %q: %v", path, err)
+	}
+	return &Logger{out: out, includePatch: includePatch}, nil
+}
+
+// Log writes the record of an admission outcome
+func (l *Logger) Log(outcome *controller.AdmissionOutcome) error {
+	line, err := json.Marshal(l.NewRecord(outcome))
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit record: %v", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.out.Write(line)
+	return err
+}
+
+// Close closes the underlying file, if any, once the record being written is
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.out.(io.Closer); ok && l.out != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// NewRecord builds the audit Record of an admission outcome
+func (l *Logger) NewRecord(outcome *controller.AdmissionOutcome) *Record {
+	req, resp := outcome.Request, outcome.Response
+	record := &Record{
+		Time:               time.Now().UTC(),
+		UID:                string(req.UID),
+		Operation:          string(req.Operation),
+		Namespace:          req.Namespace,
+		Pod:                podName(req.Object.Raw),
+		SnapshotGeneration: outcome.Generation,
+		ConfigsConsidered:  []string{},
+		ConfigsApplied:     []string{},
+		Warnings:           resp.Warnings,
+		Allowed:            resp.Allowed,
+	}
+	if outcome.Result != nil {
+		for _, decision := range outcome.Result.Decisions {
+			record.ConfigsConsidered = append(record.ConfigsConsidered, decision.Key)
+			if decision.Applied {
+				record.ConfigsApplied = append(record.ConfigsApplied, decision.Key)
+			}
+		}
+	}
+	if len(resp.Patch) != 0 {
+		sum := sha256.Sum256(resp.Patch)
+		record.PatchSHA256 = hex.EncodeToString(sum[:])
+		if l.includePatch {
+			record.Patch = json.RawMessage(resp.Patch)
+		}
+	}
+	if !resp.Allowed && resp.Result != nil {
+		record.DenyReason = resp.Result.Message
+	}
+	return record
+}
+
+// podName returns the name of the pod, or its generateName when the name is not assigned yet
+func podName(raw []byte) string {
+	obj := struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ""
+	}
+	if obj.Metadata.Name != "" {
+		return obj.Metadata.Name
+	}
+	return obj.Metadata.GenerateName
+}
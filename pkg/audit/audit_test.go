@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newOutcome(patch []byte, denyReason string) *controller.AdmissionOutcome {
+	resp := &admissionv1.AdmissionResponse{UID: "uid-1", Allowed: denyReason == "", Patch: patch, Warnings: []string{"w"}}
+	if denyReason != "" {
+		resp.Result = &metav1.Status{Message: denyReason}
+	}
+	return &controller.AdmissionOutcome{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid-1",
+			Namespace: "dbservice",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"generateName":"pod-with-defaults-"}}`)},
+		},
+		Response: resp,
+		Result: &controller.AdmitResult{
+			Decisions: []controller.ConfigDecision{
+				{Key: "/spec/containers/-", Applied: true},
+				{Key: "/spec/hostPID", Applied: false, Reason: "config sets no fields"},
+			},
+		},
+		Generation: 7,
+	}
+}
+
+func TestLogger_NewRecord(t *testing.T) {
+	l := &Logger{}
+	record := l.NewRecord(newOutcome([]byte(`[{"op":"add"}]`), ""))
+
+	if record.Pod != "pod-with-defaults-" || record.Namespace != "dbservice" || record.UID != "uid-1" {
+		t.Errorf("unexpected record identity: %+v", record)
+	}
+	if len(record.ConfigsConsidered) != 2 || len(record.ConfigsApplied) != 1 || record.ConfigsApplied[0] != "/spec/containers/-" {
+		t.Errorf("unexpected configs: considered %v, applied %v", record.ConfigsConsidered, record.ConfigsApplied)
+	}
+	if record.PatchSHA256 == "" || record.Patch != nil {
+		t.Errorf("expected only the patch hash, got patch %q and hash %q", record.Patch, record.PatchSHA256)
+	}
+	if record.SnapshotGeneration != 7 || !record.Allowed || record.DenyReason != "" {
+		t.Errorf("unexpected decision: %+v", record)
+	}
+
+	l.includePatch = true
+	if record := l.NewRecord(newOutcome([]byte(`[{"op":"add"}]`), "")); string(record.Patch) != `[{"op":"add"}]` {
+		t.Errorf("expected the full patch, got %q", record.Patch)
+	}
+
+	if record := l.NewRecord(newOutcome(nil, "boom")); record.Allowed || record.DenyReason != "boom" {
+		t.Errorf("expected a denied record, got %+v", record)
+	}
+}
+
+func TestLogger_RotatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := NewLogger(path, 0, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotate after every record
+	l.out.(*rotatingFile).maxSize = 1
+	// Files that were not rotated by the logger are never pruned
+	unrelated := []string{path + ".conf", path + ".tmp"}
+	for _, name := range unrelated {
+		if err := os.WriteFile(name, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := l.Log(newOutcome(nil, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := filepath.Glob(path + ".2*")
+	if len(backups) != 2 {
+		t.Errorf("got %d rotated files; want 2", len(backups))
+	}
+	for _, name := range unrelated {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s should not be pruned: %v", name, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Errorf("line %q is not a JSON record: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("current audit log has %d lines; want 1", lines)
+	}
+}
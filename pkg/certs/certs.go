@@ -0,0 +1,194 @@
+// Package certs generates the CA and the serving certificate the API server needs to call the webhook
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultValidity is how long certificates are valid for by default, a year like the cfssl profile it
+	// replaces
+	DefaultValidity = 365 * 24 * time.Hour
+	// DefaultCAValidity is how long generated CAs are valid for by default, long enough to sign several
+	// serving certificates in a row
+	DefaultCAValidity = 5 * DefaultValidity
+	// caCommonName is the subject of generated CAs
+	caCommonName = "Admission Controller Webhook CA"
+	keySize      = 2048
+)
+
+// Options configures the certificates Generate creates
+type Options struct {
+	// Service and Namespace name the Service of the webhook, the serving certificate is for
+	// <Service>.<Namespace>.svc
+	Service   string
+	Namespace string
+	// SANs are more names of the serving certificate, IP addresses become IP SANs
+	SANs []string
+	// Validity of the serving certificate, DefaultValidity when zero
+	Validity time.Duration
+	// CAValidity is the validity of a generated CA, DefaultCAValidity when zero
+	CAValidity time.Duration
+	// CACert and CAKey are the PEM of an existing CA to sign with, a CA is generated when they are empty
+	CACert []byte
+	CAKey  []byte
+}
+
+// Bundle holds PEM encoded certificates and keys. CAKey is empty when the CA was given.
+type Bundle struct {
+	CACert []byte
+	CAKey  []byte
+	Cert   []byte
+	Key    []byte
+}
+
+// Secret returns the serving certificate of b as a kubernetes.io/tls Secret, along with the CA certificate
+func (b *Bundle) Secret(name, namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       b.Cert,
+			corev1.TLSPrivateKeyKey: b.Key,
+			"ca.crt":                b.CACert,
+		},
+	}
+}
+
+// ServiceNames returns the DNS names the API server may use to call Service in Namespace, the one it uses
+// for webhooks being <Service>.<Namespace>.svc
+func ServiceNames(service, namespace string) []string {
+	return []string{
+		service + "." + namespace + ".svc",
+		service + "." + namespace,
+		service,
+		service + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+// Generate creates a serving certificate for the Service of opts, signed by the CA of opts or by a new one
+func Generate(opts Options, now time.Time) (*Bundle, error) {
+	if opts.Service == "" || opts.Namespace == "" {
+		return nil, fmt.Errorf("a service and a namespace are needed")
+	}
+	if opts.Validity == 0 {
+		opts.Validity = DefaultValidity
+	}
+	if opts.CAValidity == 0 {
+		opts.CAValidity = DefaultCAValidity
+	}
+
+	bundle := &Bundle{}
+	var ca *x509.Certificate
+	var caKey *rsa.PrivateKey
+	var err error
+	if len(opts.CACert) != 0 || len(opts.CAKey) != 0 {
+		ca, caKey, err = parseCA(opts.CACert, opts.CAKey)
+		if err != nil {
+			return nil, err
+		}
+		bundle.CACert = opts.CACert
+	} else {
+		caKey, err = rsa.GenerateKey(rand.Reader, keySize)
+		if err != nil {
+			return nil, err
+		}
+		tmpl := &x509.Certificate{
+			Subject:               pkix.Name{CommonName: caCommonName},
+			NotBefore:             now.Add(-time.Minute),
+			NotAfter:              now.Add(opts.CAValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		ca, bundle.CACert, err = sign(tmpl, tmpl, &caKey.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+		bundle.CAKey = encodeKey(caKey)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, err
+	}
+	names := ServiceNames(opts.Service, opts.Namespace)
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: names[0]},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(opts.Validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    names,
+	}
+	for _, san := range opts.SANs {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	if tmpl.NotAfter.After(ca.NotAfter) {
+		return nil, fmt.Errorf("the certificate would outlive its CA, which expires on %s", ca.NotAfter.Format(time.RFC3339))
+	}
+	if _, bundle.Cert, err = sign(tmpl, ca, &key.PublicKey, caKey); err != nil {
+		return nil, err
+	}
+	bundle.Key = encodeKey(key)
+	return bundle, nil
+}
+
+// sign issues tmpl for pub, signed by parent and its key, and returns it parsed and PEM encoded
+func sign(tmpl, parent *x509.Certificate, pub *rsa.PublicKey, parentKey *rsa.PrivateKey) (*x509.Certificate, []byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func encodeKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// parseCA loads an RSA CA from PEM
+func parseCA(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load CA: %v", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load CA: %v", err)
+	}
+	if !ca.IsCA {
+		return nil, nil, fmt.Errorf("could not load CA: the certificate of %s is not a CA", ca.Subject.CommonName)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("could not load CA: only RSA keys are supported")
+	}
+	return ca, key, nil
+}
@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseCert(t *testing.T, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM block in %q", data)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func verify(t *testing.T, bundle *Bundle, name string, at time.Time) error {
+	if _, err := tls.X509KeyPair(bundle.Cert, bundle.Key); err != nil {
+		t.Fatalf("certificate and key do not match: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle.CACert) {
+		t.Fatalf("no CA in %q", bundle.CACert)
+	}
+	_, err := parseCert(t, bundle.Cert).Verify(x509.VerifyOptions{
+		DNSName:     name,
+		Roots:       roots,
+		CurrentTime: at,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+func TestGenerate(t *testing.T) {
+	now := time.Now()
+	bundle, err := Generate(Options{Service: "k8s-injector", Namespace: "injector", SANs: []string{"injector.example.com", "10.0.0.1"}, Validity: time.Hour}, now)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if len(bundle.CAKey) == 0 {
+		t.Errorf("a generated CA should come with its key")
+	}
+	for _, name := range []string{"k8s-injector.injector.svc", "injector.example.com", "10.0.0.1"} {
+		if err := verify(t, bundle, name, now); err != nil {
+			t.Errorf("the certificate is not valid for %s: %v", name, err)
+		}
+	}
+	if err := verify(t, bundle, "k8s-injector.kube-system.svc", now); err == nil {
+		t.Errorf("the certificate should not be valid for another namespace")
+	}
+	if err := verify(t, bundle, "k8s-injector.injector.svc", now.Add(2*time.Hour)); err == nil {
+		t.Errorf("the certificate should have expired")
+	}
+	if cn := parseCert(t, bundle.Cert).Subject.CommonName; cn != "k8s-injector.injector.svc" {
+		t.Errorf("common name = %q; want the service name", cn)
+	}
+
+	rotated, err := Generate(Options{Service: "k8s-injector", Namespace: "injector", CACert: bundle.CACert, CAKey: bundle.CAKey}, now)
+	if err != nil {
+		t.Fatalf("Generate() with the CA failed: %v", err)
+	}
+	if len(rotated.CAKey) != 0 || string(rotated.CACert) != string(bundle.CACert) {
+		t.Errorf("the given CA should be kept")
+	}
+	if err := verify(t, rotated, "k8s-injector.injector.svc", now); err != nil {
+		t.Errorf("the rotated certificate is not valid: %v", err)
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	now := time.Now()
+	ca, err := Generate(Options{Service: "k8s-injector", Namespace: "injector", CAValidity: time.Hour, Validity: time.Minute}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "no service", opts: Options{Namespace: "injector"}, want: "a service and a namespace are needed"},
+		{name: "not a CA", opts: Options{Service: "a", Namespace: "b", CACert: ca.Cert, CAKey: ca.Key}, want: "is not a CA"},
+		{name: "mismatched key", opts: Options{Service: "a", Namespace: "b", CACert: ca.CACert, CAKey: ca.Key}, want: "could not load CA"},
+		{name: "outlives its CA", opts: Options{Service: "a", Namespace: "b", CACert: ca.CACert, CAKey: ca.CAKey}, want: "would outlive its CA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Generate(tt.opts, now); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Generate() = %v; want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
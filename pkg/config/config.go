@@ -7,77 +7,313 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/util/homedir"
 )
 
 const (
-	lifeCyclePortConfigKey       = "LIFE_CYCLE_PORT"
-	lifecyclePortDefault         = 8000
-	tlsPortConfigKey             = "TLS_PORT"
-	tlsPortDefault               = 9443
-	tlsCertFileConfigKey         = "TLS_CERTIFICATE_FILE"
-	tlsCertFileDefault           = "/var/lib/secrets/cert.pem"
-	tlsKeyFileConfigKey          = "TLS_KEY_FILE"
-	tlsKeyFileDefault            = "/var/lib/secrets/key.pem"
-	annotationNamespaceConfigKey = "ANNOTATION_NAMESPACE"
-	annotationNamespaceDefault   = ""
-	configmapNameConfigKey       = "CONFIGMAP_NAME"
-	configmapNamespaceConfigKey  = "CONFIGMAP_NAMESPACE"
-	configmapNamespaceDefault    = ""
-	logLevelConfigKey            = "LOG_LEVEL"
-	logLevelConfigDefault        = "info"
-	kubeConfigConfigKey          = "KUBE_CONFIG"
-	masterUrlConfigKey           = "MASTER_URL"
+	lifeCyclePortConfigKey        = "LIFE_CYCLE_PORT"
+	tlsPortConfigKey              = "TLS_PORT"
+	tlsCertFileConfigKey          = "TLS_CERTIFICATE_FILE"
+	tlsKeyFileConfigKey           = "TLS_KEY_FILE"
+	annotationNamespaceConfigKey  = "ANNOTATION_NAMESPACE"
+	annotationNamespaceDefault    = ""
+	configmapNameConfigKey        = "CONFIGMAP_NAME"
+	configmapNamespaceConfigKey   = "CONFIGMAP_NAMESPACE"
+	configmapNamespaceDefault     = ""
+	logLevelConfigKey             = "LOG_LEVEL"
+	logLevelConfigDefault         = "info"
+	kubeConfigConfigKey           = "KUBE_CONFIG"
+	masterUrlConfigKey            = "MASTER_URL"
+	readTimeoutConfigKey          = "READ_TIMEOUT"
+	readTimeoutDefault            = 10 * time.Second
+	writeTimeoutConfigKey         = "WRITE_TIMEOUT"
+	writeTimeoutDefault           = 30 * time.Second
+	maxRequestBytesConfigKey      = "MAX_REQUEST_BYTES"
+	maxRequestBytesDefault        = 6 << 20
+	tlsMinVersionConfigKey        = "TLS_MIN_VERSION"
+	tlsMinVersionDefault          = "1.2"
+	tlsCipherSuitesConfigKey      = "TLS_CIPHER_SUITES"
+	auditLogPathConfigKey         = "AUDIT_LOG_PATH"
+	auditLogMaxSizeConfigKey      = "AUDIT_LOG_MAX_SIZE"
+	auditLogMaxSizeDefault        = 100
+	auditLogMaxBackupsConfigKey   = "AUDIT_LOG_MAX_BACKUPS"
+	auditLogMaxBackupsDefault     = 5
+	auditLogIncludePatchConfigKey = "AUDIT_LOG_INCLUDE_PATCH"
+	debugEndpointsConfigKey       = "DEBUG_ENDPOINTS"
+	clientCAFileConfigKey         = "CLIENT_CA_FILE"
+	clientAllowedNamesConfigKey   = "CLIENT_ALLOWED_NAMES"
+	tracingExporterConfigKey      = "TRACING_EXPORTER"
+	tracingExporterDefault        = "none"
+	tracingEndpointConfigKey      = "TRACING_ENDPOINT"
+	tracingInsecureConfigKey      = "TRACING_INSECURE"
+	tracingSampleRatioConfigKey   = "TRACING_SAMPLE_RATIO"
+	tracingSampleRatioDefault     = 1.0
+	resyncPeriodConfigKey         = "RESYNC_PERIOD"
+	resyncPeriodDefault           = 10 * time.Minute
+	configMapDeletePolicyKey      = "CONFIGMAP_DELETE_POLICY"
+	configMapDeletePolicyDefault  = ConfigMapDeleteKeep
+	configMapAllNamespacesKey     = "CONFIGMAP_ALL_NAMESPACES"
+	injectionConfigCRDKey         = "INJECTION_CONFIG_CRD"
+	configDirConfigKey            = "CONFIG_DIR"
+	configDirPollIntervalKey      = "CONFIG_DIR_POLL_INTERVAL"
+	configDirPollIntervalDefault  = 5 * time.Second
+	enabledNamespacesConfigKey    = "ENABLED_NAMESPACES"
+	leaderElectConfigKey          = "LEADER_ELECT"
+	leaderElectLeaseNameKey       = "LEADER_ELECT_LEASE_NAME"
+	leaderElectLeaseNameDefault   = "k8s-injector"
+	leaderElectLeaseDurationKey   = "LEADER_ELECT_LEASE_DURATION"
+	leaderElectLeaseDurationDef   = 15 * time.Second
+	leaderElectRenewDeadlineKey   = "LEADER_ELECT_RENEW_DEADLINE"
+	leaderElectRenewDeadlineDef   = 10 * time.Second
+	leaderElectRetryPeriodKey     = "LEADER_ELECT_RETRY_PERIOD"
+	leaderElectRetryPeriodDef     = 2 * time.Second
+	eventsConfigKey               = "EVENTS"
+	eventBurstConfigKey           = "EVENT_BURST"
+	eventBurstDefault             = 25
+	eventQPSConfigKey             = "EVENT_QPS"
+	eventQPSDefault               = 1. / 300
+	configFileConfigKey           = "CONFIG_FILE"
+	excludeNamespacesConfigKey    = "EXCLUDE_NAMESPACES"
+	excludeNamespacesDefault      = "kube-system,kube-public"
+	excludePodsConfigKey          = "EXCLUDE_PODS"
+	payloadDecodingConfigKey      = "PAYLOAD_DECODING"
+	logFormatConfigKey            = "LOG_FORMAT"
+)
+
+// Defaults the install manifests rendered by render-manifests rely on
+const (
+	LifecyclePortDefault           = 8000
+	TLSPortDefault                 = 9443
+	TLSCertFileDefault             = "/var/lib/secrets/cert.pem"
+	TLSKeyFileDefault              = "/var/lib/secrets/key.pem"
+	WebhookEnableLabelKeyDefault   = "k8s-injection"
+	WebhookEnableLabelValueDefault = "enabled"
 )
 
 type Config struct {
-	LifecyclePort       int
-	TLSPort             int
-	CertFile            string
-	KeyFile             string
-	AnnotationNamespace string
-	ConfigmapNamespace  string
-	ConfigMapName       string
-	LogLevel            string
-	KubeConfig          string
-	MasterURL           string
-	WebhookEnableLabel  map[string]string
+	LifecyclePort          int
+	TLSPort                int
+	CertFile               string
+	KeyFile                string
+	AnnotationNamespace    string
+	ConfigmapNamespace     string
+	ConfigMapName          string
+	LogLevel               string
+	LogFormat              string
+	KubeConfig             string
+	MasterURL              string
+	WebhookEnableLabel     map[string]string
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	MaxRequestBytes        int64
+	TLSMinVersion          string
+	TLSCipherSuites        []string
+	AuditLogPath           string
+	AuditLogMaxSize        int
+	AuditLogMaxBackups     int
+	AuditLogIncludePatch   bool
+	DebugEndpoints         bool
+	ClientCAFile           string
+	ClientAllowedNames     []string
+	TracingExporter        string
+	TracingEndpoint        string
+	TracingInsecure        bool
+	TracingSampleRatio     float64
+	ResyncPeriod           time.Duration
+	ConfigMapDelete        string
+	ConfigMapAllNamespaces bool
+	InjectionConfigCRD     bool
+	ConfigDir              string
+	ConfigDirPollInterval  time.Duration
+	EnabledNamespaces      []string
+	LeaderElect            bool
+	LeaderElectLeaseName   string
+	LeaseDuration          time.Duration
+	RenewDeadline          time.Duration
+	RetryPeriod            time.Duration
+	Events                 bool
+	EventBurst             int
+	EventQPS               float64
+	ConfigFile             string
+	ExcludeNamespaces      []string
+	ExcludePods            labels.Selector
+	PayloadDecoding        string
+
+	// args are the command line arguments the Config was parsed from, parsed again by Reload
+	args []string
 }
 
+const (
+	// ConfigMapDeleteKeep keeps serving the last loaded injection configs once the ConfigMap is deleted
+	ConfigMapDeleteKeep = "keep"
+	// ConfigMapDeleteDisable drops every injection config once the ConfigMap is deleted
+	ConfigMapDeleteDisable = "disable"
+)
+
 const (
 	ServiceAccountNamespaceFilePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 )
 
+// ParseCliArgs fills config from the command line flags, the env vars and the file given by --config, in this
+// order of precedence, falling back to the defaults for settings none of them sets
 func ParseCliArgs(config *Config) error {
+	if err := parseArgs(config, flag.CommandLine, os.Args[1:]); err != nil {
+		return err
+	}
+
+	config.PrintHumanConfigArgs()
+
+	return nil
+}
+
+func parseArgs(config *Config, fs *flag.FlagSet, args []string) error {
 	webhookEnableLabel := NewMapStringStringFlag()
+	var tlsCipherSuites string
+	var clientAllowedNames string
+	var enabledNamespaces string
+	var excludeNamespaces string
+	var excludePods string
 
-	flag.IntVar(&config.LifecyclePort, "lifecycle-port", getIntEnv(lifeCyclePortConfigKey, lifecyclePortDefault), "Port for health checking (http only)")
-	flag.IntVar(&config.TLSPort, "tls-port", getIntEnv(tlsPortConfigKey, tlsPortDefault), "Webhook server port for handling admission controller request (forced https)")
-	flag.StringVar(&config.CertFile, "tls-cert-file", getEnv(tlsCertFileConfigKey, tlsCertFileDefault), "File containing the x509 certificate of server")
-	flag.StringVar(&config.KeyFile, "tls-key-file", getEnv(tlsKeyFileConfigKey, tlsKeyFileDefault), "File containing the x509 private key of server")
-	flag.StringVar(&config.AnnotationNamespace, "annotation-namespace", getEnv(annotationNamespaceConfigKey, annotationNamespaceDefault), "The annotation namespace")
-	flag.StringVar(&config.ConfigmapNamespace, "configmap-namespace", getEnv(configmapNamespaceConfigKey, configmapNamespaceDefault), "Namespace to search for ConfigMap to load Injection Config from (default: current namespace")
-	flag.StringVar(&config.ConfigMapName, "configmap-name", getEnv(configmapNameConfigKey, ""), "Name of ConfigMap to load Injection Config from")
-	flag.StringVar(&config.LogLevel, "log-level", getEnv(logLevelConfigKey, logLevelConfigDefault), "Sets the log level (DEBUG, INFO, ERROR, ...)")
-	flag.StringVar(&config.KubeConfig, "kube-config", getEnv(kubeConfigConfigKey, ""), "Path contain the config for kubernetes cluster")
-	flag.StringVar(&config.MasterURL, "master-url", getEnv(masterUrlConfigKey, ""), "master url of kubernetes cluster")
-	flag.Var(&webhookEnableLabel, "webhook-enable-label", "Label pair used to enable this webhook on namespace")
-	flag.Parse()
+	fs.IntVar(&config.LifecyclePort, "lifecycle-port", getIntEnv(lifeCyclePortConfigKey, LifecyclePortDefault), "Port for health checking (http only)")
+	fs.IntVar(&config.TLSPort, "tls-port", getIntEnv(tlsPortConfigKey, TLSPortDefault), "Webhook server port for handling admission controller request (forced https)")
+	fs.StringVar(&config.CertFile, "tls-cert-file", getEnv(tlsCertFileConfigKey, TLSCertFileDefault), "File containing the x509 certificate of server")
+	fs.StringVar(&config.KeyFile, "tls-key-file", getEnv(tlsKeyFileConfigKey, TLSKeyFileDefault), "File containing the x509 private key of server")
+	fs.StringVar(&config.AnnotationNamespace, "annotation-namespace", getEnv(annotationNamespaceConfigKey, annotationNamespaceDefault), "The annotation namespace")
+	fs.StringVar(&config.ConfigmapNamespace, "configmap-namespace", getEnv(configmapNamespaceConfigKey, configmapNamespaceDefault), "Namespace to search for ConfigMap to load Injection Config from (default: current namespace")
+	fs.StringVar(&config.ConfigMapName, "configmap-name", getEnv(configmapNameConfigKey, ""), "Name of ConfigMap to load Injection Config from")
+	fs.StringVar(&config.LogLevel, "log-level", getEnv(logLevelConfigKey, logLevelConfigDefault), "Sets the log level (TRACE, DEBUG, INFO, WARN, ERROR)")
+	fs.StringVar(&config.LogFormat, "log-format", getEnv(logFormatConfigKey, LogFormatConsole), "Format of the log lines: console for humans, json for log collectors")
+	fs.StringVar(&config.KubeConfig, "kube-config", getEnv(kubeConfigConfigKey, ""), "Path contain the config for kubernetes cluster")
+	fs.StringVar(&config.MasterURL, "master-url", getEnv(masterUrlConfigKey, ""), "master url of kubernetes cluster")
+	fs.Var(&webhookEnableLabel, "webhook-enable-label", "Label pair used to enable this webhook on namespace")
+	fs.DurationVar(&config.ReadTimeout, "read-timeout", getDurationEnv(readTimeoutConfigKey, readTimeoutDefault), "Maximum duration for reading an entire request, including the body")
+	fs.DurationVar(&config.WriteTimeout, "write-timeout", getDurationEnv(writeTimeoutConfigKey, writeTimeoutDefault), "Maximum duration before timing out writes of the response")
+	fs.Int64Var(&config.MaxRequestBytes, "max-request-bytes", int64(getIntEnv(maxRequestBytesConfigKey, maxRequestBytesDefault)), "Maximum size in bytes of an AdmissionReview request")
+	fs.StringVar(&config.TLSMinVersion, "tls-min-version", getEnv(tlsMinVersionConfigKey, tlsMinVersionDefault), "Minimum TLS version accepted by the webhook server (1.0, 1.1, 1.2, 1.3)")
+	fs.StringVar(&tlsCipherSuites, "tls-cipher-suites", getEnv(tlsCipherSuitesConfigKey, ""), "Comma-separated list of TLS cipher suites for TLS 1.2 and below (default: Go defaults)")
+	fs.StringVar(&config.AuditLogPath, "audit-log-path", getEnv(auditLogPathConfigKey, ""), "File to write the JSON-lines audit log of admission decisions to, \"-\" for stdout (default: disabled)")
+	fs.IntVar(&config.AuditLogMaxSize, "audit-log-max-size", getIntEnv(auditLogMaxSizeConfigKey, auditLogMaxSizeDefault), "Size in megabytes after which the audit log file is rotated")
+	fs.IntVar(&config.AuditLogMaxBackups, "audit-log-max-backups", getIntEnv(auditLogMaxBackupsConfigKey, auditLogMaxBackupsDefault), "Number of rotated audit log files to keep, 0 keeps all of them")
+	fs.BoolVar(&config.AuditLogIncludePatch, "audit-log-include-patch", getBoolEnv(auditLogIncludePatchConfigKey, false), "Write the full JSON patch to the audit log instead of only its SHA-256 hash")
+	fs.BoolVar(&config.DebugEndpoints, "debug-endpoints", getBoolEnv(debugEndpointsConfigKey, false), "Serve the debug endpoints, the config dump, the mutation preview and setting the log level, on the lifecycle port")
+	fs.StringVar(&config.ClientCAFile, "client-ca-file", getEnv(clientCAFileConfigKey, ""), "File containing the CA bundle used to verify client certificates on the webhook port, enables mTLS")
+	fs.StringVar(&clientAllowedNames, "client-allowed-names", getEnv(clientAllowedNamesConfigKey, ""), "Comma-separated list of client certificate CNs or SANs allowed to call the webhook (default: any certificate signed by the client CA)")
+	fs.StringVar(&config.TracingExporter, "tracing-exporter", getEnv(tracingExporterConfigKey, tracingExporterDefault), "Where to export OpenTelemetry traces to (none, otlp, stdout)")
+	fs.StringVar(&config.TracingEndpoint, "tracing-endpoint", getEnv(tracingEndpointConfigKey, ""), "host:port of the OTLP/HTTP collector (default: localhost:4318)")
+	fs.BoolVar(&config.TracingInsecure, "tracing-insecure", getBoolEnv(tracingInsecureConfigKey, false), "Export traces to the OTLP collector over plain HTTP")
+	fs.Float64Var(&config.TracingSampleRatio, "tracing-sample-ratio", getFloatEnv(tracingSampleRatioConfigKey, tracingSampleRatioDefault), "Fraction of admissions to trace, between 0 and 1")
+	fs.DurationVar(&config.ResyncPeriod, "resync-period", getDurationEnv(resyncPeriodConfigKey, resyncPeriodDefault), "How often the namespace and ConfigMap informers replay their whole cache")
+	fs.StringVar(&config.ConfigMapDelete, "configmap-delete-policy", getEnv(configMapDeletePolicyKey, configMapDeletePolicyDefault), "What a deleted ConfigMap means: keep the last loaded configs, or disable injection")
+	fs.BoolVar(&config.ConfigMapAllNamespaces, "configmap-all-namespaces", getBoolEnv(configMapAllNamespacesKey, false), "Merge the ConfigMaps labelled app=k8s-injector of every namespace, not only of configmap-namespace, those of other namespaces only apply to their own pods")
+	fs.BoolVar(&config.InjectionConfigCRD, "injection-config-crd", getBoolEnv(injectionConfigCRDKey, false), "Also load InjectionConfig custom resources and reconcile their status, requires the CRD to be installed")
+	fs.StringVar(&config.ConfigDir, "config-dir", getEnv(configDirConfigKey, ""), "Load injection configs from the files of this directory, named like ConfigMap keys, instead of from ConfigMaps")
+	fs.DurationVar(&config.ConfigDirPollInterval, "config-dir-poll-interval", getDurationEnv(configDirPollIntervalKey, configDirPollIntervalDefault), "How often config-dir is checked for changes")
+	fs.StringVar(&enabledNamespaces, "enabled-namespaces", getEnv(enabledNamespacesConfigKey, ""), "Comma-separated list of namespaces to inject, instead of watching for namespaces with webhook-enable-label")
+	fs.BoolVar(&config.LeaderElect, "leader-elect", getBoolEnv(leaderElectConfigKey, true), "Only run the background loops on the replica holding the leader Lease, admissions are served by every replica")
+	fs.StringVar(&config.LeaderElectLeaseName, "leader-elect-lease-name", getEnv(leaderElectLeaseNameKey, leaderElectLeaseNameDefault), "Name of the leader Lease, created in configmap-namespace")
+	fs.DurationVar(&config.LeaseDuration, "leader-elect-lease-duration", getDurationEnv(leaderElectLeaseDurationKey, leaderElectLeaseDurationDef), "How long other replicas wait before taking over a Lease that was not renewed")
+	fs.DurationVar(&config.RenewDeadline, "leader-elect-renew-deadline", getDurationEnv(leaderElectRenewDeadlineKey, leaderElectRenewDeadlineDef), "How long the leader keeps retrying to renew the Lease before giving it up")
+	fs.DurationVar(&config.RetryPeriod, "leader-elect-retry-period", getDurationEnv(leaderElectRetryPeriodKey, leaderElectRetryPeriodDef), "How long to wait between two attempts to acquire or renew the Lease")
+	fs.BoolVar(&config.Events, "events", getBoolEnv(eventsConfigKey, true), "Record Kubernetes Events on ConfigMaps, Namespaces and workloads about injection outcomes")
+	fs.IntVar(&config.EventBurst, "event-burst", getIntEnv(eventBurstConfigKey, eventBurstDefault), "Number of Events recorded at once for a single object before they are rate-limited")
+	fs.Float64Var(&config.EventQPS, "event-qps", getFloatEnv(eventQPSConfigKey, eventQPSDefault), "Events per second recorded for a single object once event-burst is used up")
+	fs.StringVar(&config.ConfigFile, "config", getEnv(configFileConfigKey, ""), "YAML file of settings keyed by flag name, used for the settings no flag or env var sets, reloaded on SIGHUP")
+	fs.StringVar(&excludeNamespaces, "exclude-namespaces", getEnv(excludeNamespacesConfigKey, excludeNamespacesDefault), "Comma-separated list of namespaces that are never injected")
+	fs.StringVar(&excludePods, "exclude-pods", getEnv(excludePodsConfigKey, ""), "Label selector of the pods that are never injected, such as tier=infra,!sidecar (default: none)")
+	fs.StringVar(&config.PayloadDecoding, "payload-decoding", getEnv(payloadDecodingConfigKey, DecodingStrict), "How injection config payloads with unknown fields are loaded: strict rejects them, lenient ignores the fields with a warning")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	config.args = args
+	if config.ConfigFile != "" {
+		if err := applyConfigFile(fs, config.ConfigFile); err != nil {
+			return err
+		}
+	}
+
+	if tlsCipherSuites != "" {
+		config.TLSCipherSuites = strings.Split(tlsCipherSuites, ",")
+	}
+	if clientAllowedNames != "" {
+		config.ClientAllowedNames = strings.Split(clientAllowedNames, ",")
+	}
+	if enabledNamespaces != "" {
+		config.EnabledNamespaces = strings.Split(enabledNamespaces, ",")
+	}
+	if excludeNamespaces != "" {
+		config.ExcludeNamespaces = strings.Split(excludeNamespaces, ",")
+	}
+	selector, err := labels.Parse(excludePods)
+	if err != nil {
+		return fmt.Errorf("invalid exclude-pods passed: %v", err)
+	}
+	if !selector.Empty() {
+		config.ExcludePods = selector
+	}
+	switch strings.ToLower(config.TracingExporter) {
+	case "none":
+	case "otlp":
+	case "stdout":
+	default:
+		return fmt.Errorf("invalid tracing-exporter passed: %s Should be one of: none, otlp, stdout", config.TracingExporter)
+	}
+	config.TracingExporter = strings.ToLower(config.TracingExporter)
+	switch strings.ToLower(config.ConfigMapDelete) {
+	case ConfigMapDeleteKeep:
+	case ConfigMapDeleteDisable:
+	default:
+		return fmt.Errorf("invalid configmap-delete-policy passed: %s Should be one of: keep, disable", config.ConfigMapDelete)
+	}
+	config.ConfigMapDelete = strings.ToLower(config.ConfigMapDelete)
+	switch strings.ToLower(config.PayloadDecoding) {
+	case DecodingStrict:
+	case DecodingLenient:
+	default:
+		return fmt.Errorf("invalid payload-decoding passed: %s Should be one of: strict, lenient", config.PayloadDecoding)
+	}
+	config.PayloadDecoding = strings.ToLower(config.PayloadDecoding)
+	if config.TracingSampleRatio < 0 || config.TracingSampleRatio > 1 {
+		return fmt.Errorf("tracing-sample-ratio must be between 0 and 1, got %v", config.TracingSampleRatio)
+	}
+	if len(config.ClientAllowedNames) != 0 && config.ClientCAFile == "" {
+		return fmt.Errorf("client-allowed-names requires client-ca-file to be set")
+	}
+	if _, err := ParseTLSVersion(config.TLSMinVersion); err != nil {
+		return err
+	}
+	if _, err := ParseTLSCipherSuites(config.TLSCipherSuites); err != nil {
+		return err
+	}
+	if config.LeaderElect && (config.RetryPeriod <= 0 || config.RenewDeadline <= config.RetryPeriod || config.LeaseDuration <= config.RenewDeadline) {
+		return fmt.Errorf("leader election timings must satisfy 0 < leader-elect-retry-period < leader-elect-renew-deadline < leader-elect-lease-duration")
+	}
+	if config.Events && (config.EventBurst <= 0 || config.EventQPS <= 0) {
+		return fmt.Errorf("event-burst and event-qps must be positive, got %d and %v", config.EventBurst, config.EventQPS)
+	}
+	if config.MaxRequestBytes <= 0 {
+		return fmt.Errorf("max-request-bytes must be positive, got %d", config.MaxRequestBytes)
+	}
 
 	config.WebhookEnableLabel = webhookEnableLabel.ToMapStringString()
 	if len(config.WebhookEnableLabel) == 0 {
-		config.WebhookEnableLabel["k8s-injection"] = "enabled"
+		config.WebhookEnableLabel[WebhookEnableLabelKeyDefault] = WebhookEnableLabelValueDefault
 	}
 
-	switch strings.ToLower(config.LogLevel) {
-	case "info":
-	case "debug":
-	case "error":
+	if _, err := ParseLogLevel(config.LogLevel); err != nil {
+		return err
+	}
+	config.LogLevel = strings.ToLower(config.LogLevel)
+	switch strings.ToLower(config.LogFormat) {
+	case LogFormatConsole:
+	case LogFormatJSON:
 	default:
-		return fmt.Errorf("invalid log-level passed: %s Should be one of: info, debug, error", config.LogLevel)
+		return fmt.Errorf("invalid log-format passed: %s Should be one of: console, json", config.LogFormat)
 	}
+	config.LogFormat = strings.ToLower(config.LogFormat)
 
 	if config.ConfigmapNamespace == "" {
 		ns, err := os.ReadFile(ServiceAccountNamespaceFilePath)
@@ -89,16 +325,14 @@ func ParseCliArgs(config *Config) error {
 		}
 	}
 
-	if config.ConfigMapName == "" {
-		return fmt.Errorf("configmap name not found, this argument is mandatory")
+	if config.ConfigMapName == "" && config.ConfigDir == "" {
+		return fmt.Errorf("configmap name not found, this argument is mandatory unless config-dir is set")
 	}
 
 	if home := homedir.HomeDir(); config.KubeConfig == "" && home != "" {
 		config.KubeConfig = filepath.Join(home, ".kube", "config")
 	}
 
-	config.PrintHumanConfigArgs()
-
 	return nil
 }
 
@@ -113,9 +347,45 @@ func (c *Config) PrintHumanConfigArgs() {
 			"\tconfigmap-name: %s\n"+
 			"\tconfigmap-namespace: %s\n"+
 			"\tlog-level: %s\n"+
+			"\tlog-format: %s\n"+
 			"\tkube-config: %s\n"+
 			"\tmaster-url: %s\n"+
-			"\twebhook-enable-label: %s\n",
+			"\twebhook-enable-label: %s\n"+
+			"\tread-timeout: %s\n"+
+			"\twrite-timeout: %s\n"+
+			"\tmax-request-bytes: %d\n"+
+			"\ttls-min-version: %s\n"+
+			"\ttls-cipher-suites: %s\n"+
+			"\taudit-log-path: %s\n"+
+			"\taudit-log-max-size: %d\n"+
+			"\taudit-log-max-backups: %d\n"+
+			"\taudit-log-include-patch: %t\n"+
+			"\tdebug-endpoints: %t\n"+
+			"\tclient-ca-file: %s\n"+
+			"\tclient-allowed-names: %s\n"+
+			"\ttracing-exporter: %s\n"+
+			"\ttracing-endpoint: %s\n"+
+			"\ttracing-insecure: %t\n"+
+			"\ttracing-sample-ratio: %v\n"+
+			"\tresync-period: %s\n"+
+			"\tconfigmap-delete-policy: %s\n"+
+			"\tconfigmap-all-namespaces: %t\n"+
+			"\tinjection-config-crd: %t\n"+
+			"\tconfig-dir: %s\n"+
+			"\tconfig-dir-poll-interval: %s\n"+
+			"\tenabled-namespaces: %s\n"+
+			"\tleader-elect: %t\n"+
+			"\tleader-elect-lease-name: %s\n"+
+			"\tleader-elect-lease-duration: %s\n"+
+			"\tleader-elect-renew-deadline: %s\n"+
+			"\tleader-elect-retry-period: %s\n"+
+			"\tevents: %t\n"+
+			"\tevent-burst: %d\n"+
+			"\tevent-qps: %v\n"+
+			"\tconfig: %s\n"+
+			"\texclude-namespaces: %s\n"+
+			"\texclude-pods: %s\n"+
+			"\tpayload-decoding: %s\n",
 		c.LifecyclePort,
 		c.TLSPort,
 		c.CertFile,
@@ -124,9 +394,45 @@ func (c *Config) PrintHumanConfigArgs() {
 		c.ConfigMapName,
 		c.ConfigmapNamespace,
 		c.LogLevel,
+		c.LogFormat,
 		c.KubeConfig,
 		c.MasterURL,
 		c.WebhookEnableLabel,
+		c.ReadTimeout,
+		c.WriteTimeout,
+		c.MaxRequestBytes,
+		c.TLSMinVersion,
+		strings.Join(c.TLSCipherSuites, ","),
+		c.AuditLogPath,
+		c.AuditLogMaxSize,
+		c.AuditLogMaxBackups,
+		c.AuditLogIncludePatch,
+		c.DebugEndpoints,
+		c.ClientCAFile,
+		strings.Join(c.ClientAllowedNames, ","),
+		c.TracingExporter,
+		c.TracingEndpoint,
+		c.TracingInsecure,
+		c.TracingSampleRatio,
+		c.ResyncPeriod,
+		c.ConfigMapDelete,
+		c.ConfigMapAllNamespaces,
+		c.InjectionConfigCRD,
+		c.ConfigDir,
+		c.ConfigDirPollInterval,
+		strings.Join(c.EnabledNamespaces, ","),
+		c.LeaderElect,
+		c.LeaderElectLeaseName,
+		c.LeaseDuration,
+		c.RenewDeadline,
+		c.RetryPeriod,
+		c.Events,
+		c.EventBurst,
+		c.EventQPS,
+		c.ConfigFile,
+		strings.Join(c.ExcludeNamespaces, ","),
+		formatSelector(c.ExcludePods),
+		c.PayloadDecoding,
 	)
 }
 
@@ -149,3 +455,36 @@ func getIntEnv(key string, fallback int) int {
 	}
 	return envIntValue
 }
+func getBoolEnv(key string, fallback bool) bool {
+	envStrValue := getEnv(key, "")
+	if envStrValue == "" {
+		return fallback
+	}
+	envBoolValue, err := strconv.ParseBool(envStrValue)
+	if err != nil {
+		panic("Env Var " + key + " must be a boolean")
+	}
+	return envBoolValue
+}
+func getFloatEnv(key string, fallback float64) float64 {
+	envStrValue := getEnv(key, "")
+	if envStrValue == "" {
+		return fallback
+	}
+	envFloatValue, err := strconv.ParseFloat(envStrValue, 64)
+	if err != nil {
+		panic("Env Var " + key + " must be a number")
+	}
+	return envFloatValue
+}
+func getDurationEnv(key string, fallback time.Duration) time.Duration {
+	envStrValue := getEnv(key, "")
+	if envStrValue == "" {
+		return fallback
+	}
+	envDurationValue, err := time.ParseDuration(envStrValue)
+	if err != nil {
+		panic("Env Var " + key + " must be a duration")
+	}
+	return envDurationValue
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Conflict is a problem between injection configs that only shows once they are injected into the same pod
+type Conflict struct {
+	Keys   []string `json:"keys"`
+	Reason string   `json:"reason"`
+}
+
+// FindConflicts reports the configs of configs, keyed by path, that get in the way of each other when applied
+// to the same pod: a config patching inside the path another config replaces, and items added to the same list
+// with the same merge key, such as two containers of the same name, by one or several configs.
+func FindConflicts(configs map[string][]ScopedConfig) []Conflict {
+	paths := make([]string, 0, len(configs))
+	for path := range configs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var conflicts []Conflict
+	for i, outer := range paths {
+		if strings.HasSuffix(outer, "/-") {
+			continue
+		}
+		for _, inner := range paths[i+1:] {
+			if strings.HasPrefix(inner, outer+"/") {
+				conflicts = append(conflicts, Conflict{
+					Keys:   []string{outer, inner},
+					Reason: fmt.Sprintf("%s patches inside %s, which is replaced as a whole", inner, outer),
+				})
+			}
+		}
+	}
+
+	// Every item is identified by its list and the value of its merge key
+	type item struct{ list, id string }
+	added := map[item][]string{}
+	var items []item
+	for _, path := range paths {
+		list := strings.TrimSuffix(path, "/-")
+		for _, cfg := range configs[path] {
+			v := reflect.ValueOf(*cfg.Config)
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+				mergeKey := field.Tag.Get("patchMergeKey")
+				if mergeKey == "" || !IsInjectedField(field) {
+					continue
+				}
+				for j := 0; j < v.Field(i).Len(); j++ {
+					it := item{list: list, id: fmt.Sprintf("%s=%v", mergeKey, mergeKeyValue(v.Field(i).Index(j), mergeKey))}
+					if len(added[it]) == 0 {
+						items = append(items, it)
+					}
+					added[it] = append(added[it], path)
+				}
+			}
+		}
+	}
+	for _, it := range items {
+		if keys := added[it]; len(keys) > 1 {
+			conflicts = append(conflicts, Conflict{
+				Keys:   uniqueStrings(keys),
+				Reason: fmt.Sprintf("%s is added to %s more than once", it.id, it.list),
+			})
+		}
+	}
+	return conflicts
+}
+
+// mergeKeyValue returns the field of item named mergeKey in JSON
+func mergeKeyValue(item reflect.Value, mergeKey string) interface{} {
+	if field, ok := fieldByJSONName(item.Type(), mergeKey); ok {
+		return item.FieldByName(field.Name).Interface()
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	var unique []string
+	for i, value := range values {
+		if i == 0 || values[i-1] != value {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
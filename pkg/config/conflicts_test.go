@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	injs := map[string]*InjectionConfig{
+		"/spec/containers/-":                loadTestConfig(t, "containers:\n- {name: healthcheck, image: healthcheck:1}\n- {name: proxy, image: proxy:1}\n"),
+		"/spec/containers":                  loadTestConfig(t, "containers:\n- {name: healthcheck, image: healthcheck:2}\n"),
+		"/spec/containers/0/readinessProbe": loadTestConfig(t, "readinessProbe: {periodSeconds: 10}\n"),
+		"/spec/containers/0/ports/-":        loadTestConfig(t, "ports:\n- {containerPort: 3990}\n- {containerPort: 3990}\n"),
+		"/spec/volumes/-":                   loadTestConfig(t, "volumes:\n- {name: data, emptyDir: {}}\n"),
+	}
+
+	want := []Conflict{{
+		Keys:   []string{"/spec/containers", "/spec/containers/-"},
+		Reason: "/spec/containers/- patches inside /spec/containers, which is replaced as a whole",
+	}, {
+		Keys:   []string{"/spec/containers", "/spec/containers/0/ports/-"},
+		Reason: "/spec/containers/0/ports/- patches inside /spec/containers, which is replaced as a whole",
+	}, {
+		Keys:   []string{"/spec/containers", "/spec/containers/0/readinessProbe"},
+		Reason: "/spec/containers/0/readinessProbe patches inside /spec/containers, which is replaced as a whole",
+	}, {
+		Keys:   []string{"/spec/containers", "/spec/containers/-"},
+		Reason: "name=healthcheck is added to /spec/containers more than once",
+	}, {
+		Keys:   []string{"/spec/containers/0/ports/-"},
+		Reason: "containerPort=3990 is added to /spec/containers/0/ports more than once",
+	}}
+	if got := FindConflicts(ScopedConfigs(injs)); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindConflicts() = %+v; want %+v", got, want)
+	}
+
+	delete(injs, "/spec/containers")
+	delete(injs, "/spec/containers/0/ports/-")
+	if got := FindConflicts(ScopedConfigs(injs)); len(got) != 0 {
+		t.Errorf("FindConflicts() = %+v; want none", got)
+	}
+
+	// Configs of several sources appending to the same list
+	configs := ScopedConfigs(injs)
+	configs["/spec/volumes/-"] = append(configs["/spec/volumes/-"], ScopedConfig{Config: loadTestConfig(t, "volumes:\n- {name: data, emptyDir: {}}\n")})
+	want = []Conflict{{Keys: []string{"/spec/volumes/-"}, Reason: "name=data is added to /spec/volumes more than once"}}
+	if got := FindConflicts(configs); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindConflicts() = %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Payload decoding modes
+const (
+	// DecodingStrict rejects payloads with fields that are not part of an injection config
+	DecodingStrict = "strict"
+	// DecodingLenient ignores the unknown fields of payloads, and only warns about them
+	DecodingLenient = "lenient"
+)
+
+// DecodeInjectionConfig loads an injection config payload of any of the SupportedVersions. Fields that are not
+// part of an injection config, or spelt with another case, fail the payload when strict and are returned as
+// warnings otherwise, along with their line and column. A payload that sets no field is loaded with a warning.
+func DecodeInjectionConfig(payload []byte, strict bool) (*InjectionConfig, []string, error) {
+	inj, version, err := decodePayload(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	into := reflect.TypeOf(InjectionConfig{})
+	if version == V1alpha1 {
+		into = reflect.TypeOf(InjectionConfigV1alpha1{})
+	}
+	unknown, err := unknownFields(payload, into)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(unknown) != 0 {
+		if strict {
+			return nil, nil, fmt.Errorf("%s", strings.Join(unknown, "; "))
+		}
+		warnings = append(warnings, unknown...)
+	}
+	if inj.SetsNothing() {
+		warnings = append(warnings, "the payload sets no injection field")
+	}
+	return inj, warnings, nil
+}
+
+// SetsNothing reports whether c neither sets a field injected into pods nor extends another config
+func (c *InjectionConfig) SetsNothing() bool {
+	if c.Extends != nil {
+		return false
+	}
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !IsInjectedField(v.Type().Field(i)) {
+			continue
+		}
+		if (field.Kind() == reflect.Slice && field.Len() != 0) || (field.Kind() == reflect.Ptr && !field.IsNil()) {
+			return false
+		}
+	}
+	return true
+}
+
+// unknownFields describes every field of payload that t has no field for, with the json tags of t spelt
+// exactly
+func unknownFields(payload []byte, t reflect.Type) ([]string, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(payload, &doc); err != nil {
+		return nil, err
+	}
+	var found []string
+	walkFields(&doc, t, "", &found)
+	return found, nil
+}
+
+func walkFields(node *yaml.Node, t reflect.Type, path string, found *[]string) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, content := range node.Content {
+			walkFields(content, t, path, found)
+		}
+		return
+	}
+	t = indirect(t)
+	if isLeaf(t) {
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				// The fields of a merged mapping are fields of this one
+				walkFields(value, t, path, found)
+				continue
+			}
+			field, ok := fieldByJSONName(t, key.Value)
+			if ok {
+				walkFields(value, field.Type, joinField(path, key.Value), found)
+				continue
+			}
+			msg := fmt.Sprintf("line %d, column %d: unknown field %q", key.Line, key.Column, key.Value)
+			if path != "" {
+				msg += " in " + path
+			}
+			if suggestion, ok := fieldByJSONNameFold(t, key.Value); ok {
+				msg += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			*found = append(*found, msg)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkFields(node.Content[i+1], t.Elem(), joinField(path, node.Content[i].Value), found)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			walkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), found)
+		}
+	}
+}
+
+func joinField(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeInjectionConfig_UnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{{
+		name:    "top level",
+		payload: "readinesProbe:\n  periodSeconds: 10\n",
+		want:    []string{`line 1, column 1: unknown field "readinesProbe"`},
+	}, {
+		name:    "nested",
+		payload: "containers:\n- name: sidecar\n  volumeMount:\n  - mountPath: /data\n",
+		want:    []string{`line 3, column 3: unknown field "volumeMount" in containers[0]`},
+	}, {
+		name:    "wrong case",
+		payload: "hostPid: true\n",
+		want:    []string{`line 1, column 1: unknown field "hostPid", did you mean "hostPID"?`},
+	}, {
+		name:    "v1alpha1",
+		payload: "apiVersion: config.k8s-injector.io/v1alpha1\nkind: InjectionConfig\nspec:\n  containers:\n  - name: sidecar\n    env:\n    - name: A\n      valu: b\n",
+		want:    []string{`line 8, column 7: unknown field "valu" in spec.containers[0].env[0]`},
+	}, {
+		name:    "merged mapping",
+		payload: "probe: &probe\n  periodSeconds: 10\nreadinessProbe:\n  <<: *probe\n  periodSecond: 5\n",
+		want:    []string{`line 1, column 1: unknown field "probe"`, `line 5, column 3: unknown field "periodSecond" in readinessProbe`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeInjectionConfig([]byte(tt.payload), true)
+			if err == nil || err.Error() != strings.Join(tt.want, "; ") {
+				t.Errorf("strict error = %v; want %q", err, strings.Join(tt.want, "; "))
+			}
+
+			inj, warnings, err := DecodeInjectionConfig([]byte(tt.payload), false)
+			if err != nil || inj == nil {
+				t.Fatalf("lenient DecodeInjectionConfig() = %v, %v; want the config loaded", inj, err)
+			}
+			if len(warnings) < len(tt.want) || !reflect.DeepEqual(warnings[:len(tt.want)], tt.want) {
+				t.Errorf("lenient warnings = %q; want %q", warnings, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInjectionConfig_SetsNothing(t *testing.T) {
+	tests := []struct {
+		payload string
+		warns   bool
+	}{
+		{payload: "", warns: true},
+		{payload: "{}", warns: true},
+		{payload: "name: base\n", warns: true},
+		{payload: "extends: base\n"},
+		{payload: "hostPID: false\n"},
+		{payload: "containers: []\n", warns: true},
+	}
+	for _, tt := range tests {
+		_, warnings, err := DecodeInjectionConfig([]byte(tt.payload), true)
+		if err != nil {
+			t.Errorf("DecodeInjectionConfig(%q) failed: %v", tt.payload, err)
+			continue
+		}
+		warned := len(warnings) == 1 && warnings[0] == "the payload sets no injection field"
+		if warned != tt.warns {
+			t.Errorf("DecodeInjectionConfig(%q) warnings = %q; want a warning: %t", tt.payload, warnings, tt.warns)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// IsInjectedField reports whether a field of InjectionConfig is injected into pods, Name and Extends only
+// name configs
+func IsInjectedField(field reflect.StructField) bool {
+	return field.Name != "Name" && field.Name != "Extends"
+}
+
+// ResolveExtends returns injs, keyed by path, where every config extending another is replaced by its base
+// with its own fields merged over it, and the names of the configs each of them extends, nearest first.
+// Lists of containers, volumes, env vars, volume mounts and ports are merged item by item by their name,
+// mount path or port, like a strategic merge patch; other fields replace those of the base. Configs whose
+// base is missing, defined by several keys or part of a cycle are left out and reported in errs, as are
+// resolved configs that no longer match their path.
+func ResolveExtends(injs map[string]*InjectionConfig) (resolved map[string]*InjectionConfig, bases map[string][]string, errs map[string]error) {
+	return resolveExtends(injs, nil)
+}
+
+// extendsScope tells where a config was loaded from, which decides the configs it can extend
+type extendsScope struct {
+	path      string
+	source    string
+	namespace string
+	tenant    bool
+}
+
+// resolveExtends is ResolveExtends for configs keyed by anything, scopes maps their keys to their path and
+// source. Keys missing from scopes are paths of a single source. A config extends the config of its name in
+// its own source, else in its own namespace, else in the sources that are not tenants; configs of other
+// tenants are never looked at.
+func resolveExtends(injs map[string]*InjectionConfig, scopes map[string]extendsScope) (map[string]*InjectionConfig, map[string][]string, map[string]error) {
+	named := map[string][]string{}
+	for key, inj := range injs {
+		if inj.Name != nil {
+			named[*inj.Name] = append(named[*inj.Name], key)
+		}
+	}
+	for _, keys := range named {
+		sort.Strings(keys)
+	}
+
+	r := &resolver{
+		injs:     injs,
+		scopes:   scopes,
+		named:    named,
+		resolved: make(map[string]*InjectionConfig, len(injs)),
+		bases:    map[string][]string{},
+		errs:     map[string]error{},
+	}
+	for key := range injs {
+		r.resolve(key, nil)
+	}
+	for key, inj := range r.resolved {
+		if len(r.bases[key]) == 0 {
+			continue
+		}
+		if err := ValidatePath(r.scope(key).path, inj); err != nil {
+			r.errs[key] = fmt.Errorf("resolved config: %v", err)
+			delete(r.resolved, key)
+			delete(r.bases, key)
+		}
+	}
+	return r.resolved, r.bases, r.errs
+}
+
+type resolver struct {
+	injs     map[string]*InjectionConfig
+	scopes   map[string]extendsScope
+	named    map[string][]string
+	resolved map[string]*InjectionConfig
+	bases    map[string][]string
+	errs     map[string]error
+}
+
+// resolve resolves the config of key, chain holds the keys being resolved that extend it
+func (r *resolver) resolve(key string, chain []string) (*InjectionConfig, error) {
+	if inj, ok := r.resolved[key]; ok {
+		return inj, nil
+	}
+	if err, ok := r.errs[key]; ok {
+		return nil, err
+	}
+	for i, k := range chain {
+		if k == key {
+			return nil, &cycleError{keys: append([]string(nil), chain[i:]...), injs: r.injs}
+		}
+	}
+
+	inj := r.injs[key]
+	if inj.Extends == nil {
+		r.resolved[key] = inj
+		return inj, nil
+	}
+	base, err := r.base(key, *inj.Extends, append(chain, key))
+	if err == nil {
+		var merged *InjectionConfig
+		merged, err = mergeOverrides(r.resolved[base], inj)
+		if err == nil {
+			r.resolved[key] = merged
+			r.bases[key] = append([]string{*inj.Extends}, r.bases[base]...)
+			return merged, nil
+		}
+		err = fmt.Errorf("cannot merge over %q: %v", *inj.Extends, err)
+	}
+	r.errs[key] = err
+	return nil, err
+}
+
+// scope returns the scope of the config of key
+func (r *resolver) scope(key string) extendsScope {
+	if scope, ok := r.scopes[key]; ok {
+		return scope
+	}
+	return extendsScope{path: key}
+}
+
+// candidates returns the keys of the configs named name that the config of key can extend, those of its own
+// source, else of its own namespace, else of the sources that are not tenants
+func (r *resolver) candidates(key, name string) []string {
+	scope := r.scope(key)
+	var sameSource, sameNamespace, shared []string
+	for _, k := range r.named[name] {
+		switch other := r.scope(k); {
+		case other.source == scope.source:
+			sameSource = append(sameSource, k)
+		case other.namespace == scope.namespace:
+			sameNamespace = append(sameNamespace, k)
+		case !other.tenant:
+			shared = append(shared, k)
+		}
+	}
+	switch {
+	case len(sameSource) != 0:
+		return sameSource
+	case len(sameNamespace) != 0:
+		return sameNamespace
+	}
+	return shared
+}
+
+// base resolves the config named name that the config of key extends, and returns its key
+func (r *resolver) base(key, name string, chain []string) (string, error) {
+	keys := r.candidates(key, name)
+	switch {
+	case len(keys) == 0:
+		return "", fmt.Errorf("extends %q, but no config is named so", name)
+	case len(keys) > 1:
+		return "", fmt.Errorf("extends %q, which is the name of several configs: %s", name, strings.Join(keys, ", "))
+	}
+	if _, err := r.resolve(keys[0], chain); err != nil {
+		if cycle, ok := err.(*cycleError); ok && cycle.has(key) {
+			return "", err
+		}
+		return "", fmt.Errorf("extends %q, which cannot be resolved: %v", name, err)
+	}
+	return keys[0], nil
+}
+
+// cycleError is the error of every config of an extends cycle
+type cycleError struct {
+	keys []string
+	injs map[string]*InjectionConfig
+}
+
+func (e *cycleError) Error() string {
+	names := make([]string, 0, len(e.keys)+1)
+	for _, key := range append(e.keys, e.keys[0]) {
+		names = append(names, fmt.Sprintf("%q", *e.injs[key].Name))
+	}
+	return "extends cycle " + strings.Join(names, " -> ")
+}
+
+func (e *cycleError) has(key string) bool {
+	for _, k := range e.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOverrides merges the injected fields set by overrides over base, keeping the name of overrides
+func mergeOverrides(base, overrides *InjectionConfig) (*InjectionConfig, error) {
+	original, err := json.Marshal(injectedFields(base))
+	if err != nil {
+		return nil, err
+	}
+	patch, err := json.Marshal(injectedFields(overrides))
+	if err != nil {
+		return nil, err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, InjectionConfig{})
+	if err != nil {
+		return nil, err
+	}
+	out := &InjectionConfig{}
+	if err := json.Unmarshal(merged, out); err != nil {
+		return nil, err
+	}
+	out.Name = overrides.Name
+	out.Extends = overrides.Extends
+	return out, nil
+}
+
+// injectedFields returns the fields of inj that are injected, without the unset ones
+func injectedFields(inj *InjectionConfig) InjectionSpecV1alpha1 {
+	fields := InjectionSpecV1alpha1(*inj)
+	fields.Name = nil
+	fields.Extends = nil
+	return fields
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, payload string) *InjectionConfig {
+	inj, err := LoadInjectionConfigStrict([]byte(payload))
+	if err != nil {
+		t.Fatalf("LoadInjectionConfigStrict(%q) failed: %v", payload, err)
+	}
+	return inj
+}
+
+const healthcheckPayload = `
+name: healthcheck
+containers:
+- name: healthcheck
+  image: healthcheck:1
+  env:
+  - {name: TARGET, value: web}
+  - {name: PORT, value: "3990"}
+  readinessProbe:
+    periodSeconds: 10
+`
+
+func TestResolveExtends(t *testing.T) {
+	injs := map[string]*InjectionConfig{
+		"/spec/containers/-": loadTestConfig(t, healthcheckPayload),
+		"/spec/initContainers/-": loadTestConfig(t, `
+name: db-healthcheck
+extends: healthcheck
+containers:
+- name: healthcheck
+  env:
+  - {name: TARGET, value: db}
+  readinessProbe:
+    periodSeconds: 30
+`),
+		"/spec/initContainers": loadTestConfig(t, "name: slow-db-healthcheck\nextends: db-healthcheck\n"),
+	}
+
+	resolved, bases, errs := ResolveExtends(injs)
+	if len(errs) != 0 {
+		t.Fatalf("ResolveExtends() errs = %v", errs)
+	}
+	if resolved["/spec/containers/-"] != injs["/spec/containers/-"] {
+		t.Errorf("a config extending nothing should be kept as is")
+	}
+
+	db := resolved["/spec/initContainers/-"]
+	if len(db.Containers) != 1 {
+		t.Fatalf("containers = %+v; want the healthcheck container merged by name", db.Containers)
+	}
+	container := db.Containers[0]
+	if container.Image != "healthcheck:1" {
+		t.Errorf("image = %q; want it from the base", container.Image)
+	}
+	env := map[string]string{}
+	for _, e := range container.Env {
+		env[e.Name] = e.Value
+	}
+	if want := map[string]string{"TARGET": "db", "PORT": "3990"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v; want %v", env, want)
+	}
+	if container.ReadinessProbe.PeriodSeconds != 30 {
+		t.Errorf("readinessProbe.periodSeconds = %d; want the override", container.ReadinessProbe.PeriodSeconds)
+	}
+	if *db.Name != "db-healthcheck" || injs["/spec/containers/-"].Containers[0].Env[0].Value != "web" {
+		t.Errorf("the resolved config should keep its name and leave the base untouched")
+	}
+
+	if want := []string{"db-healthcheck", "healthcheck"}; !reflect.DeepEqual(bases["/spec/initContainers"], want) {
+		t.Errorf("bases = %v; want %v", bases["/spec/initContainers"], want)
+	}
+	if _, ok := bases["/spec/containers/-"]; ok {
+		t.Errorf("bases = %v; want none for a config extending nothing", bases)
+	}
+}
+
+func TestResolveExtends_Errors(t *testing.T) {
+	named := func(name, extends string) *InjectionConfig {
+		hostPID := true
+		inj := &InjectionConfig{Name: &name, HostPID: &hostPID}
+		if extends != "" {
+			inj.Extends = &extends
+		}
+		return inj
+	}
+
+	tests := []struct {
+		name string
+		injs map[string]*InjectionConfig
+		want map[string]string
+	}{{
+		name: "missing base",
+		injs: map[string]*InjectionConfig{"/spec/hostPID": named("a", "b")},
+		want: map[string]string{"/spec/hostPID": `extends "b", but no config is named so`},
+	}, {
+		name: "ambiguous base",
+		injs: map[string]*InjectionConfig{
+			"/spec/hostPID":         named("a", "b"),
+			"/spec/hostNetwork":     named("b", ""),
+			"/spec/containers/0/ha": named("b", ""),
+		},
+		want: map[string]string{"/spec/hostPID": `extends "b", which is the name of several configs`},
+	}, {
+		name: "cycle",
+		injs: map[string]*InjectionConfig{
+			"/spec/hostPID":     named("a", "b"),
+			"/spec/hostNetwork": named("b", "a"),
+			"/metadata/name":    named("c", "a"),
+		},
+		want: map[string]string{
+			"/spec/hostPID":     `extends cycle "`,
+			"/spec/hostNetwork": `extends cycle "`,
+			"/metadata/name":    `extends "a", which cannot be resolved: extends cycle`,
+		},
+	}, {
+		name: "self",
+		injs: map[string]*InjectionConfig{"/spec/hostPID": named("a", "a")},
+		want: map[string]string{"/spec/hostPID": `extends cycle "a" -> "a"`},
+	}, {
+		name: "resolved config of another type",
+		injs: map[string]*InjectionConfig{
+			"/spec/hostPID":          named("a", ""),
+			"/spec/initContainers/-": {Extends: named("a", "").Name},
+		},
+		want: map[string]string{
+			"/spec/initContainers/-": "resolved config: path /spec/initContainers/- holds a v1.Container, but the payload sets hostPID",
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, _, errs := ResolveExtends(tt.injs)
+			if len(errs) != len(tt.want) {
+				t.Errorf("ResolveExtends() errs = %v; want %d errors", errs, len(tt.want))
+			}
+			for key, want := range tt.want {
+				if err := errs[key]; err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error of %s = %v; want it to contain %q", key, err, want)
+				}
+				if _, ok := resolved[key]; ok {
+					t.Errorf("%s was resolved despite its error", key)
+				}
+			}
+		})
+	}
+}
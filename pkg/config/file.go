@@ -0,0 +1,233 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// flagEnvKeys maps every setting of the config file, named like its flag, to the env var that overrides it.
+// webhook-enable-label has no env var.
+var flagEnvKeys = map[string]string{
+	"lifecycle-port":              lifeCyclePortConfigKey,
+	"tls-port":                    tlsPortConfigKey,
+	"tls-cert-file":               tlsCertFileConfigKey,
+	"tls-key-file":                tlsKeyFileConfigKey,
+	"annotation-namespace":        annotationNamespaceConfigKey,
+	"configmap-namespace":         configmapNamespaceConfigKey,
+	"configmap-name":              configmapNameConfigKey,
+	"log-level":                   logLevelConfigKey,
+	"log-format":                  logFormatConfigKey,
+	"kube-config":                 kubeConfigConfigKey,
+	"master-url":                  masterUrlConfigKey,
+	"webhook-enable-label":        "",
+	"read-timeout":                readTimeoutConfigKey,
+	"write-timeout":               writeTimeoutConfigKey,
+	"max-request-bytes":           maxRequestBytesConfigKey,
+	"tls-min-version":             tlsMinVersionConfigKey,
+	"tls-cipher-suites":           tlsCipherSuitesConfigKey,
+	"audit-log-path":              auditLogPathConfigKey,
+	"audit-log-max-size":          auditLogMaxSizeConfigKey,
+	"audit-log-max-backups":       auditLogMaxBackupsConfigKey,
+	"audit-log-include-patch":     auditLogIncludePatchConfigKey,
+	"debug-endpoints":             debugEndpointsConfigKey,
+	"client-ca-file":              clientCAFileConfigKey,
+	"client-allowed-names":        clientAllowedNamesConfigKey,
+	"tracing-exporter":            tracingExporterConfigKey,
+	"tracing-endpoint":            tracingEndpointConfigKey,
+	"tracing-insecure":            tracingInsecureConfigKey,
+	"tracing-sample-ratio":        tracingSampleRatioConfigKey,
+	"resync-period":               resyncPeriodConfigKey,
+	"configmap-delete-policy":     configMapDeletePolicyKey,
+	"configmap-all-namespaces":    configMapAllNamespacesKey,
+	"injection-config-crd":        injectionConfigCRDKey,
+	"config-dir":                  configDirConfigKey,
+	"config-dir-poll-interval":    configDirPollIntervalKey,
+	"enabled-namespaces":          enabledNamespacesConfigKey,
+	"leader-elect":                leaderElectConfigKey,
+	"leader-elect-lease-name":     leaderElectLeaseNameKey,
+	"leader-elect-lease-duration": leaderElectLeaseDurationKey,
+	"leader-elect-renew-deadline": leaderElectRenewDeadlineKey,
+	"leader-elect-retry-period":   leaderElectRetryPeriodKey,
+	"events":                      eventsConfigKey,
+	"event-burst":                 eventBurstConfigKey,
+	"event-qps":                   eventQPSConfigKey,
+	"exclude-namespaces":          excludeNamespacesConfigKey,
+	"exclude-pods":                excludePodsConfigKey,
+	"payload-decoding":            payloadDecodingConfigKey,
+}
+
+// selectorFlags take a label selector, given in the config file either as a string or as a LabelSelector
+var selectorFlags = map[string]bool{
+	"exclude-pods": true,
+}
+
+// applyConfigFile sets every flag of fs named in the YAML file at path, unless it was already set on the
+// command line or through its env var
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	return applySettingsFile(fs, "config file", path, func(name string) (bool, bool) {
+		envKey, ok := flagEnvKeys[name]
+		return ok, envKey != "" && os.Getenv(envKey) != ""
+	})
+}
+
+// ApplyValuesFile sets every flag of fs named in the YAML file at path, unless it was already set on the
+// command line. Values are given like in the config file, lists as YAML lists and key=value pairs as maps.
+// The flag naming the values file itself cannot be set from it.
+func ApplyValuesFile(fs *flag.FlagSet, path, valuesFlag string) error {
+	return applySettingsFile(fs, "values file", path, func(name string) (bool, bool) {
+		return name != valuesFlag && fs.Lookup(name) != nil, false
+	})
+}
+
+// applySettingsFile sets the flags of fs named in the YAML file at path, which is called kind in errors.
+// lookup tells whether a name is a setting of the file, and whether it is already set by other means.
+func applySettingsFile(fs *flag.FlagSet, kind, path string, lookup func(name string) (known, setElsewhere bool)) error {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", kind, err)
+	}
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(payload, &settings); err != nil {
+		return fmt.Errorf("cannot parse %s %s: %v", kind, path, err)
+	}
+
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		known, setElsewhere := lookup(name)
+		if !known {
+			return fmt.Errorf("%s %s: unknown setting %q", kind, path, name)
+		}
+		if onCommandLine[name] || setElsewhere {
+			continue
+		}
+		value, err := settingValue(name, settings[name])
+		if err != nil {
+			return fmt.Errorf("%s %s: %s: %v", kind, path, name, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s %s: %s: %v", kind, path, name, err)
+		}
+	}
+	return nil
+}
+
+// settingValue converts a decoded YAML value to the string its flag parses: lists are comma-separated and
+// maps are key=value pairs, or a label selector for selectorFlags
+func settingValue(name string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(name, item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		if selectorFlags[name] {
+			return selectorValue(v)
+		}
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			s, err := settingValue(name, item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+func selectorValue(value map[string]interface{}) (string, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	labelSelector := metav1.LabelSelector{}
+	if err := json.Unmarshal(raw, &labelSelector); err != nil {
+		return "", err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
+func formatSelector(selector labels.Selector) string {
+	if selector == nil {
+		return ""
+	}
+	return selector.String()
+}
+
+// runtimeSettings are the fields of Config that ApplyRuntime changes without a restart
+var runtimeSettings = map[string]bool{
+	"LogLevel":           true,
+	"WebhookEnableLabel": true,
+	"ExcludeNamespaces":  true,
+	"ExcludePods":        true,
+}
+
+// Reload parses the command line again against the current content of the config file. The returned
+// Config is only meant to be given to ApplyRuntime.
+func (c *Config) Reload() (*Config, error) {
+	next := &Config{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := parseArgs(next, fs, c.args); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// ApplyRuntime copies the runtime-safe settings of next to c: the log level, the webhook enable label and
+// the exclusions. It returns the names of the other fields that differ, which only take effect on restart.
+func (c *Config) ApplyRuntime(next *Config) []string {
+	current := reflect.ValueOf(c).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	var restart []string
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if runtimeSettings[field.Name] {
+			current.Field(i).Set(updated.Field(i))
+		} else if !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			restart = append(restart, field.Name)
+		}
+	}
+	return restart
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+	return path
+}
+
+func parseTestArgs(t *testing.T, args ...string) (*Config, error) {
+	config := &Config{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	return config, parseArgs(config, fs, args)
+}
+
+func TestParseArgs_ConfigFilePrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+configmap-name: k8s-injector
+tls-port: 1000
+lifecycle-port: 2000
+read-timeout: 3s
+max-request-bytes: 6291456
+webhook-enable-label:
+  injection: "on"
+exclude-namespaces: [kube-system, monitoring]
+exclude-pods:
+  matchExpressions:
+  - {key: tier, operator: In, values: [infra]}
+`)
+	t.Setenv(lifeCyclePortConfigKey, "2500")
+
+	config, err := parseTestArgs(t, "--tls-port=1500", "--config="+path)
+	if err != nil {
+		t.Fatalf("parseArgs() failed: %v", err)
+	}
+	if config.TLSPort != 1500 {
+		t.Errorf("TLSPort = %d; want the flag to win over the file", config.TLSPort)
+	}
+	if config.LifecyclePort != 2500 {
+		t.Errorf("LifecyclePort = %d; want the env var to win over the file", config.LifecyclePort)
+	}
+	if config.ReadTimeout != 3*time.Second || config.MaxRequestBytes != 6291456 || config.ConfigMapName != "k8s-injector" {
+		t.Errorf("ReadTimeout, MaxRequestBytes, ConfigMapName = %s, %d, %q; want them from the file", config.ReadTimeout, config.MaxRequestBytes, config.ConfigMapName)
+	}
+	if config.WriteTimeout != writeTimeoutDefault {
+		t.Errorf("WriteTimeout = %s; want the default %s", config.WriteTimeout, writeTimeoutDefault)
+	}
+	if want := map[string]string{"injection": "on"}; !reflect.DeepEqual(config.WebhookEnableLabel, want) {
+		t.Errorf("WebhookEnableLabel = %v; want %v", config.WebhookEnableLabel, want)
+	}
+	if want := []string{"kube-system", "monitoring"}; !reflect.DeepEqual(config.ExcludeNamespaces, want) {
+		t.Errorf("ExcludeNamespaces = %v; want %v", config.ExcludeNamespaces, want)
+	}
+	if got := formatSelector(config.ExcludePods); got != "tier in (infra)" {
+		t.Errorf("ExcludePods = %q; want %q", got, "tier in (infra)")
+	}
+}
+
+func TestParseArgs_ConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "unknown setting", content: "configmap-name: x\ntls-prot: 1\n", want: `unknown setting "tls-prot"`},
+		{name: "invalid value", content: "configmap-name: x\ntls-port: https\n", want: "tls-port"},
+		{name: "nested config", content: "config: other.yaml\n", want: `unknown setting "config"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestArgs(t, "--config="+writeConfigFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseArgs() error = %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ReloadAppliesRuntimeSettings(t *testing.T) {
+	path := writeConfigFile(t, "configmap-name: k8s-injector\nlog-level: info\ntls-port: 1000\n")
+	config, err := parseTestArgs(t, "--config="+path)
+	if err != nil {
+		t.Fatalf("parseArgs() failed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("configmap-name: k8s-injector\nlog-level: debug\ntls-port: 2000\nexclude-pods: tier=infra\n"), 0o644); err != nil {
+		t.Fatalf("could not rewrite config file: %v", err)
+	}
+	next, err := config.Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	restart := config.ApplyRuntime(next)
+
+	if config.LogLevel != "debug" || formatSelector(config.ExcludePods) != "tier=infra" {
+		t.Errorf("LogLevel, ExcludePods = %q, %q; want the reloaded values", config.LogLevel, formatSelector(config.ExcludePods))
+	}
+	if config.TLSPort != 1000 {
+		t.Errorf("TLSPort = %d; want it unchanged until restart", config.TLSPort)
+	}
+	if want := []string{"TLSPort"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("ApplyRuntime() = %v; want %v", restart, want)
+	}
+}
+
+func TestFlagEnvKeys_CoverEveryFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	_ = parseArgs(&Config{}, fs, nil)
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := flagEnvKeys[f.Name]; !ok && f.Name != "config" {
+			t.Errorf("flag %q cannot be set from the config file", f.Name)
+		}
+	})
+}
+
+func TestApplyValuesFile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	values := fs.String("values", "", "")
+	image := fs.String("image", "", "")
+	namespace := fs.String("namespace", "kube-system", "")
+	args := fs.String("args", "", "")
+	path := writeConfigFile(t, "image: injector:v1\nnamespace: injector\nargs: [--log-format=json, --events=false]\n")
+	if err := fs.Parse([]string{"--values=" + path, "--namespace=ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyValuesFile(fs, *values, "values"); err != nil {
+		t.Fatalf("ApplyValuesFile() failed: %v", err)
+	}
+	if *image != "injector:v1" || *args != "--log-format=json,--events=false" {
+		t.Errorf("image, args = %q, %q; want the values of the file", *image, *args)
+	}
+	if *namespace != "ops" {
+		t.Errorf("namespace = %q; want the command line to win", *namespace)
+	}
+
+	for _, content := range []string{"imag: x\n", "values: other.yaml\n"} {
+		if err := ApplyValuesFile(fs, writeConfigFile(t, content), "values"); err == nil || !strings.Contains(err.Error(), "unknown setting") {
+			t.Errorf("ApplyValuesFile(%q) error = %v; want an unknown setting", content, err)
+		}
+	}
+}
@@ -1,24 +1,27 @@
 package config
 
 import (
-	"github.com/ghodss/yaml"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// InjectionConfig holds the fields injected into pods at a path. Name and Extends are not injected: a config
+// extending the config of another name is that config with its own fields merged over it, see ResolveExtends.
+// The patch tags give the keys lists are merged by.
 type InjectionConfig struct {
 	Name           *string                      `json:"name"`
-	Containers     []corev1.Container           `json:"containers"`
-	Volumes        []corev1.Volume              `json:"volumes"`
-	Environments   []corev1.EnvVar              `json:"env"`
-	VolumeMounts   []corev1.VolumeMount         `json:"volumeMounts"`
+	Extends        *string                      `json:"extends"`
+	Containers     []corev1.Container           `json:"containers" patchStrategy:"merge" patchMergeKey:"name"`
+	Volumes        []corev1.Volume              `json:"volumes" patchStrategy:"merge" patchMergeKey:"name"`
+	Environments   []corev1.EnvVar              `json:"env" patchStrategy:"merge" patchMergeKey:"name"`
+	VolumeMounts   []corev1.VolumeMount         `json:"volumeMounts" patchStrategy:"merge" patchMergeKey:"mountPath"`
 	HostNetwork    *bool                        `json:"hostNetwork"`
 	HostPID        *bool                        `json:"hostPID"`
-	InitContainers []corev1.Container           `json:"initContainers"`
+	InitContainers []corev1.Container           `json:"initContainers" patchStrategy:"merge" patchMergeKey:"name"`
 	Readiness      *corev1.Probe                `json:"readinessProbe"`
 	Liveness       *corev1.Probe                `json:"livenessProbe"`
 	Startup        *corev1.Probe                `json:"startupProbe"`
 	Resources      *corev1.ResourceRequirements `json:"resources"`
-	Ports          []corev1.ContainerPort       `json:"ports"`
+	Ports          []corev1.ContainerPort       `json:"ports" patchStrategy:"merge" patchMergeKey:"containerPort"`
 	// version        string
 }
 
@@ -32,11 +35,16 @@ type InjectionConfig struct {
 // 		len(c.VolumeMounts))
 // }
 
+// LoadInjectionConfig loads an injection config payload of any of the SupportedVersions, ignoring the fields
+// it does not know
 func LoadInjectionConfig(payload []byte) (*InjectionConfig, error) {
-	cfg := InjectionConfig{}
-	if err := yaml.Unmarshal(payload, &cfg); err != nil {
-		return nil, err
-	}
+	inj, _, err := DecodeInjectionConfig(payload, false)
+	return inj, err
+}
 
-	return &cfg, nil
+// LoadInjectionConfigStrict loads payload like LoadInjectionConfig, but fails on fields that are not part of
+// an InjectionConfig instead of ignoring them
+func LoadInjectionConfigStrict(payload []byte) (*InjectionConfig, error) {
+	inj, _, err := DecodeInjectionConfig(payload, true)
+	return inj, err
 }
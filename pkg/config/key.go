@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// A ConfigMap key names the JSON patch path its injection config is added at:
+//
+//	key     = 1*( "." segment )
+//	segment = 1*( ALPHA / DIGIT / "-" / "_" / ".." )
+//
+// Dots separate the segments, and a doubled dot is a literal dot inside a segment, so the key
+// .spec.nodeSelector.example..com/zone is invalid but .spec.nodeSelector.example..com is the path
+// /spec/nodeSelector/example.com. A segment therefore cannot start with a literal dot, and keys cannot name
+// the prefixed label or annotation keys holding a '/'. A list index is a segment of digits, and "-" as the
+// last segment appends to the list.
+
+var podType = reflect.TypeOf(corev1.Pod{})
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// KeyToPath converts a ConfigMap key to the JSON patch path it injects at
+func KeyToPath(key string) (string, error) {
+	segments, err := parseKey(key)
+	if err != nil {
+		return "", err
+	}
+	return "/" + strings.Join(segments, "/"), nil
+}
+
+// ValidateKey checks that a ConfigMap key follows the key grammar
+func ValidateKey(key string) error {
+	_, err := parseKey(key)
+	return err
+}
+
+func parseKey(key string) ([]string, error) {
+	if !strings.HasPrefix(key, ".") {
+		return nil, fmt.Errorf("key %q must start with a dot", key)
+	}
+	var segments []string
+	var segment strings.Builder
+	for i := 1; i <= len(key); i++ {
+		if i == len(key) || key[i] == '.' {
+			if i+1 < len(key) && key[i+1] == '.' && segment.Len() != 0 {
+				segment.WriteByte('.')
+				i++
+				continue
+			}
+			if segment.Len() == 0 {
+				return nil, fmt.Errorf("key %q has an empty path segment at offset %d", key, i)
+			}
+			segments = append(segments, segment.String())
+			segment.Reset()
+			continue
+		}
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return nil, fmt.Errorf("key %q has invalid character %q at offset %d, only letters, digits, '-', '_' and '.' are allowed", key, c, i)
+		}
+		segment.WriteByte(c)
+	}
+	return segments, nil
+}
+
+// ValidatePath checks that path exists in the Pod schema and that every injected field set by inj holds a
+// value of the type found at path. The fields of a config at a path ending with "-" are appended item by
+// item, so they must be lists of the items of the list at path.
+func ValidatePath(path string, inj *InjectionConfig) error {
+	segments, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	target, err := typeAt(segments)
+	if err != nil {
+		return fmt.Errorf("path %s: %v", path, err)
+	}
+	appending := segments[len(segments)-1] == "-"
+
+	v := reflect.ValueOf(*inj)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !IsInjectedField(v.Type().Field(i)) {
+			continue
+		}
+		if (field.Kind() == reflect.Slice && field.Len() == 0) || (field.Kind() == reflect.Ptr && field.IsNil()) {
+			continue
+		}
+		name := jsonName(v.Type().Field(i))
+		valueType := field.Type()
+		if appending && valueType.Kind() == reflect.Slice {
+			valueType = valueType.Elem()
+		}
+		if indirect(valueType) != indirect(target) {
+			return fmt.Errorf("path %s holds a %s, but the payload sets %s to a %s", path, typeName(target), name, typeName(valueType))
+		}
+	}
+	return nil
+}
+
+// splitPath splits a JSON patch path into its unescaped segments
+func splitPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("path %q must start with / and name a field", path)
+	}
+	segments := strings.Split(path[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+	}
+	return segments, nil
+}
+
+// typeAt returns the type of the value an add operation sets at the path made of segments, in a Pod
+func typeAt(segments []string) (reflect.Type, error) {
+	if segments[0] != "spec" && segments[0] != "metadata" {
+		return nil, fmt.Errorf("only fields under /spec and /metadata can be injected, not %q", segments[0])
+	}
+	t := podType
+	where := ""
+	for i, segment := range segments {
+		t = indirect(t)
+		if segment == "" {
+			return nil, fmt.Errorf("empty segment after %q", where+"/")
+		}
+		switch {
+		case t.Kind() == reflect.Slice:
+			if segment == "-" {
+				if i != len(segments)-1 {
+					return nil, fmt.Errorf("\"-\" appends to %s and can only be the last segment", where)
+				}
+			} else if _, err := strconv.ParseUint(segment, 10, 31); err != nil {
+				return nil, fmt.Errorf("%s is a list, %q is neither an index nor \"-\"", where, segment)
+			}
+			t = t.Elem()
+		case t.Kind() == reflect.Map:
+			t = t.Elem()
+		case t.Kind() == reflect.Struct && !isLeaf(t):
+			field, ok := fieldByJSONName(t, segment)
+			if !ok {
+				if suggestion, ok := fieldByJSONNameFold(t, segment); ok {
+					return nil, fmt.Errorf("%s has no field %q, did you mean %q?", orRoot(where), segment, suggestion)
+				}
+				return nil, fmt.Errorf("%s has no field %q", orRoot(where), segment)
+			}
+			t = field.Type
+		default:
+			return nil, fmt.Errorf("%s is a %s and has no field %q", where, typeName(t), segment)
+		}
+		where += "/" + segment
+	}
+	return t, nil
+}
+
+// isLeaf reports whether values of t are encoded as a whole, like an IntOrString or a Quantity
+func isLeaf(t reflect.Type) bool {
+	return t.Implements(jsonMarshaler) || reflect.PtrTo(t).Implements(jsonMarshaler)
+}
+
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if field.Anonymous && tag[0] == "" {
+			if inner, ok := fieldByJSONName(indirect(field.Type), name); ok {
+				return inner, true
+			}
+			continue
+		}
+		if tag[0] == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func fieldByJSONNameFold(t reflect.Type, name string) (string, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			if inner, ok := fieldByJSONNameFold(indirect(field.Type), name); ok {
+				return inner, true
+			}
+			continue
+		}
+		if tag := jsonName(field); strings.EqualFold(tag, name) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func typeName(t reflect.Type) string {
+	t = indirect(t)
+	if t.Kind() == reflect.Slice {
+		return "list of " + typeName(t.Elem())
+	}
+	return t.String()
+}
+
+func orRoot(where string) string {
+	if where == "" {
+		return "a Pod"
+	}
+	return where
+}
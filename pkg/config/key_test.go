@@ -0,0 +1,125 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestKeyToPath(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr string
+	}{
+		{key: ".spec.containers.0.readinessProbe", want: "/spec/containers/0/readinessProbe"},
+		{key: ".spec.containers.-", want: "/spec/containers/-"},
+		{key: ".spec.nodeSelector.example..com", want: "/spec/nodeSelector/example.com"},
+		{key: ".metadata.labels.app..kubernetes..io_name", want: "/metadata/labels/app.kubernetes.io_name"},
+		{key: "spec.hostPID", wantErr: `key "spec.hostPID" must start with a dot`},
+		{key: ".spec.", wantErr: `key ".spec." has an empty path segment at offset 6`},
+		{key: "..spec", wantErr: `key "..spec" has an empty path segment at offset 1`},
+		{key: ".spec.host PID", wantErr: `key ".spec.host PID" has invalid character ' ' at offset 10`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := KeyToPath(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("KeyToPath() error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("KeyToPath() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	hostPID := true
+	name := "sidecar"
+	probe := &InjectionConfig{Readiness: &corev1.Probe{}}
+	containers := &InjectionConfig{Containers: []corev1.Container{{Name: "sidecar"}}}
+
+	tests := []struct {
+		name    string
+		path    string
+		inj     *InjectionConfig
+		wantErr string
+	}{
+		{name: "probe", path: "/spec/containers/0/readinessProbe", inj: probe},
+		{name: "append", path: "/spec/containers/-", inj: containers},
+		{name: "whole list", path: "/spec/initContainers", inj: &InjectionConfig{InitContainers: []corev1.Container{{Name: "init"}}}},
+		{name: "scalar", path: "/spec/hostPID", inj: &InjectionConfig{HostPID: &hostPID}},
+		{name: "only extends", path: "/spec/hostPID", inj: &InjectionConfig{Name: &name, Extends: &name}},
+		{name: "resources", path: "/spec/containers/0/resources", inj: &InjectionConfig{Resources: &corev1.ResourceRequirements{}}},
+		{
+			name:    "unknown field",
+			path:    "/spec/containers/0/readinesProbe",
+			inj:     probe,
+			wantErr: `/spec/containers/0 has no field "readinesProbe"`,
+		},
+		{
+			name:    "wrong case",
+			path:    "/spec/hostpid",
+			inj:     &InjectionConfig{HostPID: &hostPID},
+			wantErr: `/spec has no field "hostpid", did you mean "hostPID"?`,
+		},
+		{
+			name:    "not an index",
+			path:    "/spec/containers/first/readinessProbe",
+			inj:     probe,
+			wantErr: `/spec/containers is a list, "first" is neither an index nor "-"`,
+		},
+		{
+			name:    "append in the middle",
+			path:    "/spec/containers/-/readinessProbe",
+			inj:     probe,
+			wantErr: `"-" appends to /spec/containers and can only be the last segment`,
+		},
+		{
+			name:    "field of a scalar",
+			path:    "/spec/hostPID/value",
+			inj:     &InjectionConfig{HostPID: &hostPID},
+			wantErr: `/spec/hostPID is a bool and has no field "value"`,
+		},
+		{
+			name:    "status",
+			path:    "/status/phase",
+			inj:     &InjectionConfig{HostPID: &hostPID},
+			wantErr: `only fields under /spec and /metadata can be injected, not "status"`,
+		},
+		{
+			name:    "payload of another type",
+			path:    "/spec/containers/0/livenessProbe",
+			inj:     containers,
+			wantErr: "path /spec/containers/0/livenessProbe holds a v1.Probe, but the payload sets containers to a list of v1.Container",
+		},
+		{
+			name:    "append a probe",
+			path:    "/spec/containers/-",
+			inj:     probe,
+			wantErr: "path /spec/containers/- holds a v1.Container, but the payload sets readinessProbe to a v1.Probe",
+		},
+		// A payload setting nothing is only warned about by DecodeInjectionConfig
+		{name: "empty payload", path: "/spec/hostPID", inj: &InjectionConfig{}},
+		{name: "only a name", path: "/metadata/labels/app", inj: &InjectionConfig{Name: &name}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePath(tt.path, tt.inj)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidatePath() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidatePath() error = %v; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
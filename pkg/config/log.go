@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Log output formats
+const (
+	// LogFormatConsole writes human readable log lines
+	LogFormatConsole = "console"
+	// LogFormatJSON writes a JSON object per log line
+	LogFormatJSON = "json"
+)
+
+var logLevels = map[string]zerolog.Level{
+	"trace": zerolog.TraceLevel,
+	"debug": zerolog.DebugLevel,
+	"info":  zerolog.InfoLevel,
+	"warn":  zerolog.WarnLevel,
+	"error": zerolog.ErrorLevel,
+}
+
+// ParseLogLevel converts a level such as "info" or "WARN" to its zerolog level
+func ParseLogLevel(level string) (zerolog.Level, error) {
+	if l, ok := logLevels[strings.ToLower(strings.TrimSpace(level))]; ok {
+		return l, nil
+	}
+	return zerolog.NoLevel, fmt.Errorf("invalid log-level passed: %s Should be one of: trace, debug, info, warn, error", level)
+}
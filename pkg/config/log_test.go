@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for level, want := range map[string]zerolog.Level{
+		"trace": zerolog.TraceLevel,
+		"Debug": zerolog.DebugLevel,
+		" info": zerolog.InfoLevel,
+		"WARN":  zerolog.WarnLevel,
+		"error": zerolog.ErrorLevel,
+	} {
+		if got, err := ParseLogLevel(level); err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", level, got, err, want)
+		}
+	}
+	for _, level := range []string{"", "fatal", "verbose"} {
+		if _, err := ParseLogLevel(level); err == nil {
+			t.Errorf("ParseLogLevel(%q) should fail", level)
+		}
+	}
+}
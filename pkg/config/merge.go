@@ -0,0 +1,245 @@
+package config
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ConfigSelector restricts the pods an injection config applies to. A nil ConfigSelector matches every pod.
+type ConfigSelector struct {
+	// Namespaces the config applies to, every namespace when empty
+	Namespaces []string
+	// Pods selects pods by label, every pod when nil
+	Pods labels.Selector
+}
+
+// Matches reports whether a pod with the given labels in namespace is selected
+func (s *ConfigSelector) Matches(namespace string, podLabels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	if len(s.Namespaces) != 0 {
+		found := false
+		for _, ns := range s.Namespaces {
+			if ns == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return s.Pods == nil || s.Pods.Matches(labels.Set(podLabels))
+}
+
+// LowestPriority is the priority of the sources outside the injector's own namespace, which cannot choose
+// their own, so that they never win a key over a source of the injector's namespace
+const LowestPriority = math.MinInt32
+
+// SourceConfigs are the injection configs loaded from a single source
+type SourceConfigs struct {
+	Source     ConfigSource
+	Priority   int
+	Selector   *ConfigSelector
+	InjConfigs map[string]*InjectionConfig
+	// Keys maps the paths of InjConfigs to the keys they were loaded from, paths missing from it are their key
+	Keys map[string]string
+}
+
+// Key returns the key the config of path was loaded from
+func (s SourceConfigs) Key(path string) string {
+	if key, ok := s.Keys[path]; ok {
+		return key
+	}
+	return path
+}
+
+// ScopedConfig is an injection config together with the pods it applies to and the source it came from
+type ScopedConfig struct {
+	Config   *InjectionConfig
+	Selector *ConfigSelector
+	// Source is the ID of the source the config was loaded from, empty for configs of no source
+	Source string
+	// Bases are the names of the configs it extends, nearest first
+	Bases []string
+}
+
+// MergedConfigs are the injection configs of several sources
+type MergedConfigs struct {
+	// Configs maps every path to the configs of the sources setting it, highest precedence first
+	Configs map[string][]ScopedConfig
+	Sources []ConfigSource
+}
+
+// MergeSources merges sources by precedence: the highest Priority comes first, sources of equal priority
+// keep their given order. Every source keeps its configs, the ones of a pod are only picked on admission, see
+// Applicable. A config is reported in the Overridden field of its source and dropped when a config of a
+// higher source for the same path applies to every pod it applies to, configs appending to a list never are.
+// The configs left are then resolved like ResolveExtends does, a config extending the config of its name in
+// its own source, else in its own namespace, else in the sources that are not tenants. Those that cannot be
+// are dropped and reported in the LoadErrors of their source, under the key they were loaded from.
+func MergeSources(sources []SourceConfigs) *MergedConfigs {
+	ordered := make([]SourceConfigs, len(sources))
+	copy(ordered, sources)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+
+	merged := &MergedConfigs{
+		Configs: map[string][]ScopedConfig{},
+		Sources: make([]ConfigSource, 0, len(ordered)),
+	}
+	for _, src := range ordered {
+		source := src.Source
+		for path, inj := range src.InjConfigs {
+			if owner, ok := shadowedBy(merged.Configs[path], path, src.Selector); ok {
+				if source.Overridden == nil {
+					source.Overridden = map[string]string{}
+				}
+				source.Overridden[path] = owner
+				continue
+			}
+			merged.Configs[path] = append(merged.Configs[path], ScopedConfig{Config: inj, Selector: src.Selector, Source: source.ID()})
+		}
+		merged.Sources = append(merged.Sources, source)
+	}
+
+	// The configs of different sources may share a path, they are resolved together under a key of their own
+	byID := make(map[string]ConfigSource, len(merged.Sources))
+	for _, source := range merged.Sources {
+		byID[source.ID()] = source
+	}
+	loaded := make(map[string]SourceConfigs, len(ordered))
+	for _, src := range ordered {
+		loaded[src.Source.ID()] = src
+	}
+	injs := map[string]*InjectionConfig{}
+	scopes := map[string]extendsScope{}
+	for path, configs := range merged.Configs {
+		for _, cfg := range configs {
+			key := entryKey(path, cfg.Source)
+			source := byID[cfg.Source]
+			injs[key] = cfg.Config
+			scopes[key] = extendsScope{path: path, source: cfg.Source, namespace: source.Namespace, tenant: source.Tenant}
+		}
+	}
+	resolved, bases, errs := resolveExtends(injs, scopes)
+	for path, configs := range merged.Configs {
+		kept := configs[:0]
+		for _, cfg := range configs {
+			key := entryKey(path, cfg.Source)
+			if err, ok := errs[key]; ok {
+				merged.addLoadError(cfg.Source, loaded[cfg.Source].Key(path), err)
+				continue
+			}
+			cfg.Config = resolved[key]
+			cfg.Bases = bases[key]
+			kept = append(kept, cfg)
+		}
+		if len(kept) == 0 {
+			delete(merged.Configs, path)
+		} else {
+			merged.Configs[path] = kept
+		}
+	}
+	return merged
+}
+
+// ScopedConfigs returns injs, keyed by path, as configs of no source applying to every pod
+func ScopedConfigs(injs map[string]*InjectionConfig) map[string][]ScopedConfig {
+	configs := make(map[string][]ScopedConfig, len(injs))
+	for path, inj := range injs {
+		configs[path] = []ScopedConfig{{Config: inj}}
+	}
+	return configs
+}
+
+// Applicable returns the configs of configs, those of a path in precedence order, that apply to a pod with
+// podLabels in namespace: the first one whose selector matches it, or every one that does for a path
+// appending to a list
+func Applicable(configs []ScopedConfig, path, namespace string, podLabels map[string]string) []ScopedConfig {
+	var applicable []ScopedConfig
+	for _, cfg := range configs {
+		if !cfg.Selector.Matches(namespace, podLabels) {
+			continue
+		}
+		applicable = append(applicable, cfg)
+		if !appends(path) {
+			break
+		}
+	}
+	return applicable
+}
+
+// shadowedBy returns the source of the first of configs, set for path, that applies to every pod selector does
+func shadowedBy(configs []ScopedConfig, path string, selector *ConfigSelector) (string, bool) {
+	if appends(path) {
+		return "", false
+	}
+	for _, cfg := range configs {
+		if cfg.Selector.covers(selector) {
+			return cfg.Source, true
+		}
+	}
+	return "", false
+}
+
+// covers reports whether s matches every pod other matches, telling by their namespaces only
+func (s *ConfigSelector) covers(other *ConfigSelector) bool {
+	if s == nil {
+		return true
+	}
+	if s.Pods != nil {
+		return false
+	}
+	if len(s.Namespaces) == 0 {
+		return true
+	}
+	if other == nil || len(other.Namespaces) == 0 {
+		return false
+	}
+	namespaces := make(map[string]bool, len(s.Namespaces))
+	for _, ns := range s.Namespaces {
+		namespaces[ns] = true
+	}
+	for _, ns := range other.Namespaces {
+		if !namespaces[ns] {
+			return false
+		}
+	}
+	return true
+}
+
+// addLoadError reports err for the config loaded from key of the source with ID sourceID, without modifying
+// the LoadErrors shared with the source it was loaded as
+func (m *MergedConfigs) addLoadError(sourceID, key string, err error) {
+	for i := range m.Sources {
+		source := &m.Sources[i]
+		if source.ID() != sourceID {
+			continue
+		}
+		loadErrors := make(map[string]string, len(source.LoadErrors)+1)
+		for k, v := range source.LoadErrors {
+			loadErrors[k] = v
+		}
+		loadErrors[key] = err.Error()
+		source.LoadErrors = loadErrors
+	}
+}
+
+// entryKey names the config of path of the source with ID sourceID among the configs of every source
+func entryKey(path, sourceID string) string {
+	if sourceID == "" {
+		return path
+	}
+	return path + " of " + sourceID
+}
+
+// appends reports whether the config of path is appended to a list rather than set
+func appends(path string) bool {
+	return strings.HasSuffix(path, "/-")
+}
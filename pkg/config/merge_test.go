@@ -0,0 +1,210 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestMergeSources(t *testing.T) {
+	low := &InjectionConfig{HostPID: new(bool)}
+	high := &InjectionConfig{HostPID: new(bool)}
+	selector := &ConfigSelector{Pods: labels.SelectorFromSet(labels.Set{"app": "api"})}
+
+	merged := MergeSources([]SourceConfigs{
+		{Source: ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "first"}, InjConfigs: map[string]*InjectionConfig{"/spec/hostPID": low, "/spec/hostNetwork": {}}},
+		{Source: ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "second"}, InjConfigs: map[string]*InjectionConfig{"/spec/hostNetwork": {}}},
+		{Source: ConfigSource{Kind: "InjectionConfig", Namespace: "payment", Name: "high"}, Priority: 1, Selector: selector, InjConfigs: map[string]*InjectionConfig{"/spec/hostPID": high}},
+	})
+
+	hostPID := merged.Configs["/spec/hostPID"]
+	if len(hostPID) != 2 || hostPID[0].Config != high || hostPID[0].Selector != selector || hostPID[1].Config != low {
+		t.Errorf("/spec/hostPID got = %+v; want the highest priority first, then the config of every other pod", hostPID)
+	}
+	hostNetwork := merged.Configs["/spec/hostNetwork"]
+	if len(hostNetwork) != 1 || hostNetwork[0].Source != "ConfigMap/kube-system/first" || hostNetwork[0].Selector != nil {
+		t.Errorf("/spec/hostNetwork got = %+v; want the first source to win ties", hostNetwork)
+	}
+	if len(merged.Sources) != 3 || merged.Sources[0].Name != "high" {
+		t.Fatalf("sources got = %+v; want ordered by precedence", merged.Sources)
+	}
+	if _, ok := merged.Sources[1].Overridden["/spec/hostPID"]; ok {
+		t.Errorf("a config only shadowed for some pods should not be overridden: %+v", merged.Sources[1])
+	}
+	if merged.Sources[2].Overridden["/spec/hostNetwork"] != "ConfigMap/kube-system/first" {
+		t.Errorf("conflicts were not reported: %+v", merged.Sources)
+	}
+}
+
+func TestMergeSources_DisjointSelectors(t *testing.T) {
+	hostPID, noHostPID := true, false
+	sidecar := func(name string) *InjectionConfig {
+		return &InjectionConfig{Containers: []corev1.Container{{Name: name, Image: name}}}
+	}
+	source := func(namespace string, injs map[string]*InjectionConfig) SourceConfigs {
+		return SourceConfigs{
+			Source:     ConfigSource{Kind: "ConfigMap", Namespace: namespace, Name: "k8s-injector"},
+			Priority:   LowestPriority,
+			Selector:   &ConfigSelector{Namespaces: []string{namespace}},
+			InjConfigs: injs,
+		}
+	}
+	merged := MergeSources([]SourceConfigs{
+		{
+			Source:     ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "k8s-injector"},
+			InjConfigs: map[string]*InjectionConfig{"/spec/containers/-": sidecar("proxy")},
+		},
+		source("payment", map[string]*InjectionConfig{"/spec/hostPID": {HostPID: &hostPID}, "/spec/containers/-": sidecar("vault")}),
+		source("dbservice", map[string]*InjectionConfig{"/spec/hostPID": {HostPID: &noHostPID}}),
+	})
+
+	tests := []struct {
+		namespace  string
+		hostPID    *bool
+		containers []string
+	}{
+		{namespace: "payment", hostPID: &hostPID, containers: []string{"proxy", "vault"}},
+		{namespace: "dbservice", hostPID: &noHostPID, containers: []string{"proxy"}},
+		{namespace: "web", containers: []string{"proxy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			applicable := Applicable(merged.Configs["/spec/hostPID"], "/spec/hostPID", tt.namespace, nil)
+			switch {
+			case tt.hostPID == nil && len(applicable) != 0:
+				t.Errorf("/spec/hostPID got = %+v; want none", applicable)
+			case tt.hostPID != nil && (len(applicable) != 1 || *applicable[0].Config.HostPID != *tt.hostPID):
+				t.Errorf("/spec/hostPID got = %+v; want only the config of its namespace, %t", applicable, *tt.hostPID)
+			}
+
+			var containers []string
+			for _, cfg := range Applicable(merged.Configs["/spec/containers/-"], "/spec/containers/-", tt.namespace, nil) {
+				containers = append(containers, cfg.Config.Containers[0].Name)
+			}
+			if !reflect.DeepEqual(containers, tt.containers) {
+				t.Errorf("/spec/containers/- got = %v; want %v appended", containers, tt.containers)
+			}
+		})
+	}
+	for _, source := range merged.Sources {
+		if len(source.Overridden) != 0 {
+			t.Errorf("source %s got overridden %v; want none", source.ID(), source.Overridden)
+		}
+	}
+}
+
+func TestConfigSelector_Matches(t *testing.T) {
+	var all *ConfigSelector
+	if !all.Matches("payment", nil) {
+		t.Errorf("a nil selector should match every pod")
+	}
+	s := &ConfigSelector{Namespaces: []string{"payment"}}
+	if s.Matches("dbservice", nil) || !s.Matches("payment", map[string]string{"app": "api"}) {
+		t.Errorf("namespaces were not matched")
+	}
+}
+
+func TestMergeSources_ResolvesExtends(t *testing.T) {
+	hostPID := true
+	base, missing := "base", "missing"
+	merged := MergeSources([]SourceConfigs{
+		{Source: ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "base"}, InjConfigs: map[string]*InjectionConfig{"/spec/hostPID": {Name: &base, HostPID: &hostPID}}},
+		{Source: ConfigSource{Kind: "InjectionConfig", Namespace: "payment", Name: "derived"}, InjConfigs: map[string]*InjectionConfig{
+			"/spec/hostNetwork": {Extends: &missing, HostNetwork: &hostPID},
+			"/spec/hostIPC":     {Extends: &base},
+		}},
+	})
+
+	hostIPC := merged.Configs["/spec/hostIPC"]
+	if len(hostIPC) != 1 || hostIPC[0].Config.HostPID == nil || !*hostIPC[0].Config.HostPID {
+		t.Errorf("/spec/hostIPC = %+v; want it resolved from its base", hostIPC)
+	}
+	if got := hostIPC[0].Bases; len(got) != 1 || got[0] != "base" {
+		t.Errorf("bases = %v; want [base]", got)
+	}
+	if _, ok := merged.Configs["/spec/hostNetwork"]; ok {
+		t.Errorf("a config extending a missing base should be dropped")
+	}
+	if got := merged.Sources[1].LoadErrors["/spec/hostNetwork"]; got != `extends "missing", but no config is named so` {
+		t.Errorf("load error = %q; want it reported on its source", got)
+	}
+}
+
+func TestMergeSources_ReportsErrorsByKey(t *testing.T) {
+	yes, missing := true, "missing"
+	merged := MergeSources([]SourceConfigs{{
+		Source:     ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "k8s-injector"},
+		InjConfigs: map[string]*InjectionConfig{"/spec/hostNetwork": {Extends: &missing, HostNetwork: &yes}},
+		Keys:       map[string]string{"/spec/hostNetwork": ".spec.hostNetwork"},
+	}})
+
+	loadErrors := merged.Sources[0].LoadErrors
+	if len(loadErrors) != 1 || loadErrors[".spec.hostNetwork"] == "" {
+		t.Errorf("load errors got = %v; want the error under the key the config was loaded from", loadErrors)
+	}
+}
+
+func TestMergeSources_ExtendsWithinScope(t *testing.T) {
+	yes, no := true, false
+	shared, base := "shared", "base"
+	tenant := func(kind, namespace string, injs map[string]*InjectionConfig) SourceConfigs {
+		return SourceConfigs{
+			Source:     ConfigSource{Kind: kind, Namespace: namespace, Name: "k8s-injector", Tenant: true},
+			Priority:   LowestPriority,
+			Selector:   &ConfigSelector{Namespaces: []string{namespace}},
+			InjConfigs: injs,
+		}
+	}
+	merged := MergeSources([]SourceConfigs{
+		{Source: ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "k8s-injector"}, InjConfigs: map[string]*InjectionConfig{"/spec/hostPID": {Name: &shared, HostPID: &yes}}},
+		tenant("ConfigMap", "payment", map[string]*InjectionConfig{"/spec/hostNetwork": {Name: &base, HostNetwork: &yes}}),
+		tenant("InjectionConfig", "payment", map[string]*InjectionConfig{"/spec/hostIPC": {Extends: &base}}),
+		tenant("ConfigMap", "dbservice", map[string]*InjectionConfig{
+			"/spec/hostNetwork": {Name: &base, HostNetwork: &no},
+			"/spec/hostIPC":     {Extends: &base},
+		}),
+		tenant("ConfigMap", "web", map[string]*InjectionConfig{
+			"/spec/hostIPC":     {Extends: &shared},
+			"/spec/hostNetwork": {Extends: &base},
+		}),
+	})
+
+	tests := []struct {
+		name        string
+		source      string
+		hostNetwork *bool
+		hostPID     *bool
+	}{
+		{name: "same namespace", source: "InjectionConfig/payment/k8s-injector", hostNetwork: &yes},
+		{name: "same source", source: "ConfigMap/dbservice/k8s-injector", hostNetwork: &no},
+		{name: "injector namespace", source: "ConfigMap/web/k8s-injector", hostPID: &yes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *InjectionConfig
+			for _, cfg := range merged.Configs["/spec/hostIPC"] {
+				if cfg.Source == tt.source {
+					got = cfg.Config
+				}
+			}
+			if got == nil {
+				t.Fatalf("/spec/hostIPC of %s was not resolved: %+v", tt.source, merged.Sources)
+			}
+			if !reflect.DeepEqual(got.HostNetwork, tt.hostNetwork) || !reflect.DeepEqual(got.HostPID, tt.hostPID) {
+				t.Errorf("/spec/hostIPC of %s = %+v; want hostNetwork %v, hostPID %v", tt.source, got, tt.hostNetwork, tt.hostPID)
+			}
+		})
+	}
+
+	for _, source := range merged.Sources {
+		want := ""
+		if source.Namespace == "web" {
+			want = `extends "base", but no config is named so`
+		}
+		if got := source.LoadErrors["/spec/hostNetwork"]; got != want {
+			t.Errorf("load error of %s = %q; want %q, the configs of other tenants are never extended", source.ID(), got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// An injection config payload is either unversioned, the bare fields of an InjectionConfig, or carries an
+// apiVersion and a kind and holds the fields in its spec:
+//
+//	apiVersion: config.k8s-injector.io/v1alpha1
+//	kind: InjectionConfig
+//	spec:
+//	  hostPID: true
+//
+// Both forms are loaded into an InjectionConfig, which is what the rest of the injector works with.
+
+// Versions of the injection config payload
+const (
+	// Unversioned is the payload without apiVersion and kind
+	Unversioned = ""
+	// V1alpha1 is the apiVersion of the first versioned payload
+	V1alpha1 = "config.k8s-injector.io/v1alpha1"
+)
+
+// InjectionConfigKind is the kind of every versioned payload
+const InjectionConfigKind = "InjectionConfig"
+
+// SupportedVersions are the payload versions LoadInjectionConfig accepts, oldest first
+var SupportedVersions = []string{Unversioned, V1alpha1}
+
+// TypeMeta is the apiVersion and kind of a payload, both empty for an unversioned one
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+// InjectionConfigV1alpha1 is the v1alpha1 form of an injection config payload
+type InjectionConfigV1alpha1 struct {
+	TypeMeta `json:",inline"`
+	Spec     InjectionSpecV1alpha1 `json:"spec"`
+}
+
+// InjectionSpecV1alpha1 holds the fields injected by a v1alpha1 payload. It has the fields of an unversioned
+// payload.
+type InjectionSpecV1alpha1 struct {
+	Name           *string                      `json:"name,omitempty"`
+	Extends        *string                      `json:"extends,omitempty"`
+	Containers     []corev1.Container           `json:"containers,omitempty"`
+	Volumes        []corev1.Volume              `json:"volumes,omitempty"`
+	Environments   []corev1.EnvVar              `json:"env,omitempty"`
+	VolumeMounts   []corev1.VolumeMount         `json:"volumeMounts,omitempty"`
+	HostNetwork    *bool                        `json:"hostNetwork,omitempty"`
+	HostPID        *bool                        `json:"hostPID,omitempty"`
+	InitContainers []corev1.Container           `json:"initContainers,omitempty"`
+	Readiness      *corev1.Probe                `json:"readinessProbe,omitempty"`
+	Liveness       *corev1.Probe                `json:"livenessProbe,omitempty"`
+	Startup        *corev1.Probe                `json:"startupProbe,omitempty"`
+	Resources      *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Ports          []corev1.ContainerPort       `json:"ports,omitempty"`
+}
+
+// ConvertUnversionedToV1alpha1 converts an unversioned config to its v1alpha1 form
+func ConvertUnversionedToV1alpha1(in *InjectionConfig) *InjectionConfigV1alpha1 {
+	return &InjectionConfigV1alpha1{
+		TypeMeta: TypeMeta{APIVersion: V1alpha1, Kind: InjectionConfigKind},
+		Spec:     InjectionSpecV1alpha1(*in),
+	}
+}
+
+// ConvertV1alpha1ToUnversioned converts a v1alpha1 config to its unversioned form
+func ConvertV1alpha1ToUnversioned(in *InjectionConfigV1alpha1) *InjectionConfig {
+	out := InjectionConfig(in.Spec)
+	return &out
+}
+
+// PayloadVersion returns the apiVersion of payload, Unversioned when it has neither apiVersion nor kind
+func PayloadVersion(payload []byte) (string, error) {
+	raw, err := yaml.YAMLToJSON(payload)
+	if err != nil {
+		return "", err
+	}
+	meta, err := payloadMeta(raw)
+	if err != nil {
+		return "", err
+	}
+	return meta.APIVersion, nil
+}
+
+// payloadMeta reads the apiVersion and kind of a JSON payload and checks they are supported
+func payloadMeta(raw []byte) (TypeMeta, error) {
+	meta := TypeMeta{}
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return meta, nil
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return meta, err
+	}
+	switch {
+	case meta == TypeMeta{}:
+	case meta.APIVersion == V1alpha1 && meta.Kind == InjectionConfigKind:
+	case meta.APIVersion == V1alpha1 || meta.APIVersion == "":
+		return meta, fmt.Errorf("unsupported kind %q, want %q", meta.Kind, InjectionConfigKind)
+	default:
+		return meta, fmt.Errorf("unsupported apiVersion %q, want %q or none", meta.APIVersion, V1alpha1)
+	}
+	return meta, nil
+}
+
+// decodePayload loads a payload of any supported version, ignoring unknown fields, and returns its version
+func decodePayload(payload []byte) (*InjectionConfig, string, error) {
+	raw, err := yaml.YAMLToJSON(payload)
+	if err != nil {
+		return nil, "", err
+	}
+	meta, err := payloadMeta(raw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if meta.APIVersion == V1alpha1 {
+		versioned := InjectionConfigV1alpha1{}
+		if err := json.Unmarshal(raw, &versioned); err != nil {
+			return nil, "", err
+		}
+		return ConvertV1alpha1ToUnversioned(&versioned), meta.APIVersion, nil
+	}
+	cfg := InjectionConfig{}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, "", err
+	}
+	return &cfg, meta.APIVersion, nil
+}
+
+// MarshalInjectionConfig encodes inj as a YAML payload of version, leaving out the fields it does not set
+func MarshalInjectionConfig(inj *InjectionConfig, version string) ([]byte, error) {
+	switch version {
+	case Unversioned:
+		// The v1alpha1 spec has the fields of an unversioned payload, without the unset ones
+		return yaml.Marshal(InjectionSpecV1alpha1(*inj))
+	case V1alpha1:
+		return yaml.Marshal(ConvertUnversionedToV1alpha1(inj))
+	default:
+		return nil, fmt.Errorf("unsupported apiVersion %q, want %q or none", version, V1alpha1)
+	}
+}
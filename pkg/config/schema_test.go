@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadInjectionConfig_Versions(t *testing.T) {
+	hostPID := true
+	want := &InjectionConfig{HostPID: &hostPID}
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "unversioned", payload: "hostPID: true\n"},
+		{name: "v1alpha1", payload: "apiVersion: config.k8s-injector.io/v1alpha1\nkind: InjectionConfig\nspec:\n  hostPID: true\n"},
+		{name: "unknown apiVersion", payload: "apiVersion: config.k8s-injector.io/v2\nkind: InjectionConfig\n", wantErr: `unsupported apiVersion "config.k8s-injector.io/v2"`},
+		{name: "missing kind", payload: "apiVersion: config.k8s-injector.io/v1alpha1\nspec:\n  hostPID: true\n", wantErr: `unsupported kind ""`},
+		{name: "kind without apiVersion", payload: "kind: InjectionConfig\nhostPID: true\n", wantErr: `unsupported kind "InjectionConfig"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, load := range []func([]byte) (*InjectionConfig, error){LoadInjectionConfig, LoadInjectionConfigStrict} {
+				got, err := load([]byte(tt.payload))
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Errorf("load() error = %v; want it to contain %q", err, tt.wantErr)
+					}
+					continue
+				}
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("load() = %+v, %v; want %+v", got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadInjectionConfigStrict_V1alpha1UnknownField(t *testing.T) {
+	// Unversioned fields next to the spec of a versioned payload are not injected
+	payload := "apiVersion: config.k8s-injector.io/v1alpha1\nkind: InjectionConfig\nhostPID: true\n"
+	if _, err := LoadInjectionConfigStrict([]byte(payload)); err == nil || !strings.Contains(err.Error(), `unknown field "hostPID"`) {
+		t.Errorf("LoadInjectionConfigStrict() error = %v; want an unknown field error", err)
+	}
+}
+
+func TestMarshalInjectionConfig_RoundTrip(t *testing.T) {
+	name := "sidecar"
+	inj, err := LoadInjectionConfigStrict([]byte("name: sidecar\ncontainers:\n- name: sidecar\n  image: busybox\n"))
+	if err != nil {
+		t.Fatalf("LoadInjectionConfigStrict() failed: %v", err)
+	}
+	for _, version := range SupportedVersions {
+		payload, err := MarshalInjectionConfig(inj, version)
+		if err != nil {
+			t.Fatalf("MarshalInjectionConfig(%q) failed: %v", version, err)
+		}
+		if got, err := PayloadVersion(payload); err != nil || got != version {
+			t.Errorf("PayloadVersion() = %q, %v; want %q", got, err, version)
+		}
+		if strings.Contains(string(payload), "null") {
+			t.Errorf("MarshalInjectionConfig(%q) = %s; want unset fields left out", version, payload)
+		}
+		got, err := LoadInjectionConfigStrict(payload)
+		if err != nil || !reflect.DeepEqual(got, inj) || *got.Name != name {
+			t.Errorf("LoadInjectionConfigStrict(%s) = %+v, %v; want %+v", payload, got, err, inj)
+		}
+	}
+
+	if _, err := MarshalInjectionConfig(inj, "v1"); err == nil {
+		t.Error("MarshalInjectionConfig(\"v1\") succeeded; want an unsupported apiVersion error")
+	}
+}
+
+func TestConvert_RoundTrip(t *testing.T) {
+	hostNetwork := false
+	in := &InjectionConfig{HostNetwork: &hostNetwork}
+	versioned := ConvertUnversionedToV1alpha1(in)
+	if versioned.APIVersion != V1alpha1 || versioned.Kind != InjectionConfigKind {
+		t.Errorf("ConvertUnversionedToV1alpha1() TypeMeta = %+v", versioned.TypeMeta)
+	}
+	if got := ConvertV1alpha1ToUnversioned(versioned); !reflect.DeepEqual(got, in) {
+		t.Errorf("ConvertV1alpha1ToUnversioned() = %+v; want %+v", got, in)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Snapshot is an immutable view of everything an admission needs: the loaded
+// injection configs and the set of namespaces with injection enabled. A
+// Snapshot is never modified once published; every change produces a new one
+// with a higher Generation.
+type Snapshot struct {
+	Generation uint64
+	// Configs maps every path to the configs of the sources setting it, highest precedence first, see
+	// Applicable for those of a pod
+	Configs    map[string][]ScopedConfig
+	Namespaces map[string]bool
+	Sources    []ConfigSource
+	// ExcludedNamespaces are never injected, kube-system and kube-public when nil
+	ExcludedNamespaces map[string]bool
+	// ExcludedPods selects the pods that are never injected, none when nil
+	ExcludedPods labels.Selector
+}
+
+// ConfigSource describes an object the injection configs of a Snapshot were loaded from
+type ConfigSource struct {
+	Kind            string            `json:"kind"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Name            string            `json:"name"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	LoadErrors      map[string]string `json:"loadErrors,omitempty"`
+	// LoadWarnings maps the keys of this source that were loaded despite a problem, to the problem
+	LoadWarnings map[string]string `json:"loadWarnings,omitempty"`
+	// Overridden maps the keys of this source that lost to another source, to the source that won
+	Overridden map[string]string `json:"overridden,omitempty"`
+	// Tenant is set for sources outside the injector's own namespace, whose configs cannot be extended by
+	// those of other namespaces
+	Tenant bool `json:"tenant,omitempty"`
+}
+
+// ID identifies the source among every source of a Snapshot
+func (s ConfigSource) ID() string {
+	return s.Kind + "/" + s.Namespace + "/" + s.Name
+}
+
+// IsExcludedNamespace reports whether pods of ns are never injected
+func (s *Snapshot) IsExcludedNamespace(ns string) bool {
+	if s.ExcludedNamespaces == nil {
+		return ns == metav1.NamespaceSystem || ns == metav1.NamespacePublic
+	}
+	return s.ExcludedNamespaces[ns]
+}
+
+// IsExcludedPod reports whether a pod with the given labels is never injected
+func (s *Snapshot) IsExcludedPod(podLabels map[string]string) bool {
+	return s.ExcludedPods != nil && s.ExcludedPods.Matches(labels.Set(podLabels))
+}
+
+// SortedKeys returns the paths of the injection configs in a stable order
+func (s *Snapshot) SortedKeys() []string {
+	keys := make([]string, 0, len(s.Configs))
+	for key := range s.Configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SnapshotStore publishes Snapshots atomically. Readers always get a complete
+// Snapshot without locking, writers are serialised.
+type SnapshotStore struct {
+	mu      sync.Mutex
+	current atomic.Value
+}
+
+// NewSnapshotStore creates a store holding an empty Snapshot at generation 0
+func NewSnapshotStore() *SnapshotStore {
+	s := &SnapshotStore{}
+	s.current.Store(&Snapshot{
+		Configs:    map[string][]ScopedConfig{},
+		Namespaces: map[string]bool{},
+	})
+	return s
+}
+
+// Load returns the currently published Snapshot
+func (s *SnapshotStore) Load() *Snapshot {
+	return s.current.Load().(*Snapshot)
+}
+
+// Update publishes a new Snapshot built by mutate from a shallow copy of the
+// current one. mutate must replace, never modify in place, the maps it wants
+// to change since they are still shared with the previous Snapshot.
+func (s *SnapshotStore) Update(mutate func(next *Snapshot)) *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := *s.Load()
+	mutate(&next)
+	next.Generation++
+	s.current.Store(&next)
+	return &next
+}
+
+// SetInjConfigs publishes a new Snapshot with the given injection configs, keyed by path and applying to every
+// pod, and the sources they came from
+func (s *SnapshotStore) SetInjConfigs(injConfigs map[string]*InjectionConfig, sources ...ConfigSource) *Snapshot {
+	return s.Update(func(next *Snapshot) {
+		next.Configs = ScopedConfigs(injConfigs)
+		next.Sources = sources
+	})
+}
+
+// SetConfigs publishes a new Snapshot with the given merged injection configs
+func (s *SnapshotStore) SetConfigs(merged *MergedConfigs) *Snapshot {
+	return s.Update(func(next *Snapshot) {
+		next.Configs = merged.Configs
+		next.Sources = merged.Sources
+	})
+}
+
+// SetNamespace publishes a new Snapshot with injection enabled or disabled for ns
+func (s *SnapshotStore) SetNamespace(ns string, enabled bool) *Snapshot {
+	return s.Update(func(next *Snapshot) {
+		namespaces := make(map[string]bool, len(next.Namespaces)+1)
+		for k, v := range next.Namespaces {
+			namespaces[k] = v
+		}
+		if enabled {
+			namespaces[ns] = true
+		} else {
+			delete(namespaces, ns)
+		}
+		next.Namespaces = namespaces
+	})
+}
+
+// SetNamespaces publishes a new Snapshot with injection enabled for exactly the given namespaces
+func (s *SnapshotStore) SetNamespaces(namespaces []string) *Snapshot {
+	return s.Update(func(next *Snapshot) {
+		next.Namespaces = make(map[string]bool, len(namespaces))
+		for _, ns := range namespaces {
+			next.Namespaces[ns] = true
+		}
+	})
+}
+
+// SetExclusions publishes a new Snapshot where the given namespaces and the pods selected by pods are never
+// injected
+func (s *SnapshotStore) SetExclusions(namespaces []string, pods labels.Selector) *Snapshot {
+	return s.Update(func(next *Snapshot) {
+		next.ExcludedNamespaces = make(map[string]bool, len(namespaces))
+		for _, ns := range namespaces {
+			next.ExcludedNamespaces[ns] = true
+		}
+		next.ExcludedPods = pods
+	})
+}
+
+// ReloadStatus describes the outcome of the latest injection config reloads. While reloads fail, the
+// last successfully loaded configs keep being served.
+type ReloadStatus struct {
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	FailingSince        time.Time `json:"failingSince"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+}
+
+// Ready reports whether injection configs can be served, which is only false when every reload so far failed
+func (s ReloadStatus) Ready() bool {
+	return !s.LastSuccess.IsZero() || s.ConsecutiveFailures == 0
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshotStore_SetNamespaceDoesNotMutatePublished(t *testing.T) {
+	store := NewSnapshotStore()
+
+	first := store.SetNamespace("dbservice", true)
+	second := store.SetNamespace("payment", true)
+	third := store.SetNamespace("dbservice", false)
+
+	if first.Generation != 1 || second.Generation != 2 || third.Generation != 3 {
+		t.Errorf("generations got = %d, %d, %d; want = 1, 2, 3", first.Generation, second.Generation, third.Generation)
+	}
+	if len(first.Namespaces) != 1 || !first.Namespaces["dbservice"] {
+		t.Errorf("first snapshot was modified: %v", first.Namespaces)
+	}
+	if len(second.Namespaces) != 2 {
+		t.Errorf("second snapshot was modified: %v", second.Namespaces)
+	}
+	if third.Namespaces["dbservice"] || !third.Namespaces["payment"] {
+		t.Errorf("third snapshot got = %v; want only payment", third.Namespaces)
+	}
+	if store.Load() != third {
+		t.Errorf("Load() did not return the last published snapshot")
+	}
+}
+
+func TestSnapshotStore_ConcurrentUpdates(t *testing.T) {
+	store := NewSnapshotStore()
+	injConfigs := map[string]*InjectionConfig{"/spec/hostNetwork": {}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			store.SetInjConfigs(injConfigs)
+		}()
+		go func() {
+			defer wg.Done()
+			snapshot := store.Load()
+			for range snapshot.Namespaces {
+			}
+			for range snapshot.Configs {
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := store.Load().Generation; got != 50 {
+		t.Errorf("Generation got = %d; want = 50", got)
+	}
+}
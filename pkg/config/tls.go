@@ -0,0 +1,56 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts a version such as "1.2" or "VersionTLS12" to its crypto/tls constant
+func ParseTLSVersion(version string) (uint16, error) {
+	name := strings.TrimPrefix(strings.TrimSpace(version), "VersionTLS")
+	if len(name) == 2 {
+		name = name[:1] + "." + name[1:]
+	}
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid tls-min-version passed: %s Should be one of: 1.0, 1.1, 1.2, 1.3", version)
+}
+
+// ParseTLSCipherSuites converts IANA cipher suite names to their crypto/tls IDs. An empty list means the
+// Go defaults should be used and returns nil. Suites crypto/tls reports as insecure are rejected.
+func ParseTLSCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if insecure[name] {
+			return nil, fmt.Errorf("insecure tls cipher suite: %s", name)
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	cases := map[string]uint16{
+		"1.2":          tls.VersionTLS12,
+		"VersionTLS13": tls.VersionTLS13,
+		" 1.1 ":        tls.VersionTLS11,
+	}
+	for in, want := range cases {
+		got, err := ParseTLSVersion(in)
+		if err != nil {
+			t.Errorf("ParseTLSVersion(%q) returned error: %v", in, err)
+		} else if got != want {
+			t.Errorf("ParseTLSVersion(%q) got = %x; want = %x", in, got, want)
+		}
+	}
+
+	if _, err := ParseTLSVersion("2.0"); err == nil {
+		t.Error("ParseTLSVersion(\"2.0\") expected an error")
+	}
+}
+
+func TestParseTLSCipherSuites(t *testing.T) {
+	got, err := ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("ParseTLSCipherSuites() got = %v; want = %v", got, want)
+	}
+
+	if _, err := ParseTLSCipherSuites([]string{"TLS_NOT_A_SUITE"}); err == nil {
+		t.Error("ParseTLSCipherSuites() expected an error for an unknown suite")
+	}
+	if _, err := ParseTLSCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil || !strings.Contains(err.Error(), "insecure") {
+		t.Errorf("ParseTLSCipherSuites() got = %v; want an error for an insecure suite", err)
+	}
+	if got, err := ParseTLSCipherSuites(nil); err != nil || got != nil {
+		t.Errorf("ParseTLSCipherSuites(nil) got = %v, %v; want = nil, nil", got, err)
+	}
+}
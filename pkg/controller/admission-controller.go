@@ -1,13 +1,18 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -20,31 +25,59 @@ type PatchOperation struct {
 
 const jsonContentType = `application/json`
 
-type admitFunc func(*admissionv1.AdmissionRequest, map[string]*config.InjectionConfig, map[string]bool) ([]PatchOperation, *bool, error)
+// ConfigDecision explains what an admit function did with the injection configs of a single path
+type ConfigDecision struct {
+	Key     string `json:"key"`
+	Applied bool   `json:"applied"`
+	Reason  string `json:"reason,omitempty"`
+	// Sources are the IDs of the sources whose configs of Key were applied
+	Sources []string `json:"sources,omitempty"`
+	// Warnings are the fields of the configs of Key that could not be injected
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AdmitResult is the decision of an admit function for one request. Mutating admit functions fill Patches,
+// validating ones fill Allowed.
+type AdmitResult struct {
+	Patches []PatchOperation
+	Allowed *bool
+	// Message explains why a validating admit function did not allow the request
+	Message   string
+	Decisions []ConfigDecision
+	Warnings  []string
+}
+
+// AdmissionOutcome describes a handled AdmissionReview, for callers that want to record what was decided
+type AdmissionOutcome struct {
+	Request    *admissionv1.AdmissionRequest
+	Response   *admissionv1.AdmissionResponse
+	Result     *AdmitResult
+	Generation uint64
+}
+
+type admitFunc func(context.Context, *admissionv1.AdmissionRequest, *config.Snapshot) (*AdmitResult, error)
 
 type admissionType string
 
 const MutatingAdmission admissionType = "MUTATING"
 const ValidatingAdmission admissionType = "VALIDATING"
 
-func isSystemNamespace(ns string) bool {
-	return ns == metav1.NamespaceSystem || ns == metav1.NamespacePublic
-}
-
 // This function parses the HTTP request from admission webhook controller, and in case of a well-formed request
-// , it call a admit function corresponding that implement logic for that request. The response will be returned as
-// raw bytes
-func AdmissionControllerHandler(w http.ResponseWriter, r *http.Request, admit admitFunc, t admissionType, injConfigs map[string]*config.InjectionConfig, namespaces map[string]bool) ([]byte, error) {
+// , it call a admit function corresponding that implement logic for that request. The admit function only ever sees
+// the given snapshot, so a concurrent config reload cannot change the configs in the middle of a request, and
+// stops once the request context is done. The response will be returned as raw bytes, together with the outcome
+// of the admission once the request could be parsed
+func AdmissionControllerHandler(w http.ResponseWriter, r *http.Request, admit admitFunc, t admissionType, snapshot *config.Snapshot) ([]byte, *AdmissionOutcome, error) {
 
 	// Step 1: Request validation. Only handle POST requests with a body and json content type.
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
-		return nil, fmt.Errorf("invalid method %s, only POST requests are allowed", r.Method)
+		return nil, nil, fmt.Errorf("invalid method %s, only POST requests are allowed", r.Method)
 	}
 
 	if contentType := r.Header.Get("Content-Type"); contentType != jsonContentType {
 		w.WriteHeader(http.StatusBadRequest)
-		return nil, fmt.Errorf("unsupported content type %s, only %s is supported", contentType, jsonContentType)
+		return nil, nil, fmt.Errorf("unsupported content type %s, only %s is supported", contentType, jsonContentType)
 	}
 
 	// Step 2: Parse the AdmissionReview request.
@@ -53,68 +86,137 @@ func AdmissionControllerHandler(w http.ResponseWriter, r *http.Request, admit ad
 	// body, _ := ioutil.ReadAll(r.Body)
 	// log.Info().Msgf("Admission request: \n%s", body)
 
+	_, span := tracing.Start(r.Context(), "DecodeAdmissionReview")
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&admissionReviewReq)
+	tracing.End(span, err)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		return nil, fmt.Errorf("could not deserialize request: %v", err)
+		return nil, nil, fmt.Errorf("could not deserialize request: %v", err)
 	} else if admissionReviewReq.Request == nil {
 		w.WriteHeader(http.StatusBadRequest)
-		return nil, errors.New("malformed admission review: request is nil")
+		return nil, nil, errors.New("malformed admission review: request is nil")
+	}
+
+	if t != MutatingAdmission && t != ValidatingAdmission {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, fmt.Errorf("admission type must be %q or %q", MutatingAdmission, ValidatingAdmission)
 	}
 
-	// Step 3: Construct the AdmissionReview response.
+	// Step 3: Run the admission and construct the AdmissionReview response.
+	outcome, err := Admit(r.Context(), admissionReviewReq.Request, admit, t, snapshot)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, err
+	}
 	admissionReviewResponse := admissionv1.AdmissionReview{
-		Response: &admissionv1.AdmissionResponse{
-			UID: admissionReviewReq.Request.UID,
-		},
+		Response: outcome.Response,
 	}
 
 	admissionReviewResponse.APIVersion = "admission.k8s.io/v1"
 	admissionReviewResponse.Kind = "AdmissionReview"
 
-	var patchOps []PatchOperation
-	var allowed *bool
+	// Return the AdmissionReview with a response as JSON
+	bytes, err := json.Marshal(&admissionReviewResponse)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling response: %v", err)
+	}
+	return bytes, outcome, nil
+}
 
-	// Apply admit function only for non-system namespaces
-	if !isSystemNamespace(admissionReviewReq.Request.Namespace) {
-		if t == MutatingAdmission {
-			patchOps, _, err = admit(admissionReviewReq.Request, injConfigs, namespaces)
-		} else if t == ValidatingAdmission {
-			_, allowed, err = admit(admissionReviewReq.Request, injConfigs, namespaces)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			return nil, fmt.Errorf("admission type must be %q or %q", MutatingAdmission, ValidatingAdmission)
+// Admit runs the admit function against an already decoded AdmissionRequest and builds the AdmissionResponse.
+// Every line logged through tracing.Logger during the admission carries the fields of WithRequestLogger.
+// Pods in excluded namespaces are never mutated, every config of the snapshot is reported as skipped for them.
+// The returned error is only set when no response could be built at all.
+func Admit(ctx context.Context, req *admissionv1.AdmissionRequest, admit admitFunc, t admissionType, snapshot *config.Snapshot) (*AdmissionOutcome, error) {
+	ctx = WithRequestLogger(ctx, req)
+	var err error
+	result := &AdmitResult{}
+	outcome := &AdmissionOutcome{
+		Request:    req,
+		Response:   &admissionv1.AdmissionResponse{UID: req.UID},
+		Result:     result,
+		Generation: snapshot.Generation,
+	}
+
+	// Apply admit function only for namespaces that are not excluded
+	if t == ValidatingAdmission || !snapshot.IsExcludedNamespace(req.Namespace) {
+		var admitted *AdmitResult
+		if admitted, err = admit(ctx, req, snapshot); admitted != nil {
+			result = admitted
+			outcome.Result = admitted
 		}
 	} else {
-		log.Info().Msg("Just apply configuration only for non-system namespaces")
+		tracing.Logger(ctx).Info().Msgf("Namespace %q is excluded from injection", req.Namespace)
+		for _, key := range snapshot.SortedKeys() {
+			result.Decisions = append(result.Decisions, ConfigDecision{Key: key, Reason: fmt.Sprintf("namespace %q is excluded", req.Namespace)})
+		}
 	}
+	response := outcome.Response
+	response.Warnings = result.Warnings
 	if err != nil {
-		admissionReviewResponse.Response.Allowed = false
-		admissionReviewResponse.Response.Result = &metav1.Status{
+		response.Allowed = false
+		response.Result = &metav1.Status{
 			Message: err.Error(),
 		}
 	} else {
-		if len(patchOps) != 0 {
-			patchBytes, err := json.Marshal(patchOps)
-			log.Debug().Msgf("%s", patchBytes)
+		if t == MutatingAdmission && len(result.Patches) != 0 {
+			_, span := tracing.Start(ctx, "MarshalPatch", trace.WithAttributes(attribute.Int("injector.patch.operations", len(result.Patches))))
+			patchBytes, err := json.Marshal(result.Patches)
+			tracing.End(span, err)
+			tracing.Logger(ctx).Debug().Msgf("%s", patchBytes)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
 				return nil, fmt.Errorf("could not marshal JSON patch: %v", err)
 			}
-			admissionReviewResponse.Response.Patch = patchBytes
+			response.Patch = patchBytes
 			var patchType admissionv1.PatchType = admissionv1.PatchTypeJSONPatch
-			admissionReviewResponse.Response.PatchType = &patchType
-			admissionReviewResponse.Response.Allowed = true
+			response.PatchType = &patchType
+			response.Allowed = true
 		} else {
-			admissionReviewResponse.Response.Allowed = *allowed
+			// Mutating admit functions never decide on allowed, so a request without patches is simply let through
+			response.Allowed = result.Allowed == nil || *result.Allowed
+			if !response.Allowed && result.Message != "" {
+				response.Result = &metav1.Status{Message: result.Message}
+			}
 		}
 	}
+	return outcome, nil
+}
 
-	// Return the AdmissionReview with a response as JSON
-	bytes, err := json.Marshal(&admissionReviewResponse)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling response: %v", err)
+// WithRequestLogger returns ctx carrying a logger with the UID, operation and namespace of req, and the name of
+// the pod or the kind and name of the object it admits. tracing.Logger returns that logger for ctx.
+func WithRequestLogger(ctx context.Context, req *admissionv1.AdmissionRequest) context.Context {
+	logger := log.Logger
+	if ctxLogger := zerolog.Ctx(ctx); ctxLogger.GetLevel() != zerolog.Disabled {
+		logger = *ctxLogger
+	}
+	fields := logger.With().
+		Str("uid", string(req.UID)).
+		Str("operation", string(req.Operation)).
+		Str("namespace", req.Namespace)
+	if req.Resource == podResource {
+		fields = fields.Str("pod", requestObjectName(req))
+	} else {
+		fields = fields.Str("kind", req.Kind.Kind).Str("name", requestObjectName(req))
+	}
+	logger = fields.Logger()
+	return logger.WithContext(ctx)
+}
+
+// requestObjectName names the object of req. Pods being created often only have a generateName yet.
+func requestObjectName(req *admissionv1.AdmissionRequest) string {
+	if req.Name != "" {
+		return req.Name
+	}
+	meta := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, &meta); err != nil {
+		return ""
+	}
+	if meta.Name != "" {
+		return meta.Name
+	}
+	if meta.GenerateName != "" {
+		return meta.GenerateName + "*"
 	}
-	return bytes, nil
+	return ""
 }
@@ -1,12 +1,16 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
 
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -29,67 +33,112 @@ func ApplySecurity(req *admissionv1.AdmissionRequest) ([]PatchOperation, error)
 	return []PatchOperation{}, nil
 }
 
-func ApplyNewConfig(req *admissionv1.AdmissionRequest, injConfigs map[string]*config.InjectionConfig, namespaces map[string]bool) ([]PatchOperation, *bool, error) {
-	log.Info().Msg("Applying new configs...")
+func ApplyNewConfig(ctx context.Context, req *admissionv1.AdmissionRequest, snapshot *config.Snapshot) (*AdmitResult, error) {
+	logger := tracing.Logger(ctx)
+	logger.Info().Msgf("Applying new configs from snapshot generation %d...", snapshot.Generation)
+	namespaces := snapshot.Namespaces
 
 	pod, err := decodePodResource(req)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+
+	keys := snapshot.SortedKeys()
+
+	result := &AdmitResult{}
+	skipAll := func(reason string) (*AdmitResult, error) {
+		for _, name := range keys {
+			result.Decisions = append(result.Decisions, ConfigDecision{Key: name, Reason: reason})
+		}
+		return result, nil
+	}
+
 	if val, ok := pod.Labels["k8s-injection"]; ok && val == "disable" {
-		log.Info().Msgf("does not apply configuration for pod %q because it's diabled", pod.Name)
-		return []PatchOperation{}, nil, nil
+		logger.Info().Msgf("does not apply configuration for pod %q because it's diabled", pod.Name)
+		return skipAll("pod has label k8s-injection=disable")
+	}
+	if snapshot.IsExcludedPod(pod.Labels) {
+		logger.Info().Msgf("does not apply configuration for pod %q because it matches the excluded pods", pod.Name)
+		return skipAll("pod matches exclude-pods")
 	}
 
-	log.Info().Msgf("Pod %q belong to namespace %q", pod.Name, req.Namespace)
+	logger.Info().Msgf("Pod %q belong to namespace %q", pod.Name, req.Namespace)
 	if !namespaces[req.Namespace] {
-		log.Info().Msgf("This mutating webhook only support on Pod in namepsaces %v, add label k8s-injection=enabled to enable for namespace", namespaces)
-		return []PatchOperation{}, nil, nil
+		logger.Info().Msgf("This mutating webhook only support on Pod in namepsaces %v, add label k8s-injection=enabled to enable for namespace", namespaces)
+		return skipAll(fmt.Sprintf("namespace %q is not enabled for injection", req.Namespace))
+	}
+
+	for _, name := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("stopped applying configs: %v", err)
+		}
+		configs := config.Applicable(snapshot.Configs[name], name, req.Namespace, pod.Labels)
+		if len(configs) == 0 {
+			result.Decisions = append(result.Decisions, ConfigDecision{Key: name, Reason: "selector does not match the pod"})
+			continue
+		}
+		_, span := tracing.Start(ctx, "EvaluateConfig", trace.WithAttributes(attribute.String("injector.config.key", name)))
+		decision := ConfigDecision{Key: name}
+		var patches []PatchOperation
+		var warnings []string
+		for _, cfg := range configs {
+			cfgPatches, cfgWarnings := configPatches(name, cfg.Config)
+			patches = append(patches, cfgPatches...)
+			warnings = append(warnings, cfgWarnings...)
+			if len(cfgPatches) != 0 && cfg.Source != "" {
+				decision.Sources = append(decision.Sources, cfg.Source)
+			}
+		}
+		result.Patches = append(result.Patches, patches...)
+		result.Warnings = append(result.Warnings, warnings...)
+		decision.Warnings = warnings
+
+		decision.Applied = len(patches) != 0
+		if decision.Applied {
+			decision.Reason = fmt.Sprintf("added %d patch(es)", len(patches))
+		} else if len(warnings) != 0 {
+			decision.Reason = "every field failed to marshal"
+		} else {
+			decision.Reason = "config sets no fields"
+		}
+		span.SetAttributes(attribute.Bool("injector.config.applied", decision.Applied), attribute.Int("injector.config.patches", len(patches)))
+		tracing.End(span, nil)
+		result.Decisions = append(result.Decisions, decision)
 	}
+	return result, nil
+}
 
-	getJsonObject := func(obj interface{}) (string, error) {
+// configPatches builds the patches of a single injection config, one for each field that is set, whose values
+// are the injected objects themselves. Fields that cannot be marshalled are skipped and reported as warnings.
+func configPatches(name string, cfg *config.InjectionConfig) ([]PatchOperation, []string) {
+	getJsonObject := func(obj interface{}) (json.RawMessage, error) {
 		val, err := json.Marshal(obj)
 		if err != nil {
-			return "", fmt.Errorf("could not marshal JSON patch value: %v", err)
+			return nil, fmt.Errorf("could not marshal JSON patch value: %v", err)
 		}
-		return string(val), err
+		return val, err
 	}
 
 	var patches []PatchOperation
-	for name, cfg := range injConfigs {
-		r := reflect.ValueOf(*cfg)
-		// typeOfCfg := r.Type()
-		// fmt.Printf("Num fields: %d\n", r.NumField())
-		for i := 0; i < r.NumField(); i++ {
-			// fieldName := typeOfCfg.Field(i).Name
-			if r.Field(i).Kind() == reflect.Slice && r.Field(i).Len() == 0 {
-				continue
-			}
-			if r.Field(i).Kind() == reflect.Ptr && r.Field(i).IsNil() {
-				continue
-			}
-			// fmt.Printf("Field: %s - Kind: %s\n", fieldName, r.Field(i).Kind())
-			// fmt.Println(r.Field(i).Interface())
-			if r.Field(i).Kind() == reflect.Slice && string(name[len(name)-1]) == "-" {
-				// Trường hợp add thêm 1 hoặc nhiều config vào list (đã có ít nhất 1 item)
-				for j := 0; j < r.Field(i).Len(); j++ {
-					val, err := getJsonObject(r.Field(i).Index(j).Interface())
-					if err != nil {
-						fmt.Println(err)
-						continue
-					}
-					patches = append(patches, PatchOperation{
-						Op:    "add",
-						Path:  name,
-						Value: val,
-					})
-				}
-			} else {
-
-				// Trường hợp add thêm 1 config/ 1 list config (new)
-				val, err := getJsonObject(r.Field(i).Interface())
+	var warnings []string
+	r := reflect.ValueOf(*cfg)
+	typeOfCfg := r.Type()
+	for i := 0; i < r.NumField(); i++ {
+		if !config.IsInjectedField(typeOfCfg.Field(i)) {
+			continue
+		}
+		if r.Field(i).Kind() == reflect.Slice && r.Field(i).Len() == 0 {
+			continue
+		}
+		if r.Field(i).Kind() == reflect.Ptr && r.Field(i).IsNil() {
+			continue
+		}
+		if r.Field(i).Kind() == reflect.Slice && string(name[len(name)-1]) == "-" {
+			// Trường hợp add thêm 1 hoặc nhiều config vào list (đã có ít nhất 1 item)
+			for j := 0; j < r.Field(i).Len(); j++ {
+				val, err := getJsonObject(r.Field(i).Index(j).Interface())
 				if err != nil {
-					fmt.Println(err)
+					warnings = append(warnings, fmt.Sprintf("%s: %s[%d]: %v", name, typeOfCfg.Field(i).Name, j, err))
 					continue
 				}
 				patches = append(patches, PatchOperation{
@@ -98,10 +147,22 @@ func ApplyNewConfig(req *admissionv1.AdmissionRequest, injConfigs map[string]*co
 					Value: val,
 				})
 			}
+		} else {
 
+			// Trường hợp add thêm 1 config/ 1 list config (new)
+			val, err := getJsonObject(r.Field(i).Interface())
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: %s: %v", name, typeOfCfg.Field(i).Name, err))
+				continue
+			}
+			patches = append(patches, PatchOperation{
+				Op:    "add",
+				Path:  name,
+				Value: val,
+			})
 		}
 	}
-	return patches, nil, nil
+	return patches, warnings
 }
 
 func decodePodResource(req *admissionv1.AdmissionRequest) (*corev1.Pod, error) {
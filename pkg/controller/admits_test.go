@@ -1,14 +1,20 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"os"
 	"testing"
 
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -20,7 +26,7 @@ func TestAddNewConfig(t *testing.T) {
 	want := []PatchOperation{{
 		Op:    "add",
 		Path:  "/spec/containers/0/readinessProbe",
-		Value: "{\"httpGet\":{\"path\":\"/healthz\",\"port\":3990},\"initialDelaySeconds\":5,\"timeoutSeconds\":5,\"periodSeconds\":10,\"successThreshold\":2,\"failureThreshold\":3}",
+		Value: json.RawMessage(`{"httpGet":{"path":"/healthz","port":3990},"initialDelaySeconds":5,"timeoutSeconds":5,"periodSeconds":10,"successThreshold":2,"failureThreshold":3}`),
 	}}
 
 	byteValues, err := os.ReadFile(admissionReqFilePath)
@@ -50,12 +56,236 @@ func TestAddNewConfig(t *testing.T) {
 		"dbservice": true,
 	}
 
-	got, _, err := ApplyNewConfig(req.Request, injConfig, namespaces)
+	got, err := ApplyNewConfig(context.Background(), req.Request, &config.Snapshot{Configs: config.ScopedConfigs(injConfig), Namespaces: namespaces})
 	if err != nil {
 		t.Errorf("Apply new config failed")
 	} else {
-		if diff := cmp.Diff(want, got); diff != "" {
+		if diff := cmp.Diff(want, got.Patches); diff != "" {
 			t.Errorf("ApplyNewConfig() mismatch (-want +got):\n%s", diff)
 		}
 	}
 }
+
+func TestAddNewConfig_StopsWhenContextDone(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	snapshot := &config.Snapshot{
+		Configs:    config.ScopedConfigs(map[string]*config.InjectionConfig{"/spec/hostPID": {HostPID: new(bool)}}),
+		Namespaces: map[string]bool{"dbservice": true},
+	}
+	if result, err := ApplyNewConfig(ctx, req.Request, snapshot); err == nil {
+		t.Errorf("ApplyNewConfig() with a cancelled context got %+v; want an error", result)
+	}
+}
+
+func TestAddNewConfig_Selectors(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	snapshot := &config.Snapshot{
+		Configs: map[string][]config.ScopedConfig{
+			"/spec/hostPID": {{
+				Config:   &config.InjectionConfig{HostPID: new(bool)},
+				Selector: &config.ConfigSelector{Pods: labels.SelectorFromSet(labels.Set{"app": "pod-with-defaults"})},
+			}},
+			"/spec/hostNetwork": {{
+				Config:   &config.InjectionConfig{HostNetwork: new(bool)},
+				Selector: &config.ConfigSelector{Pods: labels.SelectorFromSet(labels.Set{"app": "other"})},
+			}},
+			"/spec/shareProcessNamespace": {{
+				Config:   &config.InjectionConfig{HostPID: new(bool)},
+				Selector: &config.ConfigSelector{Namespaces: []string{"payment"}},
+			}},
+		},
+		Namespaces: map[string]bool{"dbservice": true},
+	}
+	got, err := ApplyNewConfig(context.Background(), req.Request, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := map[string]bool{}
+	for _, decision := range got.Decisions {
+		applied[decision.Key] = decision.Applied
+	}
+	want := map[string]bool{"/spec/hostPID": true, "/spec/hostNetwork": false, "/spec/shareProcessNamespace": false}
+	if diff := cmp.Diff(want, applied); diff != "" {
+		t.Errorf("ApplyNewConfig() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddNewConfig_SourcesOfAPath(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	hostPID := true
+	volume := func(name string) *config.InjectionConfig {
+		return &config.InjectionConfig{Volumes: []corev1.Volume{{Name: name}}}
+	}
+	snapshot := &config.Snapshot{
+		Configs: map[string][]config.ScopedConfig{
+			"/spec/hostPID": {
+				{Config: &config.InjectionConfig{HostPID: &hostPID}, Selector: &config.ConfigSelector{Namespaces: []string{"payment"}}, Source: "ConfigMap/payment/k8s-injector"},
+				{Config: &config.InjectionConfig{HostPID: new(bool)}, Selector: &config.ConfigSelector{Namespaces: []string{"dbservice"}}, Source: "ConfigMap/dbservice/k8s-injector"},
+				{Config: &config.InjectionConfig{HostPID: &hostPID}, Source: "ConfigMap/kube-system/k8s-injector"},
+			},
+			"/spec/volumes/-": {
+				{Config: volume("shared"), Source: "ConfigMap/kube-system/k8s-injector"},
+				{Config: volume("payment"), Selector: &config.ConfigSelector{Namespaces: []string{"payment"}}, Source: "ConfigMap/payment/k8s-injector"},
+				{Config: volume("db"), Selector: &config.ConfigSelector{Namespaces: []string{"dbservice"}}, Source: "ConfigMap/dbservice/k8s-injector"},
+			},
+		},
+		Namespaces: map[string]bool{"dbservice": true},
+	}
+	got, err := ApplyNewConfig(context.Background(), req.Request, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PatchOperation{
+		{Op: "add", Path: "/spec/hostPID", Value: json.RawMessage("false")},
+		{Op: "add", Path: "/spec/volumes/-", Value: json.RawMessage(`{"name":"shared"}`)},
+		{Op: "add", Path: "/spec/volumes/-", Value: json.RawMessage(`{"name":"db"}`)},
+	}
+	if diff := cmp.Diff(want, got.Patches); diff != "" {
+		t.Errorf("ApplyNewConfig() patches mismatch (-want +got):\n%s", diff)
+	}
+	sources := map[string][]string{}
+	for _, decision := range got.Decisions {
+		sources[decision.Key] = decision.Sources
+	}
+	wantSources := map[string][]string{
+		"/spec/hostPID":   {"ConfigMap/dbservice/k8s-injector"},
+		"/spec/volumes/-": {"ConfigMap/kube-system/k8s-injector", "ConfigMap/dbservice/k8s-injector"},
+	}
+	if diff := cmp.Diff(wantSources, sources); diff != "" {
+		t.Errorf("ApplyNewConfig() decision sources mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAdmit_Exclusions(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	snapshot := func(mutate func(*config.Snapshot)) *config.Snapshot {
+		s := &config.Snapshot{
+			Configs:    config.ScopedConfigs(map[string]*config.InjectionConfig{"/spec/hostPID": {HostPID: new(bool)}}),
+			Namespaces: map[string]bool{"dbservice": true},
+		}
+		mutate(s)
+		return s
+	}
+	tests := []struct {
+		name     string
+		snapshot *config.Snapshot
+		want     bool
+	}{
+		{name: "no exclusions", snapshot: snapshot(func(*config.Snapshot) {}), want: true},
+		{name: "excluded namespace", snapshot: snapshot(func(s *config.Snapshot) { s.ExcludedNamespaces = map[string]bool{"dbservice": true} }), want: false},
+		{name: "excluded pod", snapshot: snapshot(func(s *config.Snapshot) {
+			s.ExcludedPods = labels.SelectorFromSet(labels.Set{"app": "pod-with-defaults"})
+		}), want: false},
+		{name: "other pods excluded", snapshot: snapshot(func(s *config.Snapshot) { s.ExcludedPods = labels.SelectorFromSet(labels.Set{"app": "other"}) }), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, err := Admit(context.Background(), req.Request, ApplyNewConfig, MutatingAdmission, tt.snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(outcome.Response.Patch) != 0; got != tt.want {
+				t.Errorf("Admit() patched = %v; want %v (decisions %+v)", got, tt.want, outcome.Result.Decisions)
+			}
+		})
+	}
+}
+
+func TestAdmit_ResponsePatchAppliesToPod(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	hostPID := true
+	snapshot := &config.Snapshot{
+		Configs: config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/hostPID":   {HostPID: &hostPID},
+			"/spec/volumes/-": {Volumes: []corev1.Volume{{Name: "shared"}}},
+		}),
+		Namespaces: map[string]bool{"dbservice": true},
+	}
+	outcome, err := Admit(context.Background(), req.Request, ApplyNewConfig, MutatingAdmission, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The patch is applied as the API server does, with no decoding of its values
+	patch, err := jsonpatch.DecodePatch(outcome.Response.Patch)
+	if err != nil {
+		t.Fatalf("could not decode response patch: %v", err)
+	}
+	mutated, err := patch.Apply(req.Request.Object.Raw)
+	if err != nil {
+		t.Fatalf("could not apply response patch: %v", err)
+	}
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(mutated, &pod); err != nil {
+		t.Fatalf("mutated pod is not a pod: %v\n%s", err, mutated)
+	}
+	if !pod.Spec.HostPID {
+		t.Errorf("hostPID = %v; want true", pod.Spec.HostPID)
+	}
+	if last := pod.Spec.Volumes[len(pod.Spec.Volumes)-1]; last.Name != "shared" {
+		t.Errorf("volumes = %+v; want the shared volume appended", pod.Spec.Volumes)
+	}
+}
+
+func TestAdmit_RequestLogger(t *testing.T) {
+	req := admissionv1.AdmissionReview{}
+	byteValues, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	json.Unmarshal(byteValues, &req)
+
+	out := bytes.Buffer{}
+	logger := zerolog.New(&out)
+	ctx := logger.WithContext(context.Background())
+	ctx = WithRequestLogger(ctx, req.Request)
+	tracing.Logger(ctx).Info().Msg("admitting")
+
+	line := map[string]string{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("could not decode log line %q: %v", out.String(), err)
+	}
+	for field, want := range map[string]string{
+		"uid":       string(req.Request.UID),
+		"operation": string(req.Request.Operation),
+		"namespace": req.Request.Namespace,
+		"pod":       requestObjectName(req.Request),
+	} {
+		if line[field] != want || want == "" {
+			t.Errorf("log field %s = %q; want %q", field, line[field], want)
+		}
+	}
+}
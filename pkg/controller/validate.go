@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/dungdev1/k8s-injector/pkg/apis/v1alpha1"
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/injectionconfig"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var configMapResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+
+var injectionConfigResource = metav1.GroupVersionResource{Group: v1alpha1.GroupName, Version: v1alpha1.Version, Resource: v1alpha1.Resource.Resource}
+
+// samplePod is what configs are trial-applied to. It has an item in each list a config usually appends to,
+// empty lists being left out of its JSON, so that only paths that would fail on any pod are rejected.
+var samplePod = corev1.Pod{
+	TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+	ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: metav1.NamespaceDefault, Labels: map[string]string{}},
+	Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Name:         "app",
+			Image:        "busybox",
+			Env:          []corev1.EnvVar{{Name: "SAMPLE", Value: "sample"}},
+			VolumeMounts: []corev1.VolumeMount{{Name: "sample", MountPath: "/sample"}},
+			Ports:        []corev1.ContainerPort{{ContainerPort: 8080}},
+		}},
+		InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+		Volumes:        []corev1.Volume{{Name: "sample", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+	},
+}
+
+// ValidateConfigs rejects injection ConfigMaps and InjectionConfig objects that would not load or not apply:
+// payloads are decoded strictly, keys must be valid patch paths, configs must extend a loaded config without
+// a cycle, and every config is trial-applied to a sample pod. Deletions and other resources are always allowed.
+func ValidateConfigs(ctx context.Context, req *admissionv1.AdmissionRequest, snapshot *config.Snapshot) (*AdmitResult, error) {
+	allowed := true
+	result := &AdmitResult{Allowed: &allowed}
+	if req.Operation == admissionv1.Delete {
+		return result, nil
+	}
+
+	var injs map[string]*config.InjectionConfig
+	var errs, warnings []string
+	source := config.ConfigSource{Namespace: req.Namespace, Name: req.Name}
+	switch req.Resource {
+	case configMapResource:
+		source.Kind = "ConfigMap"
+		injs, warnings, errs = validateConfigMap(req.Object.Raw)
+	case injectionConfigResource:
+		source.Kind = v1alpha1.Kind
+		ic, err := injectionconfig.Decode(req.Object.Raw)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			inj := ic.Spec.InjectionConfig
+			injs = map[string]*config.InjectionConfig{ic.Spec.Path: &inj}
+		}
+	default:
+		return result, nil
+	}
+	if len(errs) == 0 {
+		var resolved map[string]*config.InjectionConfig
+		resolved, errs = resolveExtends(injs, snapshot, source)
+		if len(errs) == 0 {
+			errs = trialApply(ctx, resolved)
+		}
+	}
+
+	result.Warnings = warnings
+	for _, key := range sortedKeys(injs) {
+		result.Decisions = append(result.Decisions, ConfigDecision{Key: key, Applied: len(errs) == 0})
+	}
+	if len(errs) != 0 {
+		allowed = false
+		result.Message = fmt.Sprintf("invalid injection config: %s", strings.Join(errs, "; "))
+		tracing.Logger(ctx).Info().Msgf("Rejected %s %s/%s: %s", req.Kind.Kind, req.Namespace, req.Name, result.Message)
+	}
+	return result, nil
+}
+
+// validateConfigMap loads every key of a ConfigMap strictly, and returns the loaded configs with the warnings
+// and errors of its keys
+func validateConfigMap(raw []byte) (map[string]*config.InjectionConfig, []string, []string) {
+	cfm := corev1.ConfigMap{}
+	if err := json.Unmarshal(raw, &cfm); err != nil {
+		return nil, nil, []string{fmt.Sprintf("could not deserialize configmap: %v", err)}
+	}
+
+	injs := map[string]*config.InjectionConfig{}
+	var warnings, errs []string
+	keys := make([]string, 0, len(cfm.Data))
+	for key := range cfm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path, err := config.KeyToPath(key)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		inj, keyWarnings, err := config.DecodeInjectionConfig([]byte(cfm.Data[key]), true)
+		if err == nil {
+			err = config.ValidatePath(path, inj)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		for _, warning := range keyWarnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", key, warning))
+		}
+		injs[path] = inj
+	}
+	return injs, warnings, errs
+}
+
+// resolveExtends resolves injs, the configs of source, together with the configs of the other sources in
+// snapshot, which they may extend. Only the resolved configs of injs are returned.
+func resolveExtends(injs map[string]*config.InjectionConfig, snapshot *config.Snapshot, source config.ConfigSource) (map[string]*config.InjectionConfig, []string) {
+	// The configs of source come first so that none of them is dropped as overridden
+	sources := []config.SourceConfigs{{Source: source, Priority: math.MaxInt32, InjConfigs: injs}}
+	for _, loaded := range snapshot.Sources {
+		if loaded.ID() == source.ID() {
+			continue
+		}
+		other := config.SourceConfigs{Source: loaded, InjConfigs: map[string]*config.InjectionConfig{}}
+		for path, configs := range snapshot.Configs {
+			for _, cfg := range configs {
+				if cfg.Source != loaded.ID() {
+					continue
+				}
+				// Loaded configs are already resolved, they must not be merged over their base again
+				base := *cfg.Config
+				base.Extends = nil
+				other.InjConfigs[path] = &base
+				other.Selector = cfg.Selector
+			}
+		}
+		sources = append(sources, other)
+	}
+
+	merged := config.MergeSources(sources)
+	out := make(map[string]*config.InjectionConfig, len(injs))
+	var errs []string
+	for _, key := range sortedKeys(injs) {
+		if err, ok := merged.Sources[0].LoadErrors[key]; ok {
+			errs = append(errs, fmt.Sprintf("%s: %s", key, err))
+			continue
+		}
+		for _, cfg := range merged.Configs[key] {
+			if cfg.Source == source.ID() {
+				out[key] = cfg.Config
+			}
+		}
+	}
+	return out, errs
+}
+
+// trialApply mutates the sample pod with every config of injs and checks that the result is still a pod
+func trialApply(ctx context.Context, injs map[string]*config.InjectionConfig) []string {
+	var errs []string
+	for _, key := range sortedKeys(injs) {
+		if err := TrialApply(ctx, config.ScopedConfigs(map[string]*config.InjectionConfig{key: injs[key]}), &samplePod); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	return errs
+}
+
+// SamplePod returns a copy of the pod ValidateConfigs trial-applies configs to
+func SamplePod() *corev1.Pod {
+	return samplePod.DeepCopy()
+}
+
+// TrialApply mutates pod with configs, keyed by path, together, the way it would be admitted in its namespace,
+// and returns why they do not apply or leave something that is not a pod
+func TrialApply(ctx context.Context, configs map[string][]config.ScopedConfig, pod *corev1.Pod) error {
+	podJSON, err := json.Marshal(pod)
+	if err != nil {
+		return fmt.Errorf("could not marshal sample pod: %v", err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID("validate"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  podResource,
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: podJSON},
+	}
+	snapshot := &config.Snapshot{Configs: configs, Namespaces: map[string]bool{pod.Namespace: true}}
+	result, err := ApplyNewConfig(ctx, req, snapshot)
+	if err != nil {
+		return err
+	}
+	if len(result.Warnings) != 0 {
+		return fmt.Errorf("%s", strings.Join(result.Warnings, "; "))
+	}
+	if len(result.Patches) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(result.Patches)
+	if err != nil {
+		return err
+	}
+	return applyToPod(podJSON, patch)
+}
+
+func applyToPod(podJSON []byte, patch []byte) error {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return fmt.Errorf("could not decode JSON patch: %v", err)
+	}
+	mutated, err := decoded.Apply(podJSON)
+	if err != nil {
+		return fmt.Errorf("could not apply to a sample pod: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(mutated))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&corev1.Pod{}); err != nil {
+		return fmt.Errorf("sample pod is not valid once mutated: %v", err)
+	}
+	return nil
+}
+
+func sortedKeys(injs map[string]*config.InjectionConfig) []string {
+	keys := make([]string, 0, len(injs))
+	for key := range injs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/ghodss/yaml"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const configMapFilePath = "../../config/configmap.yaml"
+
+func configMapRequest(t *testing.T, data map[string]string) *admissionv1.AdmissionRequest {
+	cfm := corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: metav1.NamespaceSystem},
+		Data:       data,
+	}
+	raw, err := json.Marshal(&cfm)
+	if err != nil {
+		t.Fatalf("could not marshal configmap: %v", err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       "test",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		Resource:  configMapResource,
+		Namespace: cfm.Namespace,
+		Name:      cfm.Name,
+		Operation: admissionv1.Update,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestValidateConfigs_AcceptsShippedConfigMap(t *testing.T) {
+	body, err := os.ReadFile(configMapFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read configmap file %q", configMapFilePath)
+	}
+	cfm := corev1.ConfigMap{}
+	if err := yaml.Unmarshal(body, &cfm); err != nil {
+		t.Fatalf("could not decode %q: %v", configMapFilePath, err)
+	}
+
+	outcome, err := Admit(context.Background(), configMapRequest(t, cfm.Data), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if !outcome.Response.Allowed {
+		t.Errorf("Admit() rejected %q: %+v", configMapFilePath, outcome.Response.Result)
+	}
+	if got, want := len(outcome.Result.Decisions), len(cfm.Data); got != want {
+		t.Errorf("Admit() reported %d decisions; want %d", got, want)
+	}
+}
+
+func TestValidateConfigs_RejectsBrokenConfigMaps(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]string
+		want string
+	}{{
+		name: "unknown field",
+		data: map[string]string{".spec.containers.0.readinessProbe": "readinesProbe:\n  periodSeconds: 10\n"},
+		want: "unknown field",
+	}, {
+		name: "key without leading dot",
+		data: map[string]string{"spec.hostPID": "hostPID: true\n"},
+		want: "must start with a dot",
+	}, {
+		name: "empty path segment",
+		data: map[string]string{".spec.": "hostPID: true\n"},
+		want: "empty path segment",
+	}, {
+		name: "path missing from pods",
+		data: map[string]string{".spec.containers.3.readinessProbe": "readinessProbe:\n  periodSeconds: 10\n"},
+		want: "could not apply to a sample pod",
+	}, {
+		name: "value of the wrong type",
+		data: map[string]string{".spec.hostNetwork": "readinessProbe:\n  periodSeconds: 10\n"},
+		want: "path /spec/hostNetwork holds a bool, but the payload sets readinessProbe",
+	}, {
+		name: "unsupported apiVersion",
+		data: map[string]string{".spec.hostPID": "apiVersion: config.k8s-injector.io/v9\nkind: InjectionConfig\nspec:\n  hostPID: true\n"},
+		want: `unsupported apiVersion "config.k8s-injector.io/v9"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, err := Admit(context.Background(), configMapRequest(t, tt.data), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+			if err != nil {
+				t.Fatalf("Admit() failed: %v", err)
+			}
+			if outcome.Response.Allowed {
+				t.Fatalf("Admit() allowed %v; want it rejected", tt.data)
+			}
+			if got := outcome.Response.Result.Message; !strings.Contains(got, tt.want) {
+				t.Errorf("Admit() message = %q; want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigs_AllowsDeletes(t *testing.T) {
+	req := configMapRequest(t, map[string]string{"broken": "{"})
+	req.Operation = admissionv1.Delete
+	outcome, err := Admit(context.Background(), req, ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if !outcome.Response.Allowed {
+		t.Errorf("Admit() rejected a delete: %+v", outcome.Response.Result)
+	}
+}
+
+func TestValidateConfigs_InjectionConfig(t *testing.T) {
+	object := func(spec string) *admissionv1.AdmissionRequest {
+		raw, err := yaml.YAMLToJSON([]byte("apiVersion: k8s-injector.io/v1alpha1\nkind: InjectionConfig\nmetadata:\n  name: sidecar\n  namespace: default\nspec:\n" + spec))
+		if err != nil {
+			t.Fatalf("could not convert object: %v", err)
+		}
+		return &admissionv1.AdmissionRequest{
+			UID:       "test",
+			Resource:  injectionConfigResource,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}
+	}
+
+	outcome, err := Admit(context.Background(), object("  path: /spec/hostPID\n  hostPID: true\n"), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if !outcome.Response.Allowed {
+		t.Errorf("Admit() rejected a valid InjectionConfig: %+v", outcome.Response.Result)
+	}
+
+	outcome, err = Admit(context.Background(), object("  path: /spec/hostPID\n  hostPIDs: true\n"), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if outcome.Response.Allowed {
+		t.Errorf("Admit() allowed an InjectionConfig with a misspelt field")
+	}
+	outcome, err = Admit(context.Background(), object("  path: /spec/hostPID\n  name: base\n"), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if outcome.Response.Allowed || !strings.Contains(outcome.Response.Result.Message, "spec sets no injection field") {
+		t.Errorf("Admit() got = %+v; want an InjectionConfig setting nothing rejected", outcome.Response.Result)
+	}
+}
+
+func TestValidateConfigs_Extends(t *testing.T) {
+	hostPID := true
+	base := "base"
+	snapshot := config.NewSnapshotStore().SetConfigs(config.MergeSources([]config.SourceConfigs{{
+		Source:     config.ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "base"},
+		InjConfigs: map[string]*config.InjectionConfig{"/spec/hostPID": {Name: &base, HostPID: &hostPID}},
+	}}))
+
+	tests := []struct {
+		name string
+		data map[string]string
+		want string
+	}{{
+		name: "base of another source",
+		data: map[string]string{".spec.hostIPC": "extends: base\n"},
+	}, {
+		name: "missing base",
+		data: map[string]string{".spec.hostIPC": "extends: other\n"},
+		want: `/spec/hostIPC: extends "other", but no config is named so`,
+	}, {
+		name: "cycle",
+		data: map[string]string{".spec.hostIPC": "name: a\nextends: b\n", ".spec.hostNetwork": "name: b\nextends: a\n"},
+		want: "extends cycle",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, err := Admit(context.Background(), configMapRequest(t, tt.data), ValidateConfigs, ValidatingAdmission, snapshot)
+			if err != nil {
+				t.Fatalf("Admit() failed: %v", err)
+			}
+			if tt.want == "" {
+				if !outcome.Response.Allowed {
+					t.Errorf("Admit() rejected %v: %+v", tt.data, outcome.Response.Result)
+				}
+				return
+			}
+			if outcome.Response.Allowed {
+				t.Fatalf("Admit() allowed %v; want it rejected", tt.data)
+			}
+			if got := outcome.Response.Result.Message; !strings.Contains(got, tt.want) {
+				t.Errorf("Admit() message = %q; want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigs_WarnsAboutEmptyPayloads(t *testing.T) {
+	outcome, err := Admit(context.Background(), configMapRequest(t, map[string]string{".spec.hostPID": "name: base\n"}), ValidateConfigs, ValidatingAdmission, &config.Snapshot{})
+	if err != nil {
+		t.Fatalf("Admit() failed: %v", err)
+	}
+	if !outcome.Response.Allowed {
+		t.Fatalf("Admit() rejected an empty payload: %+v", outcome.Response.Result)
+	}
+	if want := []string{".spec.hostPID: the payload sets no injection field"}; !reflect.DeepEqual(outcome.Response.Warnings, want) {
+		t.Errorf("Admit() warnings = %q; want %q", outcome.Response.Warnings, want)
+	}
+}
+
+func TestTrialApply(t *testing.T) {
+	load := func(payload string) *config.InjectionConfig {
+		inj, err := config.LoadInjectionConfigStrict([]byte(payload))
+		if err != nil {
+			t.Fatalf("LoadInjectionConfigStrict(%q) failed: %v", payload, err)
+		}
+		return inj
+	}
+	volume := load("volumes:\n- {name: data, emptyDir: {}}\n")
+	env := load("env:\n- {name: TARGET, value: db}\n")
+	containers := load("containers:\n- {name: proxy, image: proxy:1}\n")
+
+	tests := []struct {
+		name    string
+		injs    map[string]*config.InjectionConfig
+		wantErr string
+	}{
+		{name: "appends to every list", injs: map[string]*config.InjectionConfig{"/spec/volumes/-": volume, "/spec/containers/0/env/-": env}},
+		{name: "replaced list", injs: map[string]*config.InjectionConfig{
+			"/spec/containers":         containers,
+			"/spec/containers/0/env/-": env,
+		}, wantErr: "could not apply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TrialApply(context.Background(), config.ScopedConfigs(tt.injs), SamplePod())
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("TrialApply() = %v; want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
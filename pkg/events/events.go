@@ -0,0 +1,223 @@
+// Package events records Kubernetes Events about injection configs, enabled namespaces and admitted pods, so
+// that kubectl describe shows what the injector did
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Component is the source component of every recorded Event
+const Component = "k8s-injector"
+
+// Reasons of the recorded Events
+const (
+	ReasonConfigReloaded    = "ConfigReloaded"
+	ReasonConfigLoadFailed  = "ConfigLoadFailed"
+	ReasonInjectionEnabled  = "InjectionEnabled"
+	ReasonInjectionDisabled = "InjectionDisabled"
+	ReasonInjectionFailed   = "InjectionFailed"
+	ReasonInjectionPartial  = "InjectionPartial"
+	ReasonInjectionSkipped  = "InjectionSkipped"
+)
+
+const (
+	defaultBurst         = 25
+	defaultQPS   float32 = 1. / 300
+)
+
+// Options rate-limits the Events of every object: Burst Events at once, then QPS Events per second. Both
+// default to the client-go defaults, 25 Events then one every 5 minutes.
+type Options struct {
+	Burst int
+	QPS   float32
+}
+
+// Recorder records Events through a client-go event broadcaster, which aggregates repeated Events into one
+// with a count and drops Events of objects that exceed the rate limit. A nil Recorder records nothing.
+type Recorder struct {
+	// Leading reports whether this replica records the Events of ConfigMaps and Namespaces, which every
+	// replica observes alike. Events of admitted pods are recorded by the replica that admitted them.
+	Leading func() bool
+
+	recorder    record.EventRecorder
+	broadcaster record.EventBroadcaster
+
+	mu sync.Mutex
+	// loaded is the resourceVersion of each ConfigMap the last time its Events were recorded
+	loaded map[string]string
+}
+
+// NewRecorder creates a Recorder writing Events through client
+func NewRecorder(client kubernetes.Interface, opts Options) *Recorder {
+	if opts.Burst <= 0 {
+		opts.Burst = defaultBurst
+	}
+	if opts.QPS <= 0 {
+		opts.QPS = defaultQPS
+	}
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: opts.Burst,
+		QPS:       opts.QPS,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	r := newRecorder(broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: Component}))
+	r.broadcaster = broadcaster
+	return r
+}
+
+func newRecorder(recorder record.EventRecorder) *Recorder {
+	return &Recorder{
+		recorder: recorder,
+		loaded:   map[string]string{},
+	}
+}
+
+// Shutdown stops the broadcaster, Events still queued are dropped
+func (r *Recorder) Shutdown() {
+	if r == nil || r.broadcaster == nil {
+		return
+	}
+	r.broadcaster.Shutdown()
+}
+
+func (r *Recorder) leading() bool {
+	return r.Leading == nil || r.Leading()
+}
+
+// ConfigMapLoaded records that cfm was reloaded, and a warning for each of its keys in loadErrors. A
+// ConfigMap is only reported again once its resourceVersion changed, so resyncs do not repeat its Events.
+func (r *Recorder) ConfigMapLoaded(cfm *corev1.ConfigMap, loadErrors map[string]string) {
+	if r == nil || !r.leading() {
+		return
+	}
+	id := cfm.Namespace + "/" + cfm.Name
+	r.mu.Lock()
+	seen, ok := r.loaded[id]
+	r.loaded[id] = cfm.ResourceVersion
+	r.mu.Unlock()
+	if ok && seen == cfm.ResourceVersion {
+		return
+	}
+
+	keys := make([]string, 0, len(loadErrors))
+	for key := range loadErrors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.recorder.Eventf(cfm, corev1.EventTypeWarning, ReasonConfigLoadFailed, "Cannot load injection config %q: %s", key, loadErrors[key])
+	}
+	// The first load at startup is not a reload
+	if ok {
+		r.recorder.Eventf(cfm, corev1.EventTypeNormal, ReasonConfigReloaded, "Reloaded %d of %d injection configs at resourceVersion %s",
+			len(cfm.Data)-len(loadErrors), len(cfm.Data), cfm.ResourceVersion)
+	}
+}
+
+// NamespaceInjection records that injection was enabled or disabled for namespace ns through its label
+func (r *Recorder) NamespaceInjection(ns string, enabled bool, label map[string]string) {
+	if r == nil || !r.leading() {
+		return
+	}
+	ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: ns}
+	if enabled {
+		r.recorder.Eventf(ref, corev1.EventTypeNormal, ReasonInjectionEnabled, "Injection enabled by label %s", formatLabel(label))
+	} else {
+		r.recorder.Eventf(ref, corev1.EventTypeNormal, ReasonInjectionDisabled, "Injection disabled, label %s was removed", formatLabel(label))
+	}
+}
+
+// PodAdmitted records, on the controller of the admitted pod, that injection failed, that some fields could
+// not be injected, or that none of the configs applied to it. The controller of a Deployment's pods is their
+// ReplicaSet. Pods without a controller, pods of namespaces without injection and pods that were fully
+// injected are not reported.
+func (r *Recorder) PodAdmitted(outcome *controller.AdmissionOutcome, namespaceEnabled bool) {
+	if r == nil || outcome == nil || outcome.Request.Object.Raw == nil {
+		return
+	}
+	pod := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(outcome.Request.Object.Raw, &pod); err != nil {
+		log.Debug().Msgf("Not recording an event for an undecodable pod: %v", err)
+		return
+	}
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Name:       owner.Name,
+		UID:        owner.UID,
+		Namespace:  outcome.Request.Namespace,
+	}
+
+	response := outcome.Response
+	if !response.Allowed {
+		reason := strings.Join(response.Warnings, "; ")
+		if response.Result != nil && response.Result.Message != "" {
+			reason = response.Result.Message
+		}
+		r.recorder.Eventf(ref, corev1.EventTypeWarning, ReasonInjectionFailed, "Injection failed for pod %s: %s", podName(&pod), reason)
+		return
+	}
+	if len(response.Warnings) != 0 {
+		var keys []string
+		for _, decision := range outcome.Result.Decisions {
+			if len(decision.Warnings) != 0 {
+				keys = append(keys, decision.Key)
+			}
+		}
+		r.recorder.Eventf(ref, corev1.EventTypeWarning, ReasonInjectionPartial, "Injection of pod %s was partial for %s: %s",
+			podName(&pod), strings.Join(keys, ", "), strings.Join(response.Warnings, "; "))
+		return
+	}
+	if !namespaceEnabled {
+		return
+	}
+	var reasons []string
+	seen := map[string]bool{}
+	for _, decision := range outcome.Result.Decisions {
+		if decision.Applied {
+			return
+		}
+		if !seen[decision.Reason] {
+			seen[decision.Reason] = true
+			reasons = append(reasons, decision.Reason)
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = []string{"no injection config is loaded"}
+	}
+	r.recorder.Eventf(ref, corev1.EventTypeNormal, ReasonInjectionSkipped, "No injection config applied to pod %s: %s", podName(&pod), strings.Join(reasons, "; "))
+}
+
+// podName names a pod being created, which often only has a generateName yet
+func podName(pod *metav1.PartialObjectMetadata) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName + "*"
+}
+
+func formatLabel(label map[string]string) string {
+	pairs := make([]string, 0, len(label))
+	for k, v := range label {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
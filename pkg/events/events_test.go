@@ -0,0 +1,156 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func drain(fake *record.FakeRecorder) []string {
+	var got []string
+	for {
+		select {
+		case event := <-fake.Events:
+			got = append(got, event)
+		default:
+			return got
+		}
+	}
+}
+
+func TestRecorder_ConfigMapLoaded(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	r := newRecorder(fake)
+	cfm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "k8s-injector", ResourceVersion: "1"},
+		Data:       map[string]string{".spec.hostPID": "hostPID: true", ".spec.broken": "{"},
+	}
+	loadErrors := map[string]string{".spec.broken": "yaml: line 1: did not find expected node content"}
+
+	r.ConfigMapLoaded(cfm, loadErrors)
+	got := drain(fake)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "Warning "+ReasonConfigLoadFailed) {
+		t.Fatalf("first load recorded %v; want a single %s warning", got, ReasonConfigLoadFailed)
+	}
+
+	// A resync of the same resourceVersion is not reported again
+	r.ConfigMapLoaded(cfm, loadErrors)
+	if got := drain(fake); len(got) != 0 {
+		t.Errorf("resync recorded %v; want no events", got)
+	}
+
+	cfm.ResourceVersion = "2"
+	delete(cfm.Data, ".spec.broken")
+	r.ConfigMapLoaded(cfm, nil)
+	got = drain(fake)
+	if len(got) != 1 || got[0] != "Normal "+ReasonConfigReloaded+" Reloaded 1 of 1 injection configs at resourceVersion 2" {
+		t.Errorf("update recorded %v; want a single %s event", got, ReasonConfigReloaded)
+	}
+}
+
+func TestRecorder_OnlyLeaderRecordsClusterEvents(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	r := newRecorder(fake)
+	r.Leading = func() bool { return false }
+
+	r.NamespaceInjection("dbservice", true, map[string]string{"k8s-injection": "enabled"})
+	r.ConfigMapLoaded(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector"}}, map[string]string{"x": "y"})
+	if got := drain(fake); len(got) != 0 {
+		t.Errorf("follower recorded %v; want no events", got)
+	}
+
+	r.Leading = func() bool { return true }
+	r.NamespaceInjection("dbservice", false, map[string]string{"k8s-injection": "enabled"})
+	got := drain(fake)
+	if len(got) != 1 || got[0] != "Normal "+ReasonInjectionDisabled+" Injection disabled, label k8s-injection=enabled was removed" {
+		t.Errorf("leader recorded %v; want a single %s event", got, ReasonInjectionDisabled)
+	}
+}
+
+func TestRecorder_PodAdmitted(t *testing.T) {
+	isController := true
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName: "web-7d4b9c-",
+		Namespace:    "dbservice",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "ReplicaSet",
+			Name:       "web-7d4b9c",
+			UID:        "1234",
+			Controller: &isController,
+		}},
+	}}
+	raw, err := json.Marshal(&pod)
+	if err != nil {
+		t.Fatalf("could not marshal pod: %v", err)
+	}
+	outcome := func(allowed bool, message string, decisions ...controller.ConfigDecision) *controller.AdmissionOutcome {
+		response := &admissionv1.AdmissionResponse{Allowed: allowed}
+		if message != "" {
+			response.Result = &metav1.Status{Message: message}
+		}
+		return &controller.AdmissionOutcome{
+			Request:  &admissionv1.AdmissionRequest{Namespace: pod.Namespace, Object: runtime.RawExtension{Raw: raw}},
+			Response: response,
+			Result:   &controller.AdmitResult{Decisions: decisions},
+		}
+	}
+
+	partial := outcome(true, "",
+		controller.ConfigDecision{Key: "/spec/hostPID", Applied: true},
+		controller.ConfigDecision{Key: "/spec/volumes", Applied: true, Warnings: []string{"/spec/volumes: Volumes[1]: unsupported value"}})
+	partial.Response.Warnings = []string{"/spec/volumes: Volumes[1]: unsupported value"}
+	deniedWithWarnings := outcome(false, "could not apply patch")
+	deniedWithWarnings.Response.Warnings = []string{"/spec/volumes: Volumes[1]: unsupported value"}
+
+	tests := []struct {
+		name             string
+		outcome          *controller.AdmissionOutcome
+		namespaceEnabled bool
+		want             []string
+	}{{
+		name:             "failed",
+		outcome:          outcome(false, "could not deserialize pod object"),
+		namespaceEnabled: true,
+		want:             []string{"Warning InjectionFailed Injection failed for pod web-7d4b9c-*: could not deserialize pod object"},
+	}, {
+		name:             "failed with warnings",
+		outcome:          deniedWithWarnings,
+		namespaceEnabled: true,
+		want:             []string{"Warning InjectionFailed Injection failed for pod web-7d4b9c-*: could not apply patch"},
+	}, {
+		name:             "partial",
+		outcome:          partial,
+		namespaceEnabled: true,
+		want:             []string{"Warning InjectionPartial Injection of pod web-7d4b9c-* was partial for /spec/volumes: /spec/volumes: Volumes[1]: unsupported value"},
+	}, {
+		name:             "skipped",
+		outcome:          outcome(true, "", controller.ConfigDecision{Key: "/spec/hostPID", Reason: "selector does not match the pod"}),
+		namespaceEnabled: true,
+		want:             []string{"Normal InjectionSkipped No injection config applied to pod web-7d4b9c-*: selector does not match the pod"},
+	}, {
+		name:    "namespace not enabled",
+		outcome: outcome(true, "", controller.ConfigDecision{Key: "/spec/hostPID", Reason: "namespace \"dbservice\" is not enabled for injection"}),
+	}, {
+		name:             "injected",
+		outcome:          outcome(true, "", controller.ConfigDecision{Key: "/spec/hostPID", Applied: true}),
+		namespaceEnabled: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := record.NewFakeRecorder(10)
+			newRecorder(fake).PodAdmitted(tt.outcome, tt.namespaceEnabled)
+			got := drain(fake)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("PodAdmitted() recorded %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
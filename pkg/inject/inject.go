@@ -0,0 +1,214 @@
+// Package inject runs manifests through the mutation of the webhook offline, with the injection configs of
+// ConfigMap manifests.
+package inject
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"github.com/dungdev1/k8s-injector/pkg/watcher"
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podTemplatePaths are where the pod template of each workload kind is, Pods being their own template
+var podTemplatePaths = map[string][]string{
+	"Pod":                   {},
+	"Deployment":            {"spec", "template"},
+	"StatefulSet":           {"spec", "template"},
+	"DaemonSet":             {"spec", "template"},
+	"ReplicaSet":            {"spec", "template"},
+	"ReplicationController": {"spec", "template"},
+	"Job":                   {"spec", "template"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template"},
+}
+
+// Snapshot merges the injection configs of cfms the way the webhook does, and reports the keys that could not
+// be loaded to w
+func Snapshot(ctx context.Context, cfms []*corev1.ConfigMap, w io.Writer) (*config.Snapshot, error) {
+	var sources []config.SourceConfigs
+	for _, cfm := range cfms {
+		sources = append(sources, watcher.LoadConfigMap(ctx, cfm, config.DecodingStrict))
+	}
+	merged := config.MergeSources(sources)
+	for _, source := range merged.Sources {
+		for _, key := range sortedKeys(source.LoadErrors) {
+			fmt.Fprintf(w, "%s: key %q is skipped: %s\n", DescribeSource(source), key, source.LoadErrors[key])
+		}
+	}
+	if len(merged.Configs) == 0 {
+		return nil, fmt.Errorf("no injection config could be loaded")
+	}
+	store := config.NewSnapshotStore()
+	return store.SetConfigs(merged), nil
+}
+
+// Objects mutates in place the pod templates of objs and of the items of the Lists among them, the ones in
+// namespace when they have none, and reports what was injected into each of them to w. The namespaces of objs
+// are taken as enabled for injection, objects of other kinds are left as they are.
+func Objects(ctx context.Context, objs []map[string]interface{}, namespace string, snapshot *config.Snapshot, w io.Writer) error {
+	var namespaces []string
+	for _, obj := range objs {
+		for _, item := range ListItems(obj) {
+			namespaces = append(namespaces, namespaceOf(item, namespace))
+		}
+	}
+	snapshot = withNamespaces(snapshot, namespaces)
+
+	for _, obj := range objs {
+		for _, item := range ListItems(obj) {
+			result, err := Object(ctx, item, namespace, snapshot)
+			if err != nil {
+				return fmt.Errorf("%s: %v", DescribeObject(item), err)
+			}
+			if result != nil {
+				report(w, DescribeObject(item), result)
+			}
+		}
+	}
+	return nil
+}
+
+// withNamespaces returns a copy of snapshot with injection enabled in namespaces
+func withNamespaces(snapshot *config.Snapshot, namespaces []string) *config.Snapshot {
+	next := *snapshot
+	next.Namespaces = make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		next.Namespaces[ns] = true
+	}
+	return &next
+}
+
+// Object mutates the pod template of obj in place, the one in namespace when it has none, and returns nil for
+// objects without one
+func Object(ctx context.Context, obj map[string]interface{}, namespace string, snapshot *config.Snapshot) (*controller.AdmitResult, error) {
+	kind, _ := obj["kind"].(string)
+	path, ok := podTemplatePaths[kind]
+	if !ok {
+		return nil, nil
+	}
+	template := obj
+	for _, field := range path {
+		template, _ = template[field].(map[string]interface{})
+	}
+	if template == nil {
+		return nil, fmt.Errorf("no pod template at %s", strings.Join(path, "."))
+	}
+
+	// The template is admitted as a pod being created in the namespace of obj
+	meta, _ := template["metadata"].(map[string]interface{})
+	podMeta := map[string]interface{}{}
+	for k, v := range meta {
+		podMeta[k] = v
+	}
+	podMeta["namespace"] = namespaceOf(obj, namespace)
+	if len(path) != 0 {
+		objMeta, _ := obj["metadata"].(map[string]interface{})
+		podMeta["generateName"] = fmt.Sprintf("%v-", objMeta["name"])
+	}
+	pod := map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": podMeta, "spec": template["spec"]}
+	podJSON, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal pod: %v", err)
+	}
+	name, _ := podMeta["name"].(string)
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID("inject"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: namespaceOf(obj, namespace),
+		Name:      name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: podJSON},
+	}
+	outcome, err := controller.Admit(ctx, req, controller.ApplyNewConfig, controller.MutatingAdmission, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if !outcome.Response.Allowed {
+		return nil, fmt.Errorf("the webhook would deny the pod: %s", outcome.Response.Result.Message)
+	}
+	if len(outcome.Response.Patch) == 0 {
+		return outcome.Result, nil
+	}
+
+	// The patch is applied as the API server would apply the response of the webhook
+	patch, err := jsonpatch.DecodePatch(outcome.Response.Patch)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode JSON patch: %v", err)
+	}
+	mutatedJSON, err := patch.Apply(podJSON)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply JSON patch: %v", err)
+	}
+	mutated := map[string]interface{}{}
+	if err := json.Unmarshal(mutatedJSON, &mutated); err != nil {
+		return nil, err
+	}
+	mutatedMeta, _ := mutated["metadata"].(map[string]interface{})
+	if _, ok := meta["namespace"]; !ok {
+		delete(mutatedMeta, "namespace")
+	}
+	if _, ok := meta["generateName"]; !ok {
+		delete(mutatedMeta, "generateName")
+	}
+	if len(mutatedMeta) != 0 || meta != nil {
+		template["metadata"] = mutatedMeta
+	}
+	template["spec"] = mutated["spec"]
+	return outcome.Result, nil
+}
+
+// report tells w which configs were injected into an object
+func report(w io.Writer, name string, result *controller.AdmitResult) {
+	var applied []string
+	for _, decision := range result.Decisions {
+		if decision.Applied {
+			applied = append(applied, decision.Key)
+		}
+	}
+	if len(applied) == 0 {
+		fmt.Fprintf(w, "%s: nothing injected\n", name)
+	} else {
+		fmt.Fprintf(w, "%s: injected %s\n", name, strings.Join(applied, ", "))
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "%s: warning: %s\n", name, warning)
+	}
+}
+
+// namespaceOf returns the namespace of obj, or namespace when it has none
+func namespaceOf(obj map[string]interface{}, namespace string) string {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	if ns, ok := meta["namespace"].(string); ok && ns != "" {
+		return ns
+	}
+	return namespace
+}
+
+// DescribeSource names source by its kind, namespace and name, such as ConfigMap kube-system/k8s-injector
+func DescribeSource(source config.ConfigSource) string {
+	if source.Namespace == "" {
+		return source.Kind + " " + source.Name
+	}
+	return fmt.Sprintf("%s %s/%s", source.Kind, source.Namespace, source.Name)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
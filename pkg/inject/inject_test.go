@@ -0,0 +1,266 @@
+package inject
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const sidecar = "containers:\n- name: sidecar\n  image: sidecar\n"
+
+const podSpec = `
+    spec:
+      containers:
+      - name: app
+        image: app`
+
+func sidecarSnapshot(t *testing.T) *config.Snapshot {
+	cfm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: metav1.NamespaceSystem},
+		Data:       map[string]string{".spec.containers.-": sidecar},
+	}
+	snapshot, err := Snapshot(context.Background(), []*corev1.ConfigMap{cfm}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	return snapshot
+}
+
+func decode(t *testing.T, manifest string) []map[string]interface{} {
+	objs, err := DecodeDocuments(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("DecodeDocuments() = %v", err)
+	}
+	return objs
+}
+
+// templateAt returns the pod template under path of obj
+func templateAt(obj map[string]interface{}, path []string) map[string]interface{} {
+	template := obj
+	for _, field := range path {
+		template, _ = template[field].(map[string]interface{})
+	}
+	return template
+}
+
+// containersAt returns the names of the containers of the pod template under path of obj
+func containersAt(obj map[string]interface{}, path []string) []string {
+	spec, _ := templateAt(obj, path)["spec"].(map[string]interface{})
+	containers, _ := spec["containers"].([]interface{})
+	var names []string
+	for _, c := range containers {
+		container, _ := c.(map[string]interface{})
+		names = append(names, container["name"].(string))
+	}
+	return names
+}
+
+func TestObject(t *testing.T) {
+	workload := func(kind, templateKey string) string {
+		return "apiVersion: apps/v1\nkind: " + kind + "\nmetadata:\n  name: web\n  namespace: payment\nspec:\n  " + templateKey + ":" + podSpec
+	}
+	tests := []struct {
+		name       string
+		manifest   string
+		path       []string
+		containers []string
+		wantErr    string
+		unchanged  bool
+	}{
+		{
+			name:       "Pod",
+			manifest:   "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n" + strings.ReplaceAll(podSpec, "\n    ", "\n"),
+			path:       []string{},
+			containers: []string{"app", "sidecar"},
+		},
+		{name: "Deployment", manifest: workload("Deployment", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{name: "StatefulSet", manifest: workload("StatefulSet", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{name: "DaemonSet", manifest: workload("DaemonSet", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{name: "ReplicaSet", manifest: workload("ReplicaSet", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{name: "ReplicationController", manifest: workload("ReplicationController", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{name: "Job", manifest: workload("Job", "template"), path: []string{"spec", "template"}, containers: []string{"app", "sidecar"}},
+		{
+			name:       "CronJob",
+			manifest:   "apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: web\nspec:\n  jobTemplate:\n    spec:\n      template:" + strings.ReplaceAll(podSpec, "\n    ", "\n        "),
+			path:       []string{"spec", "jobTemplate", "spec", "template"},
+			containers: []string{"app", "sidecar"},
+		},
+		{name: "unsupported kind", manifest: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  ports:\n  - port: 80", unchanged: true},
+		{name: "workload without a template", manifest: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: 1", wantErr: "no pod template at spec.template"},
+	}
+	snapshot := withNamespaces(sidecarSnapshot(t), []string{"default", "payment"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := decode(t, tt.manifest)[0]
+			original := decode(t, tt.manifest)[0]
+			result, err := Object(context.Background(), obj, metav1.NamespaceDefault, snapshot)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Object() err = %v; want an error containing %q", err, tt.wantErr)
+			}
+			if tt.unchanged {
+				if result != nil || !reflect.DeepEqual(obj, original) {
+					t.Errorf("Object() = %+v, %v; want nil and the object left as it is", result, obj)
+				}
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got := containersAt(obj, tt.path); !reflect.DeepEqual(got, tt.containers) {
+				t.Errorf("containers = %v; want %v", got, tt.containers)
+			}
+			meta, _ := templateAt(obj, tt.path)["metadata"].(map[string]interface{})
+			if _, ok := meta["namespace"]; ok && len(tt.path) != 0 {
+				t.Errorf("the namespace of the pod was added to the template: %v", meta)
+			}
+			if _, ok := meta["generateName"]; ok {
+				t.Errorf("the generated name of the pod was added to the template: %v", meta)
+			}
+		})
+	}
+}
+
+func TestObject_MatchesWebhookPatch(t *testing.T) {
+	cfm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: metav1.NamespaceSystem},
+		Data: map[string]string{
+			".spec.containers.-": sidecar,
+			".spec.hostPID":      "hostPID: false\n",
+		},
+	}
+	snapshot, err := Snapshot(context.Background(), []*corev1.ConfigMap{cfm}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	snapshot = withNamespaces(snapshot, []string{"payment"})
+	manifest := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n  namespace: payment\n" + strings.ReplaceAll(podSpec, "\n    ", "\n")
+
+	// What the API server makes of the pod with the response of the webhook
+	podJSON, err := json.Marshal(decode(t, manifest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID("webhook"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: "payment",
+		Name:      "web",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: podJSON},
+	}
+	outcome, err := controller.Admit(context.Background(), req, controller.ApplyNewConfig, controller.MutatingAdmission, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := jsonpatch.DecodePatch(outcome.Response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutatedJSON, err := patch.Apply(podJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{}
+	if err := json.Unmarshal(mutatedJSON, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := decode(t, manifest)[0]
+	if _, err := Object(context.Background(), obj, metav1.NamespaceDefault, snapshot); err != nil {
+		t.Fatalf("Object() = %v", err)
+	}
+	if !reflect.DeepEqual(obj["spec"], want["spec"]) {
+		t.Errorf("Object() spec = %v; want the spec the webhook response makes, %v", obj["spec"], want["spec"])
+	}
+	if spec, _ := obj["spec"].(map[string]interface{}); spec["hostPID"] != false {
+		t.Errorf("hostPID = %#v; want a bool", spec["hostPID"])
+	}
+}
+
+func TestObjects_MultipleDocuments(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: payment
+spec:
+  template:` + podSpec + `
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: debug
+  spec:
+    containers:
+    - name: app
+      image: app
+`
+	objs := decode(t, manifest)
+	if len(objs) != 2 {
+		t.Fatalf("DecodeDocuments() = %d documents; want the empty one skipped", len(objs))
+	}
+	reported := &bytes.Buffer{}
+	if err := Objects(context.Background(), objs, metav1.NamespaceDefault, sidecarSnapshot(t), reported); err != nil {
+		t.Fatalf("Objects() = %v", err)
+	}
+
+	if got := containersAt(objs[0], []string{"spec", "template"}); !reflect.DeepEqual(got, []string{"app", "sidecar"}) {
+		t.Errorf("Deployment containers = %v; want the sidecar injected", got)
+	}
+	items := ListItems(objs[1])
+	if got := containersAt(items[1], nil); !reflect.DeepEqual(got, []string{"app", "sidecar"}) {
+		t.Errorf("Pod of the List containers = %v; want the sidecar injected in the default namespace", got)
+	}
+	wantReport := "Deployment payment/web: injected /spec/containers/-\nPod debug: injected /spec/containers/-\n"
+	if reported.String() != wantReport {
+		t.Errorf("report = %q; want %q, nothing for the Service", reported.String(), wantReport)
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteDocuments(out, objs); err != nil {
+		t.Fatalf("WriteDocuments() = %v", err)
+	}
+	if written := decode(t, out.String()); !reflect.DeepEqual(written, objs) {
+		t.Errorf("WriteDocuments() wrote %s; want the documents read back as they are", out.String())
+	}
+}
+
+func TestSnapshot_ReportsSkippedKeys(t *testing.T) {
+	cfm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: metav1.NamespaceSystem},
+		Data:       map[string]string{".spec.hostPID": "hostPID: [", ".spec.hostNetwork": "hostNetwork: true"},
+	}
+	reported := &bytes.Buffer{}
+	if _, err := Snapshot(context.Background(), []*corev1.ConfigMap{cfm}, reported); err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	if !strings.HasPrefix(reported.String(), `ConfigMap kube-system/k8s-injector: key ".spec.hostPID" is skipped: `) {
+		t.Errorf("report = %q; want the key that could not be loaded", reported.String())
+	}
+
+	delete(cfm.Data, ".spec.hostNetwork")
+	if _, err := Snapshot(context.Background(), []*corev1.ConfigMap{cfm}, &bytes.Buffer{}); err == nil {
+		t.Errorf("Snapshot() of no loadable config should fail")
+	}
+}
@@ -0,0 +1,129 @@
+package inject
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ReadDocuments reads the YAML or JSON documents of a file, - for stdin, skipping empty ones
+func ReadDocuments(path string) ([]map[string]interface{}, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	docs, err := DecodeDocuments(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return docs, nil
+}
+
+// DecodeDocuments decodes the YAML or JSON documents of in, skipping empty ones
+func DecodeDocuments(in io.Reader) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	reader := yamlutil.NewYAMLReader(bufio.NewReader(in))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		if len(obj) != 0 {
+			docs = append(docs, obj)
+		}
+	}
+}
+
+// ReadConfigMaps reads the ConfigMaps of files, each holding ConfigMaps or Lists of ConfigMaps
+func ReadConfigMaps(paths []string) ([]*corev1.ConfigMap, error) {
+	var cfms []*corev1.ConfigMap
+	for _, path := range paths {
+		docs, err := ReadDocuments(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			for _, item := range ListItems(doc) {
+				if item["kind"] != "ConfigMap" {
+					return nil, fmt.Errorf("%s: %s is not a ConfigMap", path, DescribeObject(item))
+				}
+				raw, err := json.Marshal(item)
+				if err != nil {
+					return nil, err
+				}
+				cfm := &corev1.ConfigMap{}
+				if err := json.Unmarshal(raw, cfm); err != nil {
+					return nil, fmt.Errorf("%s: %s: %v", path, DescribeObject(item), err)
+				}
+				cfms = append(cfms, cfm)
+			}
+		}
+	}
+	return cfms, nil
+}
+
+// ListItems returns the items of a List, or obj itself when it is not a List
+func ListItems(obj map[string]interface{}) []map[string]interface{} {
+	if obj["kind"] != "List" {
+		return []map[string]interface{}{obj}
+	}
+	items, _ := obj["items"].([]interface{})
+	objs := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if o, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, o)
+		}
+	}
+	return objs
+}
+
+// DescribeObject names obj by its kind, namespace and name, such as Deployment web/frontend
+func DescribeObject(obj map[string]interface{}) string {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	name := fmt.Sprint(meta["name"])
+	if ns, ok := meta["namespace"].(string); ok && ns != "" {
+		name = ns + "/" + name
+	}
+	return fmt.Sprintf("%v %s", obj["kind"], name)
+}
+
+// WriteDocuments writes objs to w as a multi-document YAML stream
+func WriteDocuments(w io.Writer, objs []map[string]interface{}) error {
+	for i, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i != 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
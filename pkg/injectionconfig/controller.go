@@ -0,0 +1,463 @@
+// Package injectionconfig loads InjectionConfig custom resources and reconciles their status
+package injectionconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/apis/v1alpha1"
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultResyncPeriod   = 10 * time.Minute
+	defaultStatusInterval = 10 * time.Second
+)
+
+// Controller watches InjectionConfig objects, serves them as injection config sources and writes back their
+// status: whether they are valid, whether they are active in the published snapshot and how many pods they
+// were injected into. The conditions are written by the leader only, while every replica adds the pods it
+// injected itself.
+type Controller struct {
+	// Namespace is the injector's own namespace, objects in it apply to every enabled namespace by default
+	Namespace string
+	Snapshots *config.SnapshotStore
+	// ResyncPeriod is how often the informer replays its whole cache, defaults to 10 minutes
+	ResyncPeriod time.Duration
+	// StatusInterval is how often statuses are reconciled, defaults to 10 seconds
+	StatusInterval time.Duration
+	client         dynamic.Interface
+
+	mu     sync.Mutex
+	lister cache.GenericLister
+	// injected counts the pods injected since the last flush by source ID, only while counting
+	injected map[string]int64
+	counting bool
+}
+
+// NewController creates a Controller for the InjectionConfig objects served by client
+func NewController(client dynamic.Interface, namespace string, snapshots *config.SnapshotStore) *Controller {
+	return &Controller{
+		Namespace: namespace,
+		Snapshots: snapshots,
+		client:    client,
+		injected:  map[string]int64{},
+	}
+}
+
+// Watch notifies every time an InjectionConfig is added, deleted or has its spec modified, and on every
+// resync. Updates of the status or the metadata only, such as those of the status writers, are ignored. It
+// runs a shared informer until ctx is done, List is served from its cache once synced.
+func (c *Controller) Watch(ctx context.Context, notify chan<- interface{}) error {
+	log.Info().Msg("Watching for injectionconfigs in all namespaces...")
+	resync := c.ResyncPeriod
+	if resync <= 0 {
+		resync = defaultResyncPeriod
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(c.client, resync)
+	informer := factory.ForResource(v1alpha1.Resource)
+
+	handle := func(obj interface{}) {
+		select {
+		case notify <- struct{}{}:
+		case <-ctx.Done():
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(old, obj interface{}) {
+			if !specChanged(old, obj) {
+				return
+			}
+			handle(obj)
+		},
+		DeleteFunc: handle,
+	})
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		if ctx.Err() != nil {
+			log.Info().Msg("stopping injectionconfig watcher, context indicated we are done")
+			return nil
+		}
+		return fmt.Errorf("failed to sync injectionconfig informer cache")
+	}
+	c.mu.Lock()
+	c.lister = informer.Lister()
+	c.mu.Unlock()
+	log.Info().Msg("injectionconfig informer cache synced")
+
+	<-ctx.Done()
+	log.Info().Msg("stopping injectionconfig watcher, context indicated we are done")
+	return nil
+}
+
+// List returns the injection configs of every valid InjectionConfig, ordered by namespace and name. Invalid
+// objects are skipped, their error is reported in their status.
+func (c *Controller) List(ctx context.Context) ([]config.SourceConfigs, error) {
+	objs, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []config.SourceConfigs
+	for _, obj := range objs {
+		ic, selector, _, err := c.decode(obj)
+		if err != nil {
+			tracing.Logger(ctx).Error().Msgf("skipping invalid injectionconfig %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+			continue
+		}
+		inj := ic.Spec.InjectionConfig
+		source := sourceOf(obj)
+		source.Tenant = ic.Namespace != c.Namespace
+		sources = append(sources, config.SourceConfigs{
+			Source:     source,
+			Priority:   ic.Spec.Priority,
+			Selector:   selector,
+			InjConfigs: map[string]*config.InjectionConfig{ic.Spec.Path: &inj},
+		})
+	}
+	return sources, nil
+}
+
+// specChanged reports whether an informer update from old to obj may change the spec, that is when its
+// generation changed or when it is a resync, which replays the same object
+func specChanged(old, obj interface{}) bool {
+	before, ok := old.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	after, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	return before.GetGeneration() != after.GetGeneration() || before.GetResourceVersion() == after.GetResourceVersion()
+}
+
+// Injected counts one injected pod for every InjectionConfig among sourceIDs, the counts are added to the
+// status on the next flush. Pods are only counted while RunInjectedWriter runs.
+func (c *Controller) Injected(sourceIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.counting {
+		return
+	}
+	for _, id := range sourceIDs {
+		if strings.HasPrefix(id, v1alpha1.Kind+"/") {
+			c.injected[id]++
+		}
+	}
+}
+
+// RunInjectedWriter flushes the pods injected by this replica each StatusInterval until ctx is done. It runs on
+// every replica, pods are counted while it runs and those not flushed yet are lost when it returns.
+func (c *Controller) RunInjectedWriter(ctx context.Context) {
+	c.mu.Lock()
+	c.counting = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.counting = false
+		c.injected = map[string]int64{}
+		c.mu.Unlock()
+	}()
+
+	interval := c.StatusInterval
+	if interval <= 0 {
+		interval = defaultStatusInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.FlushInjected(ctx); err != nil {
+				log.Error().Msgf("Could not write injected pods of injectionconfigs: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// FlushInjected adds the pods counted since the last flush to the injectedPods of their InjectionConfig. The
+// status is updated against the resource version it was read at, so that the counts of every replica add up:
+// counts that could not be written are kept for the next flush, those of deleted objects are dropped.
+func (c *Controller) FlushInjected(ctx context.Context) error {
+	objs, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	counts := c.injected
+	c.injected = map[string]int64{}
+	c.mu.Unlock()
+
+	var errs []string
+	for _, obj := range objs {
+		id := sourceOf(obj).ID()
+		injected := counts[id]
+		if injected == 0 {
+			continue
+		}
+		status := currentStatus(obj)
+		status.InjectedPods += injected
+		if err := c.updateStatus(ctx, obj, status); err != nil {
+			errs = append(errs, err.Error())
+			c.mu.Lock()
+			c.injected[id] += injected
+			c.mu.Unlock()
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("cannot update status of %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// RunStatusWriter reconciles the conditions of every InjectionConfig each StatusInterval until ctx is done
+func (c *Controller) RunStatusWriter(ctx context.Context) {
+	interval := c.StatusInterval
+	if interval <= 0 {
+		interval = defaultStatusInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SyncStatus(ctx); err != nil {
+				log.Error().Msgf("Could not reconcile injectionconfig statuses: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SyncStatus writes the conditions of every InjectionConfig whose conditions changed, keeping the pods they
+// were injected into
+func (c *Controller) SyncStatus(ctx context.Context) error {
+	objs, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := c.Snapshots.Load()
+	var errs []string
+	for _, obj := range objs {
+		status, current := c.status(obj, snapshot)
+		if equality.Semantic.DeepEqual(status, current) {
+			continue
+		}
+		if err := c.updateStatus(ctx, obj, status); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("cannot update status of %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// updateStatus replaces the status of obj, failing when obj changed since it was read
+func (c *Controller) updateStatus(ctx context.Context, obj *unstructured.Unstructured, status *v1alpha1.InjectionConfigStatus) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+	updated := obj.DeepCopy()
+	updated.Object["status"] = content
+	_, err = c.client.Resource(v1alpha1.Resource).Namespace(obj.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("%s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// currentStatus returns the status of obj, an empty one when it cannot be read
+func currentStatus(obj *unstructured.Unstructured) *v1alpha1.InjectionConfigStatus {
+	current := &v1alpha1.InjectionConfigStatus{}
+	if content, ok := obj.Object["status"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, current); err != nil {
+			return &v1alpha1.InjectionConfigStatus{}
+		}
+	}
+	return current
+}
+
+// status computes the new status of obj, next to its current one
+func (c *Controller) status(obj *unstructured.Unstructured, snapshot *config.Snapshot) (*v1alpha1.InjectionConfigStatus, *v1alpha1.InjectionConfigStatus) {
+	current := currentStatus(obj)
+	status := current.DeepCopy()
+	status.ObservedGeneration = obj.GetGeneration()
+	status.LastError = ""
+
+	ic, _, ignored, err := c.decode(obj)
+	if err != nil {
+		status.LastError = err.Error()
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionValid, Status: metav1.ConditionFalse, Reason: "InvalidSpec", Message: err.Error(), ObservedGeneration: status.ObservedGeneration})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionActive, Status: metav1.ConditionFalse, Reason: "InvalidSpec", Message: "the spec is not valid", ObservedGeneration: status.ObservedGeneration})
+		return status, current
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionValid, Status: metav1.ConditionTrue, Reason: "Validated", Message: "the spec is valid", ObservedGeneration: status.ObservedGeneration})
+	if len(ignored) != 0 {
+		message := fmt.Sprintf("%s only apply in the injector namespace %s", strings.Join(ignored, ", "), c.Namespace)
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionFieldsIgnored, Status: metav1.ConditionTrue, Reason: "OutsideInjectorNamespace", Message: message, ObservedGeneration: status.ObservedGeneration})
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, v1alpha1.ConditionFieldsIgnored)
+	}
+
+	id := sourceOf(obj).ID()
+	switch owner, overridden := overriddenBy(snapshot, id, ic.Spec.Path); {
+	case servedBy(snapshot, id, ic.Spec.Path):
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionActive, Status: metav1.ConditionTrue, Reason: "Loaded", Message: "served to admissions", ObservedGeneration: status.ObservedGeneration})
+	case overridden:
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionActive, Status: metav1.ConditionFalse, Reason: "Overridden", Message: fmt.Sprintf("path %s is served by %s", ic.Spec.Path, owner), ObservedGeneration: status.ObservedGeneration})
+	default:
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: v1alpha1.ConditionActive, Status: metav1.ConditionFalse, Reason: "NotLoaded", Message: "not loaded yet", ObservedGeneration: status.ObservedGeneration})
+	}
+	return status, current
+}
+
+func (c *Controller) list(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	c.mu.Lock()
+	lister := c.lister
+	c.mu.Unlock()
+
+	var objs []*unstructured.Unstructured
+	if lister != nil {
+		cached, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("cannot list injectionconfigs with error: %w", err)
+		}
+		for _, obj := range cached {
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				objs = append(objs, u)
+			}
+		}
+	} else {
+		list, err := c.client.Resource(v1alpha1.Resource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot list injectionconfigs with error: %w", err)
+		}
+		for i := range list.Items {
+			objs = append(objs, &list.Items[i])
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+		return objs[i].GetName() < objs[j].GetName()
+	})
+	return objs, nil
+}
+
+// decode decodes obj and builds the selector of its config. An object outside the injector's namespace only
+// applies to its own namespace with the lowest priority, the fields of its spec saying otherwise are ignored
+// and returned.
+func (c *Controller) decode(obj *unstructured.Unstructured) (*v1alpha1.InjectionConfig, *config.ConfigSelector, []string, error) {
+	raw, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ic, err := Decode(raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	selector := &config.ConfigSelector{Namespaces: ic.Spec.Namespaces}
+	var ignored []string
+	if ic.Namespace != c.Namespace {
+		for _, ns := range ic.Spec.Namespaces {
+			if ns != ic.Namespace {
+				ignored = append(ignored, "spec.namespaces")
+				break
+			}
+		}
+		if ic.Spec.Priority != 0 {
+			ignored = append(ignored, "spec.priority")
+		}
+		selector.Namespaces = []string{ic.Namespace}
+		ic.Spec.Priority = config.LowestPriority
+	}
+	if ic.Spec.PodSelector != nil {
+		// Already validated by Decode
+		selector.Pods, _ = metav1.LabelSelectorAsSelector(ic.Spec.PodSelector)
+	}
+	return ic, selector, ignored, nil
+}
+
+// Decode strictly decodes an InjectionConfig, so that a misspelt field is reported instead of silently
+// ignored, and validates its spec, which must set an injection field or extend another config
+func Decode(raw []byte) (*v1alpha1.InjectionConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	ic := &v1alpha1.InjectionConfig{}
+	if err := decoder.Decode(ic); err != nil {
+		return nil, fmt.Errorf("cannot decode spec: %v", err)
+	}
+
+	if err := config.ValidatePath(ic.Spec.Path, &ic.Spec.InjectionConfig); err != nil {
+		return nil, fmt.Errorf("invalid spec.path: %v", err)
+	}
+	// Unlike a ConfigMap key, an object holds a single config, one setting nothing is a mistake
+	if ic.Spec.InjectionConfig.SetsNothing() {
+		return nil, fmt.Errorf("spec sets no injection field")
+	}
+	if ic.Spec.PodSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(ic.Spec.PodSelector); err != nil {
+			return nil, fmt.Errorf("invalid spec.podSelector: %v", err)
+		}
+	}
+	return ic, nil
+}
+
+// servedBy reports whether the config of path of the source with ID id is among the configs of snapshot
+func servedBy(snapshot *config.Snapshot, id, path string) bool {
+	for _, cfg := range snapshot.Configs[path] {
+		if cfg.Source == id {
+			return true
+		}
+	}
+	return false
+}
+
+// overriddenBy returns the ID of the source whose config of path overrides the one of the source with ID id
+func overriddenBy(snapshot *config.Snapshot, id, path string) (string, bool) {
+	for _, source := range snapshot.Sources {
+		if source.ID() == id {
+			owner, ok := source.Overridden[path]
+			return owner, ok
+		}
+	}
+	return "", false
+}
+
+func sourceOf(obj *unstructured.Unstructured) config.ConfigSource {
+	return config.ConfigSource{
+		Kind:            v1alpha1.Kind,
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		ResourceVersion: obj.GetResourceVersion(),
+	}
+}
@@ -0,0 +1,233 @@
+package injectionconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/apis/v1alpha1"
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+)
+
+func injectionConfig(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.GroupName + "/" + v1alpha1.Version,
+		"kind":       v1alpha1.Kind,
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name, "generation": int64(1)},
+		"spec":       spec,
+	}}
+}
+
+func newController(objs ...runtime.Object) *Controller {
+	client := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.Resource: v1alpha1.ListKind}, objs...)
+	return NewController(client, "kube-system", config.NewSnapshotStore())
+}
+
+func TestController_List(t *testing.T) {
+	c := newController(
+		injectionConfig("kube-system", "host-pid", map[string]interface{}{"path": "/spec/hostPID", "hostPID": true}),
+		injectionConfig("payment", "sidecar", map[string]interface{}{
+			"path":        "/spec/containers/-",
+			"priority":    int64(5),
+			"podSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "api"}},
+			"containers":  []interface{}{map[string]interface{}{"name": "proxy", "image": "envoy"}},
+		}),
+		injectionConfig("payment", "typo", map[string]interface{}{"path": "/spec/hostPID", "hostPDI": true}),
+		injectionConfig("payment", "no-path", map[string]interface{}{"hostPID": true}),
+		injectionConfig("payment", "empty", map[string]interface{}{"path": "/spec/hostPID", "name": "base"}),
+	)
+
+	sources, err := c.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("List() got %d sources; want the 2 valid ones: %+v", len(sources), sources)
+	}
+	if !sources[0].Selector.Matches("payment", nil) || sources[0].InjConfigs["/spec/hostPID"] == nil {
+		t.Errorf("objects in the injector namespace should apply to every namespace: %+v", sources[0])
+	}
+	sidecar := sources[1]
+	if sidecar.Priority != config.LowestPriority || len(sidecar.InjConfigs["/spec/containers/-"].Containers) != 1 {
+		t.Errorf("unexpected sidecar source: %+v", sidecar)
+	}
+	if sidecar.Selector.Matches("dbservice", map[string]string{"app": "api"}) || !sidecar.Selector.Matches("payment", map[string]string{"app": "api"}) {
+		t.Errorf("objects of other namespaces should only apply to their own namespace")
+	}
+	if sidecar.Selector.Matches("payment", map[string]string{"app": "web"}) {
+		t.Errorf("the pod selector was not applied")
+	}
+}
+
+func TestController_TenantFieldsIgnored(t *testing.T) {
+	c := newController(
+		injectionConfig("kube-system", "host-pid", map[string]interface{}{
+			"path": "/spec/hostPID", "hostPID": true, "priority": int64(3), "namespaces": []interface{}{"dbservice"},
+		}),
+		injectionConfig("payment", "host-pid", map[string]interface{}{
+			"path": "/spec/hostPID", "hostPID": false, "priority": int64(100), "namespaces": []interface{}{"payment", "dbservice"},
+		}),
+	)
+	ctx := context.Background()
+	sources, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, tenant := sources[0], sources[1]
+	if own.Priority != 3 || own.Source.Tenant || !own.Selector.Matches("dbservice", nil) || own.Selector.Matches("payment", nil) {
+		t.Errorf("an object in the injector namespace should keep its priority and namespaces: %+v", own)
+	}
+	if tenant.Priority != config.LowestPriority || !tenant.Source.Tenant || tenant.Selector.Matches("dbservice", nil) || !tenant.Selector.Matches("payment", nil) {
+		t.Errorf("an object in another namespace should only apply to its namespace with the lowest priority: %+v", tenant)
+	}
+
+	c.Snapshots.SetConfigs(config.MergeSources(sources))
+	if err := c.SyncStatus(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conditions := func(namespace string) []metav1.Condition {
+		obj, err := c.client.Resource(v1alpha1.Resource).Namespace(namespace).Get(ctx, "host-pid", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		status := &v1alpha1.InjectionConfigStatus{}
+		content, _ := obj.Object["status"].(map[string]interface{})
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, status); err != nil {
+			t.Fatal(err)
+		}
+		return status.Conditions
+	}
+	if cond := meta.FindStatusCondition(conditions("kube-system"), v1alpha1.ConditionFieldsIgnored); cond != nil {
+		t.Errorf("FieldsIgnored condition of the injector namespace object got = %+v; want none", cond)
+	}
+	cond := meta.FindStatusCondition(conditions("payment"), v1alpha1.ConditionFieldsIgnored)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Message != "spec.namespaces, spec.priority only apply in the injector namespace kube-system" {
+		t.Errorf("FieldsIgnored condition of the tenant object got = %+v", cond)
+	}
+}
+
+func TestController_SyncStatus(t *testing.T) {
+	c := newController(
+		injectionConfig("kube-system", "host-pid", map[string]interface{}{"path": "/spec/hostPID", "hostPID": true}),
+		injectionConfig("payment", "host-pid", map[string]interface{}{"path": "/spec/hostPID", "hostPID": false}),
+		injectionConfig("payment", "typo", map[string]interface{}{"path": "/spec/hostPID", "hostPDI": true}),
+	)
+	ctx := context.Background()
+	sources, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Snapshots.SetConfigs(config.MergeSources(sources))
+
+	if err := c.SyncStatus(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(namespace, name string) *v1alpha1.InjectionConfigStatus {
+		return statusOf(t, c, namespace, name)
+	}
+	isTrue := func(s *v1alpha1.InjectionConfigStatus, condition string) bool {
+		return meta.IsStatusConditionTrue(s.Conditions, condition)
+	}
+
+	winner := status("kube-system", "host-pid")
+	if !isTrue(winner, v1alpha1.ConditionValid) || !isTrue(winner, v1alpha1.ConditionActive) {
+		t.Errorf("unexpected winner status: %+v", winner)
+	}
+	loser := status("payment", "host-pid")
+	if !isTrue(loser, v1alpha1.ConditionValid) || isTrue(loser, v1alpha1.ConditionActive) {
+		t.Errorf("unexpected overridden status: %+v", loser)
+	}
+	if cond := meta.FindStatusCondition(loser.Conditions, v1alpha1.ConditionActive); cond == nil || cond.Reason != "Overridden" {
+		t.Errorf("overridden Active condition got = %+v", cond)
+	}
+	typo := status("payment", "typo")
+	if isTrue(typo, v1alpha1.ConditionValid) || typo.LastError == "" {
+		t.Errorf("a misspelt field should invalidate the object: %+v", typo)
+	}
+}
+
+func statusOf(t *testing.T, c *Controller, namespace, name string) *v1alpha1.InjectionConfigStatus {
+	obj, err := c.client.Resource(v1alpha1.Resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := &v1alpha1.InjectionConfigStatus{}
+	content, _ := obj.Object["status"].(map[string]interface{})
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestController_FlushInjected(t *testing.T) {
+	leader := newController(injectionConfig("kube-system", "host-pid", map[string]interface{}{"path": "/spec/hostPID", "hostPID": true}))
+	// Another replica of the injector, watching the same objects
+	replica := NewController(leader.client, "kube-system", config.NewSnapshotStore())
+	ctx := context.Background()
+	id := "InjectionConfig/kube-system/host-pid"
+
+	leader.Injected([]string{id})
+	if len(leader.injected) != 0 {
+		t.Errorf("pods were counted with no injected writer running: %v", leader.injected)
+	}
+	leader.counting, replica.counting = true, true
+	leader.Injected([]string{id, "ConfigMap/kube-system/k8s-injector"})
+	leader.Injected([]string{id})
+	replica.Injected([]string{id, "InjectionConfig/payment/deleted"})
+
+	for _, c := range []*Controller{leader, replica} {
+		if err := c.FlushInjected(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := statusOf(t, leader, "kube-system", "host-pid").InjectedPods; got != 3 {
+		t.Errorf("injectedPods got = %d; want the 3 pods of both replicas", got)
+	}
+	if len(replica.injected) != 0 {
+		t.Errorf("counts left after a flush: %v; want the deleted object dropped", replica.injected)
+	}
+
+	// Counts are only added once, and kept by the conditions of the leader
+	if err := leader.FlushInjected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.SyncStatus(ctx); err != nil {
+		t.Fatal(err)
+	}
+	status := statusOf(t, leader, "kube-system", "host-pid")
+	if status.InjectedPods != 3 || !meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ConditionValid) {
+		t.Errorf("status got = %+v after a second flush and a sync; want 3 injected pods", status)
+	}
+}
+
+func TestSpecChanged(t *testing.T) {
+	object := func(generation int64, resourceVersion string) *unstructured.Unstructured {
+		obj := injectionConfig("kube-system", "host-pid", map[string]interface{}{"path": "/spec/hostPID"})
+		obj.SetGeneration(generation)
+		obj.SetResourceVersion(resourceVersion)
+		return obj
+	}
+	tests := []struct {
+		name     string
+		old, obj *unstructured.Unstructured
+		want     bool
+	}{
+		{name: "spec update", old: object(1, "10"), obj: object(2, "11"), want: true},
+		{name: "status update", old: object(1, "10"), obj: object(1, "11"), want: false},
+		{name: "resync", old: object(1, "10"), obj: object(1, "10"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := specChanged(tt.old, tt.obj); got != tt.want {
+				t.Errorf("specChanged() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
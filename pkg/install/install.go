@@ -0,0 +1,387 @@
+// Package install renders the Kubernetes objects that run the injector in a cluster
+package install
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dungdev1/k8s-injector/pkg/apis/v1alpha1"
+	"github.com/dungdev1/k8s-injector/pkg/certs"
+	"github.com/dungdev1/k8s-injector/pkg/watcher"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// servicePort is the port of the Service the API server calls the webhook on
+	servicePort = 443
+	// tlsPortName names the webhook port of the container, the Service targets it by name
+	tlsPortName = "webhook-api"
+	// lifecyclePortName names the health checking port of the container
+	lifecyclePortName = "lifecycle"
+	// certsVolume is the volume the Secret is mounted from
+	certsVolume = "webhook-tls-certs"
+	// namespaceNameLabel is set by the API server on every namespace to its name
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	// webhookTimeoutSeconds is how long the API server waits for an admission
+	webhookTimeoutSeconds = 5
+)
+
+// Options configures the objects Render returns
+type Options struct {
+	// Name is the name of every object, and Namespace the namespace of the namespaced ones
+	Name      string
+	Namespace string
+	// Image and ImagePullPolicy of the injector container
+	Image           string
+	ImagePullPolicy corev1.PullPolicy
+	Replicas        int32
+	// ConfigMapName is the ConfigMap the injector loads injection configs from, in Namespace
+	ConfigMapName string
+	// WebhookEnableLabel is the label of the namespaces the injector injects pods of
+	WebhookEnableLabel map[string]string
+	// TLSPort serves the webhook and LifecyclePort the health checks
+	TLSPort       int
+	LifecyclePort int
+	// CertFile and KeyFile are where the injector reads the serving certificate from, both in the directory
+	// the Secret is mounted in
+	CertFile string
+	KeyFile  string
+	// ConfigMapAllNamespaces and InjectionConfigCRD are the settings of the same name of the injector, they
+	// need more permissions and the CRD needs its own validating webhook
+	ConfigMapAllNamespaces bool
+	InjectionConfigCRD     bool
+	// ValidatingWebhook adds the ValidatingWebhookConfiguration that rejects invalid injection configs
+	ValidatingWebhook bool
+	// FailurePolicy of the mutating webhook
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// Args are more command line flags of the injector
+	Args []string
+	// Certs holds the serving certificate of the Secret and the CA of the webhook configurations
+	Certs *certs.Bundle
+}
+
+// SecretName returns the name of the Secret holding the serving certificate of the injector called name
+func SecretName(name string) string {
+	return name + "-tls"
+}
+
+// Render returns the objects that run the injector as configured by opts: its ServiceAccount, Role,
+// ClusterRole and their bindings, the Secret of its serving certificate, its Deployment and Service and the
+// webhook configurations that call it
+func Render(opts Options) ([]runtime.Object, error) {
+	if opts.Name == "" || opts.Namespace == "" {
+		return nil, fmt.Errorf("a name and a namespace are needed")
+	}
+	if opts.Image == "" {
+		return nil, fmt.Errorf("an image is needed")
+	}
+	if opts.ConfigMapName == "" {
+		return nil, fmt.Errorf("a configmap name is needed")
+	}
+	if filepath.Dir(opts.CertFile) != filepath.Dir(opts.KeyFile) {
+		return nil, fmt.Errorf("the certificate %s and the key %s have to be in the same directory", opts.CertFile, opts.KeyFile)
+	}
+	if opts.Certs == nil {
+		return nil, fmt.Errorf("a serving certificate is needed")
+	}
+	configMapLabels, err := labels.ConvertSelectorToLabelsMap(watcher.ConfigMapLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []runtime.Object{
+		serviceAccount(opts),
+		role(opts),
+		roleBinding(opts, "Role"),
+		clusterRole(opts),
+		roleBinding(opts, "ClusterRole"),
+		opts.Certs.Secret(SecretName(opts.Name), opts.Namespace),
+		deployment(opts),
+		service(opts),
+		mutatingWebhook(opts),
+	}
+	if opts.ValidatingWebhook {
+		objs = append(objs, validatingWebhook(opts, configMapLabels))
+	}
+	return objs, nil
+}
+
+// Args returns the command line flags of the injector container
+func Args(opts Options) []string {
+	args := []string{
+		fmt.Sprintf("--configmap-name=%s", opts.ConfigMapName),
+		fmt.Sprintf("--tls-port=%d", opts.TLSPort),
+		fmt.Sprintf("--lifecycle-port=%d", opts.LifecyclePort),
+		fmt.Sprintf("--tls-cert-file=%s", opts.CertFile),
+		fmt.Sprintf("--tls-key-file=%s", opts.KeyFile),
+	}
+	if len(opts.WebhookEnableLabel) != 0 {
+		pairs := make([]string, 0, len(opts.WebhookEnableLabel))
+		for key, value := range opts.WebhookEnableLabel {
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		args = append(args, "--webhook-enable-label="+strings.Join(pairs, ","))
+	}
+	if opts.ConfigMapAllNamespaces {
+		args = append(args, "--configmap-all-namespaces")
+	}
+	if opts.InjectionConfigCRD {
+		args = append(args, "--injection-config-crd")
+	}
+	return append(args, opts.Args...)
+}
+
+func objectMeta(opts Options, namespaced bool) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{Name: opts.Name, Labels: podLabels(opts)}
+	if namespaced {
+		meta.Namespace = opts.Namespace
+	}
+	return meta
+}
+
+// podLabels are the labels of the pods of the injector, not to be mistaken for the label of its ConfigMaps
+func podLabels(opts Options) map[string]string {
+	return map[string]string{"app.kubernetes.io/name": opts.Name}
+}
+
+func serviceAccount(opts Options) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: objectMeta(opts, true),
+	}
+}
+
+// role lets the injector load its ConfigMaps and hold the leader Lease, both in its namespace
+func role(opts Options) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+		ObjectMeta: objectMeta(opts, true),
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "list", "watch"}},
+			{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "create", "update"}},
+		},
+	}
+}
+
+// clusterRole lets the injector watch namespaces and record events, and with the settings that need it,
+// list ConfigMaps and InjectionConfigs everywhere
+func clusterRole(opts Options) *rbacv1.ClusterRole {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+	}
+	if opts.ConfigMapAllNamespaces {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list", "watch"}})
+	}
+	if opts.InjectionConfigCRD {
+		rules = append(rules,
+			rbacv1.PolicyRule{APIGroups: []string{v1alpha1.GroupName}, Resources: []string{v1alpha1.Resource.Resource}, Verbs: []string{"get", "list", "watch"}},
+			rbacv1.PolicyRule{APIGroups: []string{v1alpha1.GroupName}, Resources: []string{v1alpha1.Resource.Resource + "/status"}, Verbs: []string{"update"}},
+		)
+	}
+	return &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: objectMeta(opts, false),
+		Rules:      rules,
+	}
+}
+
+// roleBinding binds the Role or the ClusterRole of the injector, named by kind, to its ServiceAccount
+func roleBinding(opts Options, kind string) runtime.Object {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: opts.Name, Namespace: opts.Namespace}}
+	roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: kind, Name: opts.Name}
+	if kind == "ClusterRole" {
+		return &rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: objectMeta(opts, false),
+			Subjects:   subjects,
+			RoleRef:    roleRef,
+		}
+	}
+	return &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+		ObjectMeta: objectMeta(opts, true),
+		Subjects:   subjects,
+		RoleRef:    roleRef,
+	}
+}
+
+func deployment(opts Options) *appsv1.Deployment {
+	replicas := opts.Replicas
+	// The Secret is mounted with the file names the injector is configured with
+	certsDir := filepath.Dir(opts.CertFile)
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString(lifecyclePortName)},
+			},
+			TimeoutSeconds: 5,
+			PeriodSeconds:  10,
+		}
+	}
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: objectMeta(opts, true),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels(opts)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels(opts)},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: opts.Name,
+					Containers: []corev1.Container{{
+						Name:            "server",
+						Image:           opts.Image,
+						ImagePullPolicy: opts.ImagePullPolicy,
+						Args:            Args(opts),
+						Ports: []corev1.ContainerPort{
+							{Name: tlsPortName, ContainerPort: int32(opts.TLSPort)},
+							{Name: lifecyclePortName, ContainerPort: int32(opts.LifecyclePort)},
+						},
+						VolumeMounts:   []corev1.VolumeMount{{Name: certsVolume, MountPath: certsDir, ReadOnly: true}},
+						ReadinessProbe: probe("/readyz"),
+						LivenessProbe:  probe("/healthz"),
+					}},
+					Volumes: []corev1.Volume{{
+						Name: certsVolume,
+						VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: SecretName(opts.Name),
+								Items: []corev1.KeyToPath{
+									{Key: corev1.TLSCertKey, Path: filepath.Base(opts.CertFile)},
+									{Key: corev1.TLSPrivateKeyKey, Path: filepath.Base(opts.KeyFile)},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func service(opts Options) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: objectMeta(opts, true),
+		Spec: corev1.ServiceSpec{
+			Selector: podLabels(opts),
+			Type:     corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{
+				Name:       "https",
+				Port:       servicePort,
+				TargetPort: intstr.FromString(tlsPortName),
+			}},
+		},
+	}
+}
+
+// clientConfig calls the Service of the injector on path
+func clientConfig(opts Options, path string) admissionregistrationv1.WebhookClientConfig {
+	port := int32(servicePort)
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: opts.Namespace,
+			Name:      opts.Name,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: opts.Certs.CACert,
+	}
+}
+
+// webhookName returns the fully qualified name of a webhook of the injector, prefixed unless prefix is empty
+func webhookName(opts Options, prefix string) string {
+	name := fmt.Sprintf("%s.%s.svc", opts.Name, opts.Namespace)
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func rule(group, version, resource string, operations ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{version},
+			Resources:   []string{resource},
+		},
+	}}
+}
+
+// mutatingWebhook calls the injector for the pods of the namespaces with the webhook enable label, except for
+// the system namespaces and its own, whose pods could otherwise not be created while the injector is down
+func mutatingWebhook(opts Options) *admissionregistrationv1.MutatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	timeout := int32(webhookTimeoutSeconds)
+	failurePolicy := opts.FailurePolicy
+	excluded := []string{metav1.NamespaceSystem, metav1.NamespacePublic}
+	if opts.Namespace != metav1.NamespaceSystem && opts.Namespace != metav1.NamespacePublic {
+		excluded = append(excluded, opts.Namespace)
+	}
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admissionregistrationv1.SchemeGroupVersion.String(), Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: objectMeta(opts, false),
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         webhookName(opts, ""),
+			ClientConfig: clientConfig(opts, "/mutate"),
+			Rules:        rule("", "v1", "pods", admissionregistrationv1.Create),
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: opts.WebhookEnableLabel,
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      namespaceNameLabel,
+					Operator: metav1.LabelSelectorOpNotIn,
+					Values:   excluded,
+				}},
+			},
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			SideEffects:             &sideEffects,
+			FailurePolicy:           &failurePolicy,
+			TimeoutSeconds:          &timeout,
+		}},
+	}
+}
+
+// validatingWebhook rejects invalid injection configs, of the ConfigMaps with the label the injector loads
+// them from, and of InjectionConfigs when the injector loads them
+func validatingWebhook(opts Options, configMapLabels map[string]string) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	timeout := int32(webhookTimeoutSeconds)
+	failurePolicy := admissionregistrationv1.Fail
+	webhook := func(prefix string) admissionregistrationv1.ValidatingWebhook {
+		return admissionregistrationv1.ValidatingWebhook{
+			Name:                    webhookName(opts, prefix),
+			ClientConfig:            clientConfig(opts, "/validate"),
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			SideEffects:             &sideEffects,
+			FailurePolicy:           &failurePolicy,
+			TimeoutSeconds:          &timeout,
+		}
+	}
+
+	configMaps := webhook("configmaps")
+	configMaps.Rules = rule("", "v1", "configmaps", admissionregistrationv1.Create, admissionregistrationv1.Update)
+	configMaps.ObjectSelector = &metav1.LabelSelector{MatchLabels: configMapLabels}
+	webhooks := []admissionregistrationv1.ValidatingWebhook{configMaps}
+	if opts.InjectionConfigCRD {
+		injectionConfigs := webhook(v1alpha1.Resource.Resource)
+		injectionConfigs.Rules = rule(v1alpha1.GroupName, v1alpha1.Version, v1alpha1.Resource.Resource, admissionregistrationv1.Create, admissionregistrationv1.Update)
+		webhooks = append(webhooks, injectionConfigs)
+	}
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admissionregistrationv1.SchemeGroupVersion.String(), Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: objectMeta(opts, false),
+		Webhooks:   webhooks,
+	}
+}
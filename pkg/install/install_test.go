@@ -0,0 +1,182 @@
+package install
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/certs"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testOptions(t *testing.T) Options {
+	bundle, err := certs.Generate(certs.Options{Service: "injector", Namespace: "ops"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Options{
+		Name:               "injector",
+		Namespace:          "ops",
+		Image:              "injector:v1",
+		Replicas:           2,
+		ConfigMapName:      "injection-configs",
+		WebhookEnableLabel: map[string]string{"inject": "yes"},
+		TLSPort:            9443,
+		LifecyclePort:      8000,
+		CertFile:           "/certs/cert.pem",
+		KeyFile:            "/certs/key.pem",
+		ValidatingWebhook:  true,
+		FailurePolicy:      admissionregistrationv1.Ignore,
+		Certs:              bundle,
+	}
+}
+
+func kinds(objs []runtime.Object) []string {
+	var kinds []string
+	for _, obj := range objs {
+		kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	return kinds
+}
+
+func TestRender(t *testing.T) {
+	opts := testOptions(t)
+	objs, err := Render(opts)
+	if err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+	want := "ServiceAccount,Role,RoleBinding,ClusterRole,ClusterRoleBinding,Secret,Deployment,Service,MutatingWebhookConfiguration,ValidatingWebhookConfiguration"
+	if got := strings.Join(kinds(objs), ","); got != want {
+		t.Fatalf("Render() kinds = %s; want %s", got, want)
+	}
+
+	secret := objs[5].(*corev1.Secret)
+	deploy := objs[6].(*appsv1.Deployment)
+	svc := objs[7].(*corev1.Service)
+	mutating := objs[8].(*admissionregistrationv1.MutatingWebhookConfiguration)
+	validating := objs[9].(*admissionregistrationv1.ValidatingWebhookConfiguration)
+
+	container := deploy.Spec.Template.Spec.Containers[0]
+	args := strings.Join(container.Args, " ")
+	for _, arg := range []string{"--configmap-name=injection-configs", "--tls-port=9443", "--tls-cert-file=/certs/cert.pem", "--webhook-enable-label=inject=yes"} {
+		if !strings.Contains(args, arg) {
+			t.Errorf("args %s do not contain %s", args, arg)
+		}
+	}
+	targetPort := svc.Spec.Ports[0].TargetPort.StrVal
+	if port := container.Ports[0]; port.Name != targetPort || port.ContainerPort != 9443 {
+		t.Errorf("the Service targets %s, the container serves %s on %d", targetPort, port.Name, port.ContainerPort)
+	}
+	volume := deploy.Spec.Template.Spec.Volumes[0].Secret
+	if volume.SecretName != secret.Name || volume.Items[0].Path != "cert.pem" || container.VolumeMounts[0].MountPath != "/certs" {
+		t.Errorf("the Secret %s is not mounted where the injector reads it from: %+v", secret.Name, volume)
+	}
+	if !bytes.Equal(mutating.Webhooks[0].ClientConfig.CABundle, opts.Certs.CACert) || !bytes.Equal(validating.Webhooks[0].ClientConfig.CABundle, opts.Certs.CACert) {
+		t.Errorf("the caBundle of the webhooks is not the CA of the certificate")
+	}
+	if webhook := mutating.Webhooks[0]; *webhook.FailurePolicy != admissionregistrationv1.Ignore {
+		t.Errorf("mutating webhook = %+v; want the failure policy", webhook)
+	}
+	if len(validating.Webhooks) != 1 || validating.Webhooks[0].ObjectSelector.MatchLabels["app"] != "k8s-injector" {
+		t.Errorf("validating webhooks = %+v; want only the one of the labelled ConfigMaps", validating.Webhooks)
+	}
+}
+
+func TestRender_NamespaceSelector(t *testing.T) {
+	tests := []struct {
+		namespace string
+		excluded  []string
+	}{
+		{namespace: "ops", excluded: []string{"kube-system", "kube-public", "ops"}},
+		{namespace: "kube-system", excluded: []string{"kube-system", "kube-public"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Namespace = tt.namespace
+			objs, err := Render(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selector := objs[8].(*admissionregistrationv1.MutatingWebhookConfiguration).Webhooks[0].NamespaceSelector
+			matcher, err := metav1.LabelSelectorAsSelector(selector)
+			if err != nil {
+				t.Fatalf("invalid namespace selector %+v: %v", selector, err)
+			}
+			if !reflect.DeepEqual(selector.MatchLabels, map[string]string{"inject": "yes"}) {
+				t.Errorf("matchLabels = %v; want the webhook enable label", selector.MatchLabels)
+			}
+			if !matcher.Matches(labels.Set{"inject": "yes", namespaceNameLabel: "payment"}) {
+				t.Errorf("selector %s does not match an enabled namespace", matcher)
+			}
+			if matcher.Matches(labels.Set{namespaceNameLabel: "payment"}) {
+				t.Errorf("selector %s matches a namespace without the webhook enable label", matcher)
+			}
+			for _, namespace := range tt.excluded {
+				if matcher.Matches(labels.Set{"inject": "yes", namespaceNameLabel: namespace}) {
+					t.Errorf("selector %s matches the %s namespace", matcher, namespace)
+				}
+			}
+		})
+	}
+}
+
+func TestRender_Settings(t *testing.T) {
+	opts := testOptions(t)
+	opts.ValidatingWebhook = false
+	objs, err := Render(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 9 || len(objs[3].(*rbacv1.ClusterRole).Rules) != 2 {
+		t.Errorf("without the validating webhook nor more settings, got %v", kinds(objs))
+	}
+
+	opts.ValidatingWebhook = true
+	opts.ConfigMapAllNamespaces = true
+	opts.InjectionConfigCRD = true
+	objs, err = Render(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := objs[3].(*rbacv1.ClusterRole).Rules; len(rules) != 5 {
+		t.Errorf("ClusterRole rules = %+v; want ConfigMaps and InjectionConfigs to be listed", rules)
+	}
+	if webhooks := objs[9].(*admissionregistrationv1.ValidatingWebhookConfiguration).Webhooks; len(webhooks) != 2 || webhooks[1].Rules[0].Resources[0] != "injectionconfigs" {
+		t.Errorf("validating webhooks = %+v; want InjectionConfigs to be validated", webhooks)
+	}
+	args := strings.Join(Args(opts), " ")
+	if !strings.Contains(args, "--configmap-all-namespaces") || !strings.Contains(args, "--injection-config-crd") {
+		t.Errorf("args %s do not enable the settings", args)
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Options)
+		want   string
+	}{
+		{name: "no image", modify: func(o *Options) { o.Image = "" }, want: "an image is needed"},
+		{name: "no configmap", modify: func(o *Options) { o.ConfigMapName = "" }, want: "a configmap name is needed"},
+		{name: "split cert files", modify: func(o *Options) { o.KeyFile = "/keys/key.pem" }, want: "same directory"},
+		{name: "no certificate", modify: func(o *Options) { o.Certs = nil }, want: "a serving certificate is needed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t)
+			tt.modify(&opts)
+			if _, err := Render(opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Render() = %v; want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package leader runs the background loops that must only run on one replica at a time
+package leader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/metrics"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Options configures the Lease used to elect the leader
+type Options struct {
+	// Enabled runs the loops on every replica without election when false
+	Enabled       bool
+	Namespace     string
+	LeaseName     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Loop is a background loop that runs until ctx is done
+type Loop func(ctx context.Context)
+
+// Elector runs its loops only while this replica holds the Lease. Losing the Lease stops the loops, and the
+// replica campaigns again.
+type Elector struct {
+	opts   Options
+	client kubernetes.Interface
+
+	mu     sync.Mutex
+	loops  map[string]Loop
+	leader bool
+}
+
+// NewElector creates an Elector campaigning for the Lease described by opts
+func NewElector(client kubernetes.Interface, opts Options) *Elector {
+	return &Elector{
+		opts:   opts,
+		client: client,
+		loops:  map[string]Loop{},
+	}
+}
+
+// Add registers a loop, loops must be added before Run
+func (e *Elector) Add(name string, loop Loop) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loops[name] = loop
+}
+
+// IsLeader reports whether the loops are currently running on this replica
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader
+}
+
+// Run campaigns for the Lease and runs the loops while leading, until ctx is done
+func (e *Elector) Run(ctx context.Context) error {
+	if !e.opts.Enabled {
+		log.Info().Msg("Leader election is disabled, running background loops on this replica")
+		e.lead(ctx)
+		return nil
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: e.opts.Namespace,
+			Name:      e.opts.LeaseName,
+		},
+		Client:     e.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.opts.Identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   e.opts.LeaseDuration,
+		RenewDeadline:   e.opts.RenewDeadline,
+		RetryPeriod:     e.opts.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            e.opts.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.lead,
+			OnStoppedLeading: func() {
+				log.Info().Msgf("%s stopped leading lease %s/%s", e.opts.Identity, e.opts.Namespace, e.opts.LeaseName)
+			},
+			OnNewLeader: func(identity string) {
+				log.Info().Msgf("Lease %s/%s is held by %s", e.opts.Namespace, e.opts.LeaseName, identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create leader elector: %v", err)
+	}
+
+	log.Info().Msgf("%s is campaigning for lease %s/%s", e.opts.Identity, e.opts.Namespace, e.opts.LeaseName)
+	for ctx.Err() == nil {
+		// Run returns once the lease is lost, the loops are stopped by then
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// lead runs every loop until ctx is done, which happens once the lease is lost
+func (e *Elector) lead(ctx context.Context) {
+	e.setLeader(true)
+	defer e.setLeader(false)
+
+	e.mu.Lock()
+	var wg sync.WaitGroup
+	for name, loop := range e.loops {
+		log.Info().Msgf("Starting background loop %s", name)
+		wg.Add(1)
+		go func(name string, loop Loop) {
+			defer wg.Done()
+			loop(ctx)
+			log.Info().Msgf("Stopped background loop %s", name)
+		}(name, loop)
+	}
+	e.mu.Unlock()
+
+	<-ctx.Done()
+	wg.Wait()
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.leader = leader
+	if leader {
+		metrics.Leader.Set(1)
+	} else {
+		metrics.Leader.Set(0)
+	}
+}
@@ -0,0 +1,83 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fakeclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestElector_OnlyLeaderRunsLoops(t *testing.T) {
+	client := fakeclient.NewSimpleClientset()
+	opts := func(identity string) Options {
+		return Options{
+			Enabled:       true,
+			Namespace:     "kube-system",
+			LeaseName:     "k8s-injector",
+			Identity:      identity,
+			LeaseDuration: time.Second,
+			RenewDeadline: 500 * time.Millisecond,
+			RetryPeriod:   100 * time.Millisecond,
+		}
+	}
+
+	var running [2]int32
+	electors := [2]*Elector{NewElector(client, opts("replica-0")), NewElector(client, opts("replica-1"))}
+	cancels := [2]context.CancelFunc{}
+	for i, e := range electors {
+		i := i
+		e.Add("status", func(ctx context.Context) {
+			atomic.AddInt32(&running[i], 1)
+			<-ctx.Done()
+			atomic.AddInt32(&running[i], -1)
+		})
+		var ctx context.Context
+		ctx, cancels[i] = context.WithCancel(context.Background())
+		defer cancels[i]()
+		go e.Run(ctx)
+		// Let the first replica win the lease
+		if i == 0 {
+			waitFor(t, "replica-0 to lead", e.IsLeader)
+		}
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if atomic.LoadInt32(&running[0]) != 1 || atomic.LoadInt32(&running[1]) != 0 || electors[1].IsLeader() {
+		t.Fatalf("loops running got = %v; want only on the leader", running)
+	}
+
+	cancels[0]()
+	waitFor(t, "replica-1 to take over", electors[1].IsLeader)
+	waitFor(t, "the loop to move to replica-1", func() bool {
+		return atomic.LoadInt32(&running[0]) == 0 && atomic.LoadInt32(&running[1]) == 1
+	})
+}
+
+func TestElector_DisabledRunsLoops(t *testing.T) {
+	e := NewElector(nil, Options{})
+	var ran int32
+	e.Add("status", func(ctx context.Context) {
+		atomic.StoreInt32(&ran, 1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, "the loop to run", func() bool { return atomic.LoadInt32(&ran) == 1 })
+	cancel()
+	<-done
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "k8s_injector"
+
+// Registry holds every metric of the injector, next to the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RejectedClientCertificates counts webhook connections refused by client certificate verification
+	RejectedClientCertificates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_client_certificates_total",
+		Help:      "Number of webhook connections rejected because of their client certificate, by reason.",
+	}, []string{"reason"})
+
+	// ConfigReloads counts injection config reloads by result: success, failure or deleted
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of injection config reloads, by result.",
+	}, []string{"result"})
+
+	// ConfigLastReloadSuccess is the time of the last successful injection config reload
+	ConfigLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Unix time of the last successful injection config reload.",
+	})
+
+	// Leader is 1 while this replica runs the background loops reserved to the leader
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica is the leader running the background loops.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		RejectedClientCertificates,
+		ConfigReloads,
+		ConfigLastReloadSuccess,
+		Leader,
+	)
+}
+
+// Handler serves the metrics of Registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
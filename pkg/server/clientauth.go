@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dungdev1/k8s-injector/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// LoadClientCAs reads a PEM bundle of the CAs allowed to sign webhook client certificates
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client CA file %q: %v", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in client CA file %q", path)
+	}
+	return pool, nil
+}
+
+// clientVerifier checks the client certificate of every webhook connection against the client CAs and,
+// when set, the allowed names. It does the whole verification itself so that each rejection is counted.
+type clientVerifier struct {
+	roots        *x509.CertPool
+	allowedNames map[string]bool
+}
+
+func newClientVerifier(roots *x509.CertPool, allowedNames []string) *clientVerifier {
+	v := &clientVerifier{roots: roots, allowedNames: map[string]bool{}}
+	for _, name := range allowedNames {
+		v.allowedNames[name] = true
+	}
+	return v
+}
+
+// VerifyPeerCertificate implements tls.Config.VerifyPeerCertificate
+func (v *clientVerifier) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return v.reject("no_certificate", errors.New("client did not provide a certificate"))
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return v.reject("invalid_certificate", fmt.Errorf("cannot parse client certificate: %v", err))
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return v.reject("untrusted", fmt.Errorf("client certificate %q is not trusted: %v", leaf.Subject.CommonName, err))
+	}
+	if len(v.allowedNames) != 0 && !v.nameAllowed(leaf) {
+		return v.reject("name_not_allowed", fmt.Errorf("client certificate %q does not match any allowed name", leaf.Subject.CommonName))
+	}
+	return nil
+}
+
+// nameAllowed matches the common name and every SAN of the certificate against the allowed names
+func (v *clientVerifier) nameAllowed(cert *x509.Certificate) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if v.allowedNames[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *clientVerifier) reject(reason string, err error) error {
+	metrics.RejectedClientCertificates.WithLabelValues(reason).Inc()
+	log.Error().Msgf("Rejected webhook connection: %v", err)
+	return err
+}
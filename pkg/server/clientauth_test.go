@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) clientCert(t *testing.T, cn string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestInjectorServer_ClientCertificates(t *testing.T) {
+	ca := newTestCA(t, "webhook client CA")
+	otherCA := newTestCA(t, "other CA")
+
+	webhook := NewWebhookServer()
+	server := httptest.NewUnstartedServer(webhook.bootRouter())
+	server.TLS = tlsConfig(ServerOptions{
+		ClientCAs:          ca.pool(),
+		ClientAllowedNames: []string{"kube-apiserver", "apiserver.cluster.local"},
+	})
+	server.StartTLS()
+	defer server.Close()
+
+	body := readAdmissionRequest(t)
+	post := func(certs ...tls.Certificate) error {
+		// A fresh transport per request, so every request does its own handshake
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		client := &http.Client{Transport: transport}
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/mutate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status got = %d; want = %d", resp.StatusCode, http.StatusOK)
+		}
+		return nil
+	}
+
+	if err := post(ca.clientCert(t, "kube-apiserver")); err != nil {
+		t.Errorf("allowed CN was rejected: %v", err)
+	}
+	if err := post(ca.clientCert(t, "front-proxy", "apiserver.cluster.local")); err != nil {
+		t.Errorf("allowed SAN was rejected: %v", err)
+	}
+
+	cases := []struct {
+		reason string
+		certs  []tls.Certificate
+	}{
+		{"no_certificate", nil},
+		{"untrusted", []tls.Certificate{otherCA.clientCert(t, "kube-apiserver")}},
+		{"name_not_allowed", []tls.Certificate{ca.clientCert(t, "some-pod")}},
+	}
+	for _, c := range cases {
+		before := testutil.ToFloat64(metrics.RejectedClientCertificates.WithLabelValues(c.reason))
+		if err := post(c.certs...); err == nil {
+			t.Errorf("%s: connection was accepted", c.reason)
+		}
+		if got := testutil.ToFloat64(metrics.RejectedClientCertificates.WithLabelValues(c.reason)) - before; got != 1 {
+			t.Errorf("%s: rejected connections counted %v; want 1", c.reason, got)
+		}
+	}
+}
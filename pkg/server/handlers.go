@@ -1,33 +1,116 @@
 package webhook
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"time"
 
+	"github.com/dungdev1/k8s-injector/pkg/config"
 	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (webhook *WebhookServer) Mutate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	log.Info().Msg("Handling mutating request...")
+	ctx, cancel := admissionContext(r)
+	defer cancel()
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Start(ctx, "Mutate", trace.WithSpanKind(trace.SpanKindServer))
+	r = r.WithContext(ctx)
+
+	snapshot := webhook.Snapshots.Load()
+	span.SetAttributes(attribute.Int64("injector.snapshot.generation", int64(snapshot.Generation)))
+	logger := tracing.Logger(ctx)
+	logger.Info().Msgf("Handling mutating request with snapshot generation %d...", snapshot.Generation)
 
 	var writeErr error
 
-	if bytes, err := controller.AdmissionControllerHandler(w, r, controller.ApplyNewConfig, controller.MutatingAdmission, webhook.InjConfigs, webhook.Namespaces); err != nil {
-		log.Error().Msgf("Error handling mutating request: %v", err)
+	bytes, outcome, err := controller.AdmissionControllerHandler(w, r, controller.ApplyNewConfig, controller.MutatingAdmission, snapshot)
+	if outcome != nil {
+		logger = tracing.Logger(controller.WithRequestLogger(ctx, outcome.Request))
+	}
+	if outcome != nil && webhook.Auditor != nil {
+		if auditErr := webhook.Auditor.Log(outcome); auditErr != nil {
+			logger.Error().Msgf("Could not write audit record: %v", auditErr)
+		}
+	}
+	if outcome != nil && webhook.Injections != nil {
+		if ids := appliedSources(outcome.Result); len(ids) != 0 {
+			webhook.Injections.Injected(ids)
+		}
+	}
+	if outcome != nil && webhook.Events != nil {
+		webhook.Events.PodAdmitted(outcome, snapshot.Namespaces[outcome.Request.Namespace])
+	}
+	if outcome != nil {
+		span.SetAttributes(
+			attribute.String("injector.admission.uid", string(outcome.Request.UID)),
+			attribute.String("injector.admission.namespace", outcome.Request.Namespace),
+			attribute.Bool("injector.admission.allowed", outcome.Response.Allowed),
+		)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error().Msgf("Error handling mutating request: %v", err)
 		_, writeErr = w.Write([]byte(err.Error()))
 	} else {
-		log.Info().Msg("Mutating request handled successfully")
+		logger.Info().Msg("Mutating request handled successfully")
 		_, writeErr = w.Write(bytes)
 	}
 
 	if writeErr != nil {
-		log.Info().Msgf("Could not write response: %v", writeErr)
+		logger.Info().Msgf("Could not write response: %v", writeErr)
 	}
 }
 
+// Validate rejects injection ConfigMaps and InjectionConfig objects that would fail to load or to apply, so a
+// broken config is refused at apply time instead of being discovered on the next reload
 func (webhook *WebhookServer) Validate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	log.Info().Msg("Handling validating request...")
+	ctx, cancel := admissionContext(r)
+	defer cancel()
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Start(ctx, "Validate", trace.WithSpanKind(trace.SpanKindServer))
+	r = r.WithContext(ctx)
+
+	snapshot := webhook.Snapshots.Load()
+	logger := tracing.Logger(ctx)
+	logger.Info().Msg("Handling validating request...")
+
+	var writeErr error
+
+	bytes, outcome, err := controller.AdmissionControllerHandler(w, r, controller.ValidateConfigs, controller.ValidatingAdmission, snapshot)
+	if outcome != nil {
+		logger = tracing.Logger(controller.WithRequestLogger(ctx, outcome.Request))
+	}
+	if outcome != nil {
+		span.SetAttributes(
+			attribute.String("injector.admission.uid", string(outcome.Request.UID)),
+			attribute.String("injector.admission.namespace", outcome.Request.Namespace),
+			attribute.Bool("injector.admission.allowed", outcome.Response.Allowed),
+		)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error().Msgf("Error handling validating request: %v", err)
+		_, writeErr = w.Write([]byte(err.Error()))
+	} else {
+		logger.Info().Msg("Validating request handled successfully")
+		_, writeErr = w.Write(bytes)
+	}
+
+	if writeErr != nil {
+		logger.Info().Msgf("Could not write response: %v", writeErr)
+	}
 }
 
 func (webhook *WebhookServer) Health(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -39,3 +122,90 @@ func (webhook *WebhookServer) Health(w http.ResponseWriter, r *http.Request, ps
 		log.Info().Msgf("Could not write response: %v", writeErr)
 	}
 }
+
+// Ready reports whether injection configs are being served. A failing reload keeps the pod ready as long as
+// an earlier config is still served, the failure is only detailed in the response.
+func (webhook *WebhookServer) Ready(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log.Debug().Msg("Handling readiness checking request...")
+
+	status := config.ReloadStatus{}
+	if webhook.Reloads != nil {
+		status = webhook.Reloads.ReloadStatus()
+	}
+	bytes, err := json.Marshal(&status)
+	if err != nil {
+		log.Error().Msgf("Error handling readiness checking request: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, writeErr := w.Write(bytes); writeErr != nil {
+		log.Info().Msgf("Could not write response: %v", writeErr)
+	}
+}
+
+// appliedSources returns the IDs of the sources with at least one config applied by result
+func appliedSources(result *controller.AdmitResult) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, decision := range result.Decisions {
+		if !decision.Applied {
+			continue
+		}
+		for _, id := range decision.Sources {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// limitBody reads at most MaxRequestBytes of the request body before handing it to handle, so an oversized
+// AdmissionReview or preview request is rejected without ever being decoded
+func (webhook *WebhookServer) limitBody(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		max := webhook.Options.MaxRequestBytes
+		if max <= 0 {
+			handle(w, r, ps)
+			return
+		}
+
+		tooLarge := func() {
+			log.Error().Msgf("Rejected request larger than %d bytes", max)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(fmt.Sprintf("request body exceeds %d bytes", max)))
+		}
+		if r.ContentLength > max {
+			tooLarge()
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(fmt.Sprintf("could not read request body: %v", err)))
+			return
+		}
+		if int64(len(body)) > max {
+			tooLarge()
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handle(w, r, ps)
+	}
+}
+
+// admissionContext bounds the request context by the timeout the API server appends to the webhook URL
+// (?timeout=5s, from timeoutSeconds of the webhook configuration), so the admit path stops doing work once
+// the API server has given up on the request
+func admissionContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
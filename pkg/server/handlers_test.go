@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const admissionReqFilePath = "../../docs/template/admission-request.json"
+
+func readAdmissionRequest(t *testing.T) []byte {
+	body, err := os.ReadFile(admissionReqFilePath)
+	if err != nil {
+		t.Fatalf("Cannot read admission request template file %q", admissionReqFilePath)
+	}
+	return body
+}
+
+func mutate(t *testing.T, webhook *WebhookServer, body []byte) *admissionv1.AdmissionResponse {
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	webhook.bootRouter().ServeHTTP(rec, req)
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Errorf("could not decode response %q: %v", rec.Body.String(), err)
+		return nil
+	}
+	return review.Response
+}
+
+func TestMutate_ConcurrentReloads(t *testing.T) {
+	webhook := NewWebhookServer()
+	body := readAdmissionRequest(t)
+
+	enabled := func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/hostPID": {HostPID: new(bool)},
+		})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	}
+	disabled := func(next *config.Snapshot) {
+		next.Configs = map[string][]config.ScopedConfig{}
+		next.Namespaces = map[string]bool{}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				webhook.Snapshots.Update(enabled)
+			} else {
+				webhook.Snapshots.Update(disabled)
+			}
+			webhook.Snapshots.SetNamespace("payment", i%3 == 0)
+		}
+	}()
+
+	var admissions sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		admissions.Add(1)
+		go func() {
+			defer admissions.Done()
+			for j := 0; j < 10; j++ {
+				resp := mutate(t, webhook, body)
+				if resp == nil {
+					return
+				}
+				if !resp.Allowed {
+					t.Errorf("admission was not allowed: %v", resp.Result)
+				}
+				var patches []controller.PatchOperation
+				if len(resp.Patch) != 0 {
+					if err := json.Unmarshal(resp.Patch, &patches); err != nil {
+						t.Errorf("could not decode patch: %v", err)
+					}
+				}
+				if len(patches) > 1 {
+					t.Errorf("got %d patches from a single snapshot; want at most 1", len(patches))
+				}
+			}
+		}()
+	}
+	admissions.Wait()
+	close(done)
+	wg.Wait()
+}
+
+func TestMutate_UsesPublishedSnapshot(t *testing.T) {
+	webhook := NewWebhookServer()
+	body := readAdmissionRequest(t)
+
+	webhook.Snapshots.Update(func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/containers/0/readinessProbe": {Readiness: &corev1.Probe{PeriodSeconds: 10}},
+		})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	})
+	if resp := mutate(t, webhook, body); resp == nil || len(resp.Patch) == 0 {
+		t.Errorf("expected a patch once the namespace is enabled")
+	}
+
+	webhook.Snapshots.SetNamespace("dbservice", false)
+	if resp := mutate(t, webhook, body); resp == nil || len(resp.Patch) != 0 {
+		t.Errorf("expected no patch once the namespace is disabled")
+	}
+}
+
+func TestMutate_RejectsOversizedRequest(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.Options.MaxRequestBytes = 1024
+	body := readAdmissionRequest(t)
+
+	for _, contentLength := range []int64{int64(len(body)), -1} {
+		req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = contentLength
+		rec := httptest.NewRecorder()
+		webhook.bootRouter().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("content length %d: status got = %d; want = %d", contentLength, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestMutate_StopsAfterAdmissionTimeout(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.Snapshots.Update(func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{"/spec/hostPID": {HostPID: new(bool)}})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/mutate?timeout=1ns", bytes.NewReader(readAdmissionRequest(t)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	webhook.bootRouter().ServeHTTP(rec, req)
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	if len(review.Response.Patch) != 0 || review.Response.Result == nil {
+		t.Errorf("expected the admission to stop without a patch, got %+v", review.Response)
+	}
+}
+
+func TestMutate_RecordsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	webhook := NewWebhookServer()
+	webhook.Snapshots.Update(func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/hostPID":     {HostPID: new(bool)},
+			"/spec/hostNetwork": {HostNetwork: new(bool)},
+		})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	})
+	mutate(t, webhook, readAdmissionRequest(t))
+
+	spans := map[string]int{}
+	var traceIDs = map[string]bool{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()]++
+		traceIDs[span.SpanContext().TraceID().String()] = true
+	}
+	want := map[string]int{"Mutate": 1, "DecodeAdmissionReview": 1, "EvaluateConfig": 2, "MarshalPatch": 1}
+	for name, count := range want {
+		if spans[name] != count {
+			t.Errorf("got %d %q spans; want %d (all spans: %v)", spans[name], name, count, spans)
+		}
+	}
+	if len(traceIDs) != 1 {
+		t.Errorf("spans belong to %d traces; want 1", len(traceIDs))
+	}
+}
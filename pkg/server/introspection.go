@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+)
+
+// WatcherStatus reports when each watcher last received an event
+type WatcherStatus interface {
+	LastEventTimes() map[string]time.Time
+}
+
+// ConfigReloadStatus reports the outcome of the latest injection config reloads
+type ConfigReloadStatus interface {
+	ReloadStatus() config.ReloadStatus
+}
+
+// LeaderStatus reports whether this replica runs the background loops reserved to the leader
+type LeaderStatus interface {
+	IsLeader() bool
+}
+
+// ConfigsResponse is what the injector currently believes, taken from a single snapshot
+type ConfigsResponse struct {
+	SnapshotGeneration uint64                  `json:"snapshotGeneration"`
+	InjectionConfigs   map[string][]ConfigView `json:"injectionConfigs"`
+	Sources            []config.ConfigSource   `json:"sources"`
+	Namespaces         []string                `json:"namespaces"`
+	Watchers           map[string]time.Time    `json:"watchers"`
+	Reload             *config.ReloadStatus    `json:"reload,omitempty"`
+	Leader             *bool                   `json:"leader,omitempty"`
+}
+
+// ConfigView is one of the configs of a path, with the pods it applies to and where it came from
+type ConfigView struct {
+	Source string `json:"source,omitempty"`
+	// Namespaces the config applies to, every namespace when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// PodSelector selects the pods the config applies to, every pod when empty
+	PodSelector string `json:"podSelector,omitempty"`
+	// Extends lists the names of the configs it extends, nearest first
+	Extends []string                `json:"extends,omitempty"`
+	Config  *config.InjectionConfig `json:"config"`
+}
+
+// Configs dumps the active injection configs as they were parsed, where they came from and the state of the
+// watchers feeding them. The configs of a path are listed by precedence, a pod gets the first one applying to
+// it, or every one applying to it for paths appending to a list. Configs extending others are dumped resolved,
+// with the names of the configs they extend.
+func (webhook *WebhookServer) Configs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log.Debug().Msg("Handling configs introspection request...")
+
+	snapshot := webhook.Snapshots.Load()
+	resp := ConfigsResponse{
+		SnapshotGeneration: snapshot.Generation,
+		InjectionConfigs:   make(map[string][]ConfigView, len(snapshot.Configs)),
+		Sources:            snapshot.Sources,
+		Namespaces:         make([]string, 0, len(snapshot.Namespaces)),
+		Watchers:           map[string]time.Time{},
+	}
+	if resp.Sources == nil {
+		resp.Sources = []config.ConfigSource{}
+	}
+	for path, configs := range snapshot.Configs {
+		for _, cfg := range configs {
+			view := ConfigView{Source: cfg.Source, Extends: cfg.Bases, Config: cfg.Config}
+			if cfg.Selector != nil {
+				view.Namespaces = cfg.Selector.Namespaces
+				if cfg.Selector.Pods != nil {
+					view.PodSelector = cfg.Selector.Pods.String()
+				}
+			}
+			resp.InjectionConfigs[path] = append(resp.InjectionConfigs[path], view)
+		}
+	}
+	for ns := range snapshot.Namespaces {
+		resp.Namespaces = append(resp.Namespaces, ns)
+	}
+	sort.Strings(resp.Namespaces)
+	if webhook.Watchers != nil {
+		resp.Watchers = webhook.Watchers.LastEventTimes()
+	}
+	if webhook.Reloads != nil {
+		status := webhook.Reloads.ReloadStatus()
+		resp.Reload = &status
+	}
+	if webhook.Leader != nil {
+		leader := webhook.Leader.IsLeader()
+		resp.Leader = &leader
+	}
+
+	bytes, err := json.MarshalIndent(&resp, "", "  ")
+	if err != nil {
+		log.Error().Msgf("Error handling configs introspection request: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, writeErr := w.Write(bytes); writeErr != nil {
+		log.Info().Msgf("Could not write response: %v", writeErr)
+	}
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+)
+
+type fakeWatchers map[string]time.Time
+
+func (f fakeWatchers) LastEventTimes() map[string]time.Time {
+	return f
+}
+
+func TestConfigs(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+	lastEvent := time.Date(2021, 7, 23, 12, 47, 8, 0, time.UTC)
+	webhook.Watchers = fakeWatchers{"configmap": lastEvent}
+	webhook.Snapshots.SetInjConfigs(map[string]*config.InjectionConfig{
+		"/spec/hostPID": {HostPID: new(bool)},
+	}, config.ConfigSource{
+		Kind:            "ConfigMap",
+		Namespace:       "kube-system",
+		Name:            "k8s-injector",
+		ResourceVersion: "42",
+		LoadErrors:      map[string]string{".spec.bad": "error converting YAML to JSON"},
+	})
+	webhook.Snapshots.SetNamespace("payment", true)
+	webhook.Snapshots.SetNamespace("dbservice", true)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/configs", nil)
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+
+	resp := ConfigsResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	if resp.SnapshotGeneration != 3 || len(resp.InjectionConfigs["/spec/hostPID"]) != 1 {
+		t.Errorf("unexpected configs: %+v", resp)
+	}
+	if len(resp.Sources) != 1 || resp.Sources[0].ResourceVersion != "42" || resp.Sources[0].LoadErrors[".spec.bad"] == "" {
+		t.Errorf("unexpected sources: %+v", resp.Sources)
+	}
+	if len(resp.Namespaces) != 2 || resp.Namespaces[0] != "dbservice" || resp.Namespaces[1] != "payment" {
+		t.Errorf("namespaces got = %v; want = [dbservice payment]", resp.Namespaces)
+	}
+	if !resp.Watchers["configmap"].Equal(lastEvent) {
+		t.Errorf("watchers got = %v", resp.Watchers)
+	}
+}
+
+func TestConfigs_Extends(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+	base := "base"
+	webhook.Snapshots.SetConfigs(config.MergeSources([]config.SourceConfigs{{
+		Source: config.ConfigSource{Kind: "ConfigMap", Namespace: "kube-system", Name: "k8s-injector"},
+		InjConfigs: map[string]*config.InjectionConfig{
+			"/spec/hostPID": {Name: &base, HostPID: new(bool)},
+			"/spec/hostIPC": {Extends: &base},
+		},
+	}}))
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/configs", nil)
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+
+	resp := ConfigsResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	hostIPC := resp.InjectionConfigs["/spec/hostIPC"]
+	if len(hostIPC) != 1 || !reflect.DeepEqual(hostIPC[0].Extends, []string{"base"}) {
+		t.Errorf("configs got = %+v; want /spec/hostIPC to extend base", resp.InjectionConfigs)
+	}
+	if resolved := hostIPC[0].Config; resolved == nil || resolved.HostPID == nil {
+		t.Errorf("configs got = %+v; want /spec/hostIPC resolved", resp.InjectionConfigs)
+	}
+	if source := hostIPC[0].Source; source != "ConfigMap/kube-system/k8s-injector" {
+		t.Errorf("source got = %q; want the ConfigMap it came from", source)
+	}
+}
+
+func TestConfigs_DisabledByDefault(t *testing.T) {
+	webhook := NewWebhookServer()
+	req := httptest.NewRequest(http.MethodGet, "/debug/configs", nil)
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status got = %d; want = %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+type fakeReloads config.ReloadStatus
+
+func (f fakeReloads) ReloadStatus() config.ReloadStatus {
+	return config.ReloadStatus(f)
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		status config.ReloadStatus
+		want   int
+	}{
+		{name: "no reload yet", want: http.StatusOK},
+		{name: "loaded", status: config.ReloadStatus{LastSuccess: time.Now()}, want: http.StatusOK},
+		{name: "failing after a success", status: config.ReloadStatus{LastSuccess: time.Now(), ConsecutiveFailures: 2, LastError: "bad apply"}, want: http.StatusOK},
+		{name: "never loaded", status: config.ReloadStatus{ConsecutiveFailures: 1, LastError: "bad apply"}, want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := NewWebhookServer()
+			webhook.Reloads = fakeReloads(tt.status)
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			rec := httptest.NewRecorder()
+			webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status code got = %d; want = %d", rec.Code, tt.want)
+			}
+			got := config.ReloadStatus{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+			}
+			if got.LastError != tt.status.LastError {
+				t.Errorf("lastError got = %q; want = %q", got.LastError, tt.status.LastError)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// maxLogLevelBytes bounds the body of a log level change
+const maxLogLevelBytes = 1 << 10
+
+// LogLevelBody is the log level in effect, and the body of a log level change
+type LogLevelBody struct {
+	Level string `json:"level"`
+}
+
+// LogLevel returns the log level in effect
+func (webhook *WebhookServer) LogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeLogLevel(w)
+}
+
+// SetLogLevel changes the log level of the whole process until the next restart, or until a SIGHUP reloads a
+// different log-level from the config file. The level is given as {"level": "debug"}.
+func (webhook *WebhookServer) SetLogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeError := func(status int, err error) {
+		log.Error().Msgf("Error handling log level change: %v", err)
+		w.WriteHeader(status)
+		if _, writeErr := w.Write([]byte(err.Error())); writeErr != nil {
+			log.Info().Msgf("Could not write response: %v", writeErr)
+		}
+	}
+
+	body := LogLevelBody{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxLogLevelBytes)).Decode(&body); err != nil {
+		writeError(http.StatusBadRequest, fmt.Errorf("could not deserialize request: %v", err))
+		return
+	}
+	level, err := config.ParseLogLevel(body.Level)
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(level)
+	// Logged without a level so that the change shows at any level
+	log.Log().Msgf("Log level changed from %s to %s", previous, level)
+	writeLogLevel(w)
+}
+
+func writeLogLevel(w http.ResponseWriter) {
+	bytes, err := json.Marshal(&LogLevelBody{Level: zerolog.GlobalLevel().String()})
+	if err != nil {
+		log.Error().Msgf("Error handling log level request: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, writeErr := w.Write(bytes); writeErr != nil {
+		log.Info().Msgf("Could not write response: %v", writeErr)
+	}
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusOK, want: "info"},
+		{name: "set", method: http.MethodPut, body: `{"level": "WARN"}`, status: http.StatusOK, want: "warn"},
+		{name: "invalid level", method: http.MethodPut, body: `{"level": "verbose"}`, status: http.StatusBadRequest, want: "warn"},
+		{name: "invalid body", method: http.MethodPut, body: `trace`, status: http.StatusBadRequest, want: "warn"},
+		{name: "set again", method: http.MethodPut, body: `{"level": "trace"}`, status: http.StatusOK, want: "trace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/loglevel", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status got = %d; want = %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := zerolog.GlobalLevel().String(); got != tt.want {
+				t.Errorf("global level got = %s; want = %s", got, tt.want)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := LogLevelBody{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Level != tt.want {
+				t.Errorf("response got = %q (%v); want level %s", rec.Body.String(), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLogLevel_DisabledByDefault(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	webhook := NewWebhookServer()
+
+	req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level": "trace"}`))
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status got = %d; want = %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := zerolog.GlobalLevel(); got != zerolog.InfoLevel {
+		t.Errorf("global level got = %s; want it unchanged", got)
+	}
+}
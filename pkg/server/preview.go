@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PreviewRequest is the body of a mutation preview, either as JSON or YAML. Namespace and Annotations
+// override the ones of the pod manifest.
+type PreviewRequest struct {
+	Pod         corev1.Pod        `json:"pod"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// PreviewResponse shows what /mutate would do with the pod of a PreviewRequest
+type PreviewResponse struct {
+	SnapshotGeneration uint64                      `json:"snapshotGeneration"`
+	Namespace          string                      `json:"namespace"`
+	Allowed            bool                        `json:"allowed"`
+	DenyReason         string                      `json:"denyReason,omitempty"`
+	Patch              []controller.PatchOperation `json:"patch"`
+	MutatedPod         json.RawMessage             `json:"mutatedPod,omitempty"`
+	PatchError         string                      `json:"patchError,omitempty"`
+	Decisions          []controller.ConfigDecision `json:"decisions"`
+	Warnings           []string                    `json:"warnings,omitempty"`
+}
+
+// Preview runs a pod manifest through the same admit pipeline as /mutate against the live snapshot, without
+// any admission actually happening
+func (webhook *WebhookServer) Preview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log.Info().Msg("Handling mutation preview request...")
+
+	writeError := func(status int, err error) {
+		log.Error().Msgf("Error handling mutation preview request: %v", err)
+		w.WriteHeader(status)
+		if _, writeErr := w.Write([]byte(err.Error())); writeErr != nil {
+			log.Info().Msgf("Could not write response: %v", writeErr)
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(http.StatusBadRequest, fmt.Errorf("could not read request: %v", err))
+		return
+	}
+	previewReq := PreviewRequest{}
+	if err := yaml.Unmarshal(body, &previewReq); err != nil {
+		writeError(http.StatusBadRequest, fmt.Errorf("could not deserialize request: %v", err))
+		return
+	}
+
+	snapshot := webhook.Snapshots.Load()
+	outcome, podJSON, err := previewAdmission(r, &previewReq, snapshot)
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := PreviewResponse{
+		SnapshotGeneration: outcome.Generation,
+		Namespace:          outcome.Request.Namespace,
+		Allowed:            outcome.Response.Allowed,
+		Patch:              outcome.Result.Patches,
+		Decisions:          outcome.Result.Decisions,
+		Warnings:           outcome.Response.Warnings,
+	}
+	if outcome.Response.Result != nil {
+		resp.DenyReason = outcome.Response.Result.Message
+	}
+	if resp.Patch == nil {
+		resp.Patch = []controller.PatchOperation{}
+	}
+	resp.MutatedPod = podJSON
+	if len(outcome.Response.Patch) != 0 {
+		if mutated, err := applyPatch(podJSON, outcome.Response.Patch); err != nil {
+			resp.PatchError = err.Error()
+		} else {
+			resp.MutatedPod = mutated
+		}
+	}
+
+	bytes, err := json.Marshal(&resp)
+	if err != nil {
+		writeError(http.StatusInternalServerError, fmt.Errorf("marshaling response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, writeErr := w.Write(bytes); writeErr != nil {
+		log.Info().Msgf("Could not write response: %v", writeErr)
+	}
+}
+
+// previewAdmission wraps the pod of a preview into an AdmissionRequest for a pod creation and admits it
+func previewAdmission(r *http.Request, previewReq *PreviewRequest, snapshot *config.Snapshot) (*controller.AdmissionOutcome, []byte, error) {
+	pod := previewReq.Pod
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	if previewReq.Namespace != "" {
+		pod.Namespace = previewReq.Namespace
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = metav1.NamespaceDefault
+	}
+	if len(previewReq.Annotations) != 0 {
+		annotations := map[string]string{}
+		for k, v := range pod.Annotations {
+			annotations[k] = v
+		}
+		for k, v := range previewReq.Annotations {
+			annotations[k] = v
+		}
+		pod.Annotations = annotations
+	}
+
+	podJSON, err := json.Marshal(&pod)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not marshal pod: %v", err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID("preview"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: podJSON},
+	}
+	outcome, err := controller.Admit(r.Context(), req, controller.ApplyNewConfig, controller.MutatingAdmission, snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	return outcome, podJSON, nil
+}
+
+func applyPatch(doc []byte, patch []byte) ([]byte, error) {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode JSON patch: %v", err)
+	}
+	mutated, err := decoded.Apply(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply JSON patch: %v", err)
+	}
+	return mutated, nil
+}
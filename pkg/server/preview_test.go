@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const previewPod = `
+namespace: dbservice
+annotations:
+  team: payment
+pod:
+  metadata:
+    name: busybox
+  spec:
+    containers:
+    - name: busybox
+      image: busybox
+`
+
+func preview(t *testing.T, webhook *WebhookServer, body string) PreviewResponse {
+	req := httptest.NewRequest(http.MethodPost, "/debug/preview", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status got = %d; want = %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	resp := PreviewResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestPreview(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+	webhook.Snapshots.Update(func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/hostPID":     {HostPID: new(bool)},
+			"/spec/hostNetwork": {},
+		})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	})
+
+	resp := preview(t, webhook, previewPod)
+	if !resp.Allowed || resp.Namespace != "dbservice" || resp.SnapshotGeneration != 1 {
+		t.Errorf("unexpected preview: %+v", resp)
+	}
+	if len(resp.Patch) != 1 || resp.Patch[0].Path != "/spec/hostPID" {
+		t.Errorf("patch got = %+v; want a single /spec/hostPID patch", resp.Patch)
+	}
+	if len(resp.Decisions) != 2 || resp.Decisions[0].Applied || !resp.Decisions[1].Applied {
+		t.Errorf("decisions got = %+v; want /spec/hostNetwork skipped and /spec/hostPID applied", resp.Decisions)
+	}
+	if resp.PatchError != "" || !strings.Contains(string(resp.MutatedPod), `"hostPID"`) || !strings.Contains(string(resp.MutatedPod), `"team":"payment"`) {
+		t.Errorf("mutated pod got = %s (%s)", resp.MutatedPod, resp.PatchError)
+	}
+
+	webhook.Snapshots.SetNamespace("dbservice", false)
+	resp = preview(t, webhook, previewPod)
+	if len(resp.Patch) != 0 || len(resp.Decisions) != 2 || resp.Decisions[1].Applied || !strings.Contains(resp.Decisions[1].Reason, "not enabled") {
+		t.Errorf("expected every config to be skipped for a disabled namespace, got %+v", resp)
+	}
+}
+
+func TestPreview_MutatedPodHasTypedFields(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+	webhook.Snapshots.Update(func(next *config.Snapshot) {
+		next.Configs = config.ScopedConfigs(map[string]*config.InjectionConfig{
+			"/spec/hostPID":      {HostPID: new(bool)},
+			"/spec/volumes":      {Volumes: []corev1.Volume{{Name: "shared"}}},
+			"/spec/containers/-": {Containers: []corev1.Container{{Name: "sidecar", Image: "sidecar"}}},
+		})
+		next.Namespaces = map[string]bool{"dbservice": true}
+	})
+
+	resp := preview(t, webhook, previewPod)
+	if resp.PatchError != "" {
+		t.Fatalf("patch error got = %s", resp.PatchError)
+	}
+	mutated := struct {
+		Spec struct {
+			HostPID    *bool              `json:"hostPID"`
+			Volumes    []corev1.Volume    `json:"volumes"`
+			Containers []corev1.Container `json:"containers"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(resp.MutatedPod, &mutated); err != nil {
+		t.Fatalf("mutated pod %s does not have typed fields: %v", resp.MutatedPod, err)
+	}
+	spec := mutated.Spec
+	if spec.HostPID == nil || *spec.HostPID || len(spec.Volumes) != 1 || spec.Volumes[0].Name != "shared" || len(spec.Containers) != 2 || spec.Containers[1].Name != "sidecar" {
+		t.Errorf("mutated pod got = %s; want hostPID false, the shared volume and the sidecar", resp.MutatedPod)
+	}
+}
+
+func TestPreview_DisabledByDefault(t *testing.T) {
+	webhook := NewWebhookServer()
+	req := httptest.NewRequest(http.MethodPost, "/debug/preview", strings.NewReader(previewPod))
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status got = %d; want = %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPreview_RejectsOversizedRequest(t *testing.T) {
+	webhook := NewWebhookServer()
+	webhook.DebugEndpoints = true
+	webhook.Options.MaxRequestBytes = 64
+	req := httptest.NewRequest(http.MethodPost, "/debug/preview", strings.NewReader(previewPod))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	webhook.lifeCycleBootRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status got = %d; want = %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
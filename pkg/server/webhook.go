@@ -2,33 +2,89 @@ package webhook
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/dungdev1/k8s-injector/pkg/audit"
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"github.com/dungdev1/k8s-injector/pkg/metrics"
 	"github.com/julienschmidt/httprouter"
 )
 
 type WebhookServer struct {
 	server          *http.Server
 	lifecycleServer *http.Server
-	InjConfigs      map[string]*config.InjectionConfig
-	Namespaces      map[string]bool
+	Snapshots       *config.SnapshotStore
+	Auditor         *audit.Logger
+	DebugEndpoints  bool
+	Watchers        WatcherStatus
+	Reloads         ConfigReloadStatus
+	Injections      InjectionRecorder
+	Events          AdmissionEventRecorder
+	Leader          LeaderStatus
+	// Options are set before the servers are started, the request size limit applies to both of them
+	Options ServerOptions
+}
+
+// InjectionRecorder is told, for every mutated pod, the IDs of the sources whose configs were applied to it
+type InjectionRecorder interface {
+	Injected(sourceIDs []string)
+}
+
+// AdmissionEventRecorder is told about every admitted pod, and whether injection is enabled for its namespace
+type AdmissionEventRecorder interface {
+	PodAdmitted(outcome *controller.AdmissionOutcome, namespaceEnabled bool)
+}
+
+// ServerOptions hardens the webhook server against slow or oversized requests and, when ClientCAs is set,
+// against clients other than the API server
+type ServerOptions struct {
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	MaxRequestBytes    int64
+	TLSMinVersion      uint16
+	TLSCipherSuites    []uint16
+	ClientCAs          *x509.CertPool
+	ClientAllowedNames []string
 }
 
 func NewWebhookServer() *WebhookServer {
-	return &WebhookServer{}
+	return &WebhookServer{
+		Snapshots: config.NewSnapshotStore(),
+	}
 }
 
 func (webhook *WebhookServer) StartInjectorServer(port int, tlsCert string, tlsKey string) error {
+	opts := webhook.Options
 	webhook.server = &http.Server{
-		Addr:    ":" + strconv.Itoa(port),
-		Handler: webhook.bootRouter(),
+		Addr:         ":" + strconv.Itoa(port),
+		Handler:      webhook.bootRouter(),
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		TLSConfig:    tlsConfig(opts),
 	}
 
 	return webhook.server.ListenAndServeTLS(tlsCert, tlsKey)
 }
 
+func tlsConfig(opts ServerOptions) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:   opts.TLSMinVersion,
+		CipherSuites: opts.TLSCipherSuites,
+	}
+	if opts.ClientCAs != nil {
+		// The client certificate is only requested here, the verifier then rejects and counts every
+		// connection without a valid one, including connections without any certificate at all
+		cfg.ClientAuth = tls.RequestClientCert
+		cfg.VerifyPeerCertificate = newClientVerifier(opts.ClientCAs, opts.ClientAllowedNames).VerifyPeerCertificate
+	}
+	return cfg
+}
+
 func (webhook *WebhookServer) StartLifeCycleServer(port int) error {
 	webhook.lifecycleServer = &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
@@ -45,7 +101,8 @@ func (webhook *WebhookServer) Shutdown() error {
 func (webhook *WebhookServer) bootRouter() *httprouter.Router {
 	router := httprouter.New()
 
-	router.POST("/mutate", webhook.Mutate)
+	router.POST("/mutate", webhook.limitBody(webhook.Mutate))
+	router.POST("/validate", webhook.limitBody(webhook.Validate))
 
 	return router
 }
@@ -54,6 +111,16 @@ func (webhook *WebhookServer) lifeCycleBootRouter() *httprouter.Router {
 	router := httprouter.New()
 
 	router.GET("/healthz", webhook.Health)
+	router.GET("/readyz", webhook.Ready)
+	router.GET("/loglevel", webhook.LogLevel)
+	router.Handler(http.MethodGet, "/metrics", metrics.Handler())
+	// The lifecycle port is plain HTTP without authentication, the configs may hold secrets in env values and
+	// anyone reaching it could turn on debug logging, which logs whole pods and their patches
+	if webhook.DebugEndpoints {
+		router.PUT("/loglevel", webhook.SetLogLevel)
+		router.GET("/debug/configs", webhook.Configs)
+		router.POST("/debug/preview", webhook.limitBody(webhook.Preview))
+	}
 
 	return router
 }
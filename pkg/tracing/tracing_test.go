@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestLogger_AddsTraceIDs(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tracetest.NewSpanRecorder())))
+
+	out := &bytes.Buffer{}
+	logger := zerolog.New(out)
+	ctx := logger.WithContext(context.Background())
+	ctx, span := Start(ctx, "test")
+	Logger(ctx).Info().Msg("inside span")
+	span.End()
+
+	line := out.String()
+	if !strings.Contains(line, span.SpanContext().TraceID().String()) || !strings.Contains(line, span.SpanContext().SpanID().String()) {
+		t.Errorf("log line %q does not carry the trace and span IDs", line)
+	}
+
+	out.Reset()
+	Logger(logger.WithContext(context.Background())).Info().Msg("outside span")
+	if strings.Contains(out.String(), "trace_id") {
+		t.Errorf("log line %q should not carry a trace ID", out.String())
+	}
+}
+
+func TestSetup_RejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Options{Exporter: "zipkin"}); err == nil {
+		t.Error("Setup() expected an error for an unknown exporter")
+	}
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,240 @@
+// Package validate checks injection configs offline the way the injector loads them, and trial-applies them
+// to sample pods.
+package validate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/controller"
+	"github.com/dungdev1/k8s-injector/pkg/inject"
+	"github.com/dungdev1/k8s-injector/pkg/watcher"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeyResult is what was found about a single key
+type KeyResult struct {
+	Source   string   `json:"source"`
+	Key      string   `json:"key"`
+	Path     string   `json:"path,omitempty"`
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Report is what was found about every key of the sources, and the conflicts between them
+type Report struct {
+	Valid     bool              `json:"valid"`
+	Keys      []KeyResult       `json:"keys"`
+	Conflicts []config.Conflict `json:"conflicts,omitempty"`
+}
+
+// Sample is a pod configs are trial-applied to, with the name it is reported by
+type Sample struct {
+	Name string
+	Pod  *corev1.Pod
+}
+
+// Source is a loaded source with the payloads it was loaded from, by key
+type Source struct {
+	config.SourceConfigs
+	Data map[string]string
+}
+
+// LoadSources loads the ConfigMaps of files and the directories dirs strictly
+func LoadSources(ctx context.Context, files, dirs []string) ([]Source, error) {
+	cfms, err := inject.ReadConfigMaps(files)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	for _, cfm := range cfms {
+		sources = append(sources, Source{watcher.LoadConfigMap(ctx, cfm, config.DecodingStrict), cfm.Data})
+	}
+	for _, dir := range dirs {
+		loaded, data, err := (&watcher.DirSource{Dir: dir, Decoding: config.DecodingStrict}).Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, Source{loaded, data})
+	}
+	return sources, nil
+}
+
+// SamplePods returns the sample pod the injector trial-applies configs to, and the pods of files
+func SamplePods(files []string) ([]Sample, error) {
+	samples := []Sample{{Name: "sample", Pod: controller.SamplePod()}}
+	for _, file := range files {
+		docs, err := inject.ReadDocuments(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range docs {
+			pod, err := PodOf(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			name := file
+			if len(docs) > 1 {
+				name = fmt.Sprintf("%s#%d", file, i+1)
+			}
+			samples = append(samples, Sample{Name: name, Pod: pod})
+		}
+	}
+	return samples, nil
+}
+
+// PodOf decodes a Pod manifest, or the pod of an AdmissionReview in the namespace of its request
+func PodOf(obj map[string]interface{}) (*corev1.Pod, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{}
+	switch obj["kind"] {
+	case "Pod":
+		if err := json.Unmarshal(raw, pod); err != nil {
+			return nil, err
+		}
+	case "AdmissionReview":
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(raw, &review); err != nil {
+			return nil, err
+		}
+		if review.Request == nil {
+			return nil, fmt.Errorf("the AdmissionReview has no request")
+		}
+		if err := json.Unmarshal(review.Request.Object.Raw, pod); err != nil {
+			return nil, fmt.Errorf("could not deserialize pod object: %v", err)
+		}
+		if pod.Namespace == "" {
+			pod.Namespace = review.Request.Namespace
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a Pod or an AdmissionReview", inject.DescribeObject(obj))
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = metav1.NamespaceDefault
+	}
+	return pod, nil
+}
+
+// Sources merges sources the way the injector does, and checks every key of them: its load errors and
+// warnings, whether it is overridden, and whether it applies to each of samples on its own. The configs that
+// apply on their own are then trial-applied together to each sample, and reported as conflicts when they do
+// not.
+func Sources(ctx context.Context, sources []Source, samples []Sample) *Report {
+	toMerge := make([]config.SourceConfigs, 0, len(sources))
+	for _, source := range sources {
+		toMerge = append(toMerge, source.SourceConfigs)
+	}
+	merged := config.MergeSources(toMerge)
+	// Merging adds the configs that cannot be resolved to the load errors of their source
+	mergedSources := map[string]config.ConfigSource{}
+	for _, source := range merged.Sources {
+		mergedSources[source.ID()] = source
+	}
+
+	report := &Report{Valid: true, Keys: []KeyResult{}}
+	// applies holds the configs that apply on their own to each sample pod, by path
+	applies := make([]map[string][]config.ScopedConfig, len(samples))
+	for i := range samples {
+		applies[i] = map[string][]config.ScopedConfig{}
+	}
+	for _, loaded := range sources {
+		source := mergedSources[loaded.Source.ID()]
+		for _, key := range sortedKeys(loaded.Data) {
+			result := KeyResult{Source: inject.DescribeSource(source), Key: key}
+			path, _ := config.KeyToPath(key)
+			result.Path = path
+			if err, ok := source.LoadErrors[key]; ok {
+				result.Errors = append(result.Errors, err)
+			}
+			if warning, ok := source.LoadWarnings[key]; ok {
+				result.Warnings = append(result.Warnings, warning)
+			}
+			if owner, ok := source.Overridden[path]; ok {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("overridden by the same path in %s", owner))
+			}
+
+			for _, cfg := range merged.Configs[path] {
+				if cfg.Source != source.ID() {
+					continue
+				}
+				for i, sample := range samples {
+					if err := controller.TrialApply(ctx, map[string][]config.ScopedConfig{path: {cfg}}, sample.Pod); err != nil {
+						result.Errors = append(result.Errors, fmt.Sprintf("pod %s: %v", sample.Name, err))
+						continue
+					}
+					applies[i][path] = append(applies[i][path], cfg)
+				}
+			}
+			result.Valid = len(result.Errors) == 0
+			report.Valid = report.Valid && result.Valid
+			report.Keys = append(report.Keys, result)
+		}
+	}
+
+	report.Conflicts = config.FindConflicts(merged.Configs)
+	for i, sample := range samples {
+		if len(applies[i]) < 2 {
+			continue
+		}
+		if err := controller.TrialApply(ctx, applies[i], sample.Pod); err != nil {
+			report.Conflicts = append(report.Conflicts, config.Conflict{
+				Keys:   sortedKeysOf(applies[i]),
+				Reason: fmt.Sprintf("pod %s: the configs apply one by one but not together: %v", sample.Name, err),
+			})
+		}
+	}
+	report.Valid = report.Valid && len(report.Conflicts) == 0
+	return report
+}
+
+// WriteReport writes report to w in a human readable form
+func WriteReport(w io.Writer, report *Report) {
+	invalid := 0
+	for _, result := range report.Keys {
+		status := "ok"
+		if !result.Valid {
+			status = "invalid"
+			invalid++
+		}
+		fmt.Fprintf(w, "%s: key %q: %s\n", result.Source, result.Key, status)
+		for _, err := range result.Errors {
+			fmt.Fprintf(w, "  error: %s\n", err)
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(w, "  warning: %s\n", warning)
+		}
+	}
+	for _, conflict := range report.Conflicts {
+		fmt.Fprintf(w, "conflict between %s: %s\n", strings.Join(conflict.Keys, ", "), conflict.Reason)
+	}
+	fmt.Fprintf(w, "%d keys, %d invalid, %d conflicts\n", len(report.Keys), invalid, len(report.Conflicts))
+}
+
+func sortedKeysOf(m map[string][]config.ScopedConfig) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
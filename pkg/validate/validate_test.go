@@ -0,0 +1,172 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const sidecar = "containers:\n- name: sidecar\n  image: sidecar\n"
+
+// configMapSources loads a ConfigMap of each of data the way LoadSources does, named first, second...
+func configMapSources(data ...map[string]string) []Source {
+	names := []string{"first", "second", "third"}
+	var sources []Source
+	for i, d := range data {
+		cfm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: names[i], Namespace: metav1.NamespaceSystem}, Data: d}
+		sources = append(sources, Source{watcher.LoadConfigMap(context.Background(), cfm, config.DecodingStrict), d})
+	}
+	return sources
+}
+
+func TestSources(t *testing.T) {
+	tests := []struct {
+		name string
+		data []map[string]string
+		// errors maps keys to a part of their error, keys that are not in it are valid
+		errors    map[string]string
+		warning   string
+		conflicts int
+	}{
+		{
+			name: "sidecar",
+			data: []map[string]string{{".spec.containers.-": sidecar}},
+		},
+		{
+			name: "env var of the first container",
+			data: []map[string]string{{".spec.containers.0.env.-": "env:\n- {name: TARGET, value: db}\n"}},
+		},
+		{
+			// The patch the webhook sends holds the values themselves, a value encoded as a string would not apply
+			name: "bool and list values",
+			data: []map[string]string{{".spec.hostPID": "hostPID: false\n", ".spec.volumes": "volumes:\n- name: shared\n  emptyDir: {}\n"}},
+		},
+		{
+			name:   "undecodable payload",
+			data:   []map[string]string{{".spec.hostPID": "hostPID: [", ".spec.containers.-": sidecar}},
+			errors: map[string]string{".spec.hostPID": "yaml"},
+		},
+		{
+			name:   "missing base",
+			data:   []map[string]string{{".spec.hostPID": "extends: missing\nhostPID: true\n"}},
+			errors: map[string]string{".spec.hostPID": `extends "missing", but no config is named so`},
+		},
+		{
+			name:    "overridden path",
+			data:    []map[string]string{{".spec.hostPID": "hostPID: true\n"}, {".spec.hostPID": "hostPID: false\n"}},
+			warning: "overridden by the same path in ConfigMap/kube-system/first",
+		},
+		{
+			name:      "same container twice",
+			data:      []map[string]string{{".spec.containers.-": sidecar}, {".spec.containers.-": sidecar}},
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := SamplePods(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := Sources(context.Background(), configMapSources(tt.data...), samples)
+
+			keys := 0
+			for _, d := range tt.data {
+				keys += len(d)
+			}
+			if len(report.Keys) != keys {
+				t.Fatalf("keys = %+v; want every key reported", report.Keys)
+			}
+			warned := false
+			for _, result := range report.Keys {
+				want, invalid := tt.errors[result.Key]
+				got := strings.Join(result.Errors, "; ")
+				if result.Valid == invalid || !strings.Contains(got, want) {
+					t.Errorf("key %s of %s = valid %t, errors %q; want an error containing %q: %t", result.Key, result.Source, result.Valid, got, want, invalid)
+				}
+				for _, warning := range result.Warnings {
+					warned = warned || (tt.warning != "" && strings.Contains(warning, tt.warning))
+				}
+			}
+			if tt.warning != "" && !warned {
+				t.Errorf("keys = %+v; want a warning containing %q", report.Keys, tt.warning)
+			}
+			if len(report.Conflicts) != tt.conflicts {
+				t.Errorf("conflicts = %+v; want %d", report.Conflicts, tt.conflicts)
+			}
+			if want := len(tt.errors) == 0 && tt.conflicts == 0; report.Valid != want {
+				t.Errorf("valid = %t; want %t", report.Valid, want)
+			}
+		})
+	}
+}
+
+func TestPodOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		obj       map[string]interface{}
+		namespace string
+		wantErr   string
+	}{
+		{
+			name:      "pod without namespace",
+			obj:       map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"name": "web"}},
+			namespace: metav1.NamespaceDefault,
+		},
+		{
+			name: "admission review",
+			obj: map[string]interface{}{
+				"apiVersion": "admission.k8s.io/v1",
+				"kind":       "AdmissionReview",
+				"request": map[string]interface{}{
+					"uid":       "1",
+					"namespace": "payment",
+					"object":    map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"generateName": "web-"}},
+				},
+			},
+			namespace: "payment",
+		},
+		{
+			name:    "admission review without request",
+			obj:     map[string]interface{}{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"},
+			wantErr: "the AdmissionReview has no request",
+		},
+		{
+			name:    "other kind",
+			obj:     map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"name": "web"}},
+			wantErr: "Service web is not a Pod or an AdmissionReview",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := PodOf(tt.obj)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("PodOf() err = %v; want %q", err, tt.wantErr)
+			}
+			if err == nil && pod.Namespace != tt.namespace {
+				t.Errorf("namespace = %q; want %q", pod.Namespace, tt.namespace)
+			}
+		})
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	samples, err := SamplePods(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Sources(context.Background(), configMapSources(map[string]string{".spec.hostPID": "hostPID: ["}), samples)
+
+	out := &bytes.Buffer{}
+	WriteReport(out, report)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if lines[0] != `ConfigMap kube-system/first: key ".spec.hostPID": invalid` || lines[len(lines)-1] != "1 keys, 1 invalid, 0 conflicts" {
+		t.Errorf("WriteReport() = %q; want the key and a summary", out.String())
+	}
+}
@@ -0,0 +1,147 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// DirSource loads injection configs from the files of a directory, every file being named like a ConfigMap
+// key. Hidden files are skipped, so a mounted ConfigMap volume works as is: files are read through
+// their symlinks.
+type DirSource struct {
+	Dir string
+	// PollInterval is how often the directory is checked for changes, defaults to 5 seconds
+	PollInterval time.Duration
+	// Decoding is config.DecodingStrict or config.DecodingLenient, defaults to strict
+	Decoding string
+}
+
+// List loads the injection configs of the directory as a single source. A not found error is returned when
+// the directory does not exist or holds no file.
+func (d *DirSource) List(ctx context.Context) ([]config.SourceConfigs, error) {
+	loaded, data, err := d.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(loaded.Source.LoadErrors) == len(data) {
+		return nil, fmt.Errorf("none of the files of %s could be processed", d.Dir)
+	}
+	tracing.Logger(ctx).Debug().Msgf("Loaded %d injection configs from %s", len(loaded.InjConfigs), d.Dir)
+	return []config.SourceConfigs{loaded}, nil
+}
+
+// Load loads the injection configs of the directory as a single source, and returns the files it read by
+// name. Files that cannot be loaded are reported in the source, an error is only returned when the directory
+// cannot be read.
+func (d *DirSource) Load(ctx context.Context) (config.SourceConfigs, map[string]string, error) {
+	data, err := d.read()
+	if err != nil {
+		return config.SourceConfigs{}, nil, err
+	}
+	source := &config.ConfigSource{Kind: "Directory", Name: d.Dir, ResourceVersion: fingerprint(data)}
+	injs, keys := loadData(ctx, source, data, d.Decoding)
+	return config.SourceConfigs{Source: *source, InjConfigs: injs, Keys: keys}, data, nil
+}
+
+// Watch notifies once right away and then every time the files of the directory change, until ctx is done
+func (d *DirSource) Watch(ctx context.Context, notify chan<- interface{}) error {
+	interval := d.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log.Info().Msgf("Watching for injection config files in %s every %s", d.Dir, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, first := "", true
+	for {
+		data, err := d.read()
+		current := fingerprint(data)
+		if err != nil && !apierrs.IsNotFound(err) {
+			log.Error().Msgf("cannot read %s: %v", d.Dir, err)
+		} else if first || current != last {
+			first, last = false, current
+			select {
+			case notify <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Info().Msg("stopping directory watcher, context indicated we are done")
+			return nil
+		}
+	}
+}
+
+// read returns the content of every file of the directory by name
+func (d *DirSource) read() (map[string]string, error) {
+	entries, err := ioutil.ReadDir(d.Dir)
+	if os.IsNotExist(err) {
+		return nil, apierrs.NewNotFound(schema.GroupResource{Resource: "directories"}, d.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if hidden(name) {
+			continue
+		}
+		path := filepath.Join(d.Dir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		payload, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data[name] = string(payload)
+	}
+	if len(data) == 0 {
+		return nil, apierrs.NewNotFound(schema.GroupResource{Resource: "files"}, d.Dir)
+	}
+	return data, nil
+}
+
+// hidden tells whether name is a hidden file rather than a key: keys start with a dot too, but always go on
+// with a field of the pod, while the kubelet's ..data entries, editor swap and lock files do not
+func hidden(name string) bool {
+	return strings.HasPrefix(name, ".") && !strings.HasPrefix(name, ".spec.") && !strings.HasPrefix(name, ".metadata.")
+}
+
+// fingerprint hashes the names and contents of data, so that any change gives another fingerprint
+func fingerprint(data map[string]string) string {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00%s", name, len(data[name]), data[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mountConfigMap lays out files like the kubelet does for a ConfigMap volume: the files live in a timestamped
+// directory, ..data links to it and every key links through ..data
+func mountConfigMap(t *testing.T, dir, version string, data map[string]string) {
+	versioned := filepath.Join(dir, "..2021_07_23_"+version)
+	if err := os.Mkdir(versioned, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, payload := range data {
+		writeFile(t, filepath.Join(versioned, key), payload)
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(versioned), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	for key := range data {
+		link := filepath.Join(dir, key)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join("..data", key), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirSource_ListConfigMapVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-injector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountConfigMap(t, dir, "1", map[string]string{
+		".spec.hostPID":     "hostPID: true",
+		".spec.hostNetwork": "hostNetwork: [",
+	})
+
+	d := &DirSource{Dir: dir}
+	sources, err := d.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || len(sources[0].InjConfigs) != 1 || sources[0].InjConfigs["/spec/hostPID"] == nil {
+		t.Fatalf("List() got = %+v; want only /spec/hostPID", sources)
+	}
+	if source := sources[0].Source; source.Kind != "Directory" || source.LoadErrors[".spec.hostNetwork"] == "" {
+		t.Errorf("List() source got = %+v", source)
+	}
+}
+
+func TestDirSource_ListSkipsHiddenFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-injector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, ".spec.hostPID"), "hostPID: true")
+	for _, name := range []string{"..spec.hostPID.swp", ".#.spec.hostPID", ".DS_Store"} {
+		writeFile(t, filepath.Join(dir, name), "garbage: [")
+	}
+
+	sources, err := (&DirSource{Dir: dir}).List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := sources[0]; len(source.InjConfigs) != 1 || len(source.Source.LoadErrors) != 0 {
+		t.Errorf("List() got = %+v; want only /spec/hostPID, the hidden files skipped", source)
+	}
+}
+
+func TestDirSource_ListDecoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-injector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, ".spec.hostPID"), "hostPID: true\nhostNetwrok: true\n")
+
+	strict, err := (&DirSource{Dir: dir}).List(context.Background())
+	if err == nil {
+		t.Fatalf("strict List() got = %+v; want the misspelt field rejected", strict)
+	}
+
+	lenient, err := (&DirSource{Dir: dir, Decoding: config.DecodingLenient}).List(context.Background())
+	if err != nil {
+		t.Fatalf("lenient List() failed: %v", err)
+	}
+	want := `line 2, column 1: unknown field "hostNetwrok"`
+	if got := lenient[0].Source.LoadWarnings[".spec.hostPID"]; got != want || lenient[0].InjConfigs["/spec/hostPID"] == nil {
+		t.Errorf("lenient List() warning got = %q; want the config loaded with warning %q", got, want)
+	}
+}
+
+func TestDirSource_ListNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-injector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range []*DirSource{{Dir: dir}, {Dir: filepath.Join(dir, "missing")}} {
+		if _, err := d.List(context.Background()); !apierrs.IsNotFound(err) {
+			t.Errorf("List() of %s err got = %v; want not found", d.Dir, err)
+		}
+	}
+}
+
+func TestDirSource_WatchNotifiesOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-injector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountConfigMap(t, dir, "1", map[string]string{".spec.hostPID": "hostPID: true"})
+
+	d := &DirSource{Dir: dir, PollInterval: 20 * time.Millisecond}
+	notify := make(chan interface{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Watch(ctx, notify)
+
+	expect := func(what string) {
+		select {
+		case <-notify:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification %s", what)
+		}
+	}
+	expect("on start")
+
+	time.Sleep(100 * time.Millisecond)
+	if len(notify) != 0 {
+		t.Errorf("got notified while nothing changed")
+	}
+
+	// Swap ..data like the kubelet does on a ConfigMap update
+	mountConfigMap(t, dir, "2", map[string]string{".spec.hostPID": "hostPID: false"})
+	expect("after the ConfigMap volume changed")
+
+	sources, err := d.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sources[0].InjConfigs["/spec/hostPID"].HostPID {
+		t.Errorf("List() did not read the new file content")
+	}
+}
@@ -0,0 +1,147 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/metrics"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ConfigLoader loads and merges the injection configs of every source, like MergeListers
+type ConfigLoader func(ctx context.Context) (*config.MergedConfigs, error)
+
+// SourceLister lists the injection configs of one kind of source, like K8sWatcher.ListConfigMaps
+type SourceLister func(ctx context.Context) ([]config.SourceConfigs, error)
+
+// MergeListers merges the sources of every lister, in the given order for sources of equal priority. A lister
+// returning not found contributes no source, not found is only returned when no lister found any source.
+func MergeListers(listers ...SourceLister) ConfigLoader {
+	return func(ctx context.Context) (*config.MergedConfigs, error) {
+		var sources []config.SourceConfigs
+		var notFound error
+		for _, list := range listers {
+			listed, err := list(ctx)
+			if apierrs.IsNotFound(err) {
+				notFound = err
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, listed...)
+		}
+		if len(sources) == 0 && notFound != nil {
+			return nil, notFound
+		}
+		return config.MergeSources(sources), nil
+	}
+}
+
+// Reloader publishes freshly loaded injection configs to a SnapshotStore. A failed reload keeps the
+// previous Snapshot and is retried with backoff until it succeeds or a newer reload supersedes it.
+type Reloader struct {
+	Load      ConfigLoader
+	Snapshots *config.SnapshotStore
+	// DeletePolicy is config.ConfigMapDeleteKeep or config.ConfigMapDeleteDisable, defaults to keep
+	DeletePolicy string
+	// Backoff paces the retries of failed reloads, defaults to 1s doubling up to 2 minutes
+	Backoff wait.Backoff
+	// Loaded, when set, is called with the configs of every successful reload, like K8sWatcher.ConfigsLoaded
+	Loaded func(merged *config.MergedConfigs)
+
+	mu      sync.Mutex
+	status  config.ReloadStatus
+	backoff wait.Backoff
+	retry   *time.Timer
+}
+
+var defaultReloadBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: 2 * time.Minute}
+
+// Reload loads the injection configs and publishes them. On failure the previous Snapshot is kept and a
+// retry is scheduled.
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.retry != nil {
+		r.retry.Stop()
+		r.retry = nil
+	}
+
+	logger := tracing.Logger(ctx)
+	merged, err := r.Load(ctx)
+	switch {
+	case err == nil:
+		snapshot := r.Snapshots.SetConfigs(merged)
+		logger.Info().Msgf("Loaded injection configs of %d paths from %d sources (generation %d)", len(merged.Configs), len(merged.Sources), snapshot.Generation)
+		metrics.ConfigReloads.WithLabelValues("success").Inc()
+		if r.Loaded != nil {
+			r.Loaded(merged)
+		}
+		r.succeeded()
+		return nil
+
+	case apierrs.IsNotFound(err):
+		metrics.ConfigReloads.WithLabelValues("deleted").Inc()
+		if r.DeletePolicy == config.ConfigMapDeleteDisable {
+			snapshot := r.Snapshots.SetInjConfigs(map[string]*config.InjectionConfig{})
+			logger.Warn().Msgf("Injection config source is gone, injection is disabled (generation %d)", snapshot.Generation)
+			r.succeeded()
+			return nil
+		}
+		logger.Warn().Msgf("Injection config source is gone, keeping the last loaded configs (generation %d)", r.Snapshots.Load().Generation)
+		r.status.LastError = err.Error()
+		return err
+
+	default:
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		if r.status.ConsecutiveFailures == 0 {
+			r.status.FailingSince = time.Now()
+			r.backoff = r.Backoff
+			if r.backoff.Steps == 0 {
+				r.backoff = defaultReloadBackoff
+			}
+		}
+		r.status.ConsecutiveFailures++
+		r.status.LastError = err.Error()
+
+		delay := r.backoff.Step()
+		logger.Error().Msgf("Reloading injection configs failed %d times, keeping generation %d and retrying in %s: %v",
+			r.status.ConsecutiveFailures, r.Snapshots.Load().Generation, delay, err)
+		r.retry = time.AfterFunc(delay, func() {
+			_ = r.Reload(context.Background())
+		})
+		return err
+	}
+}
+
+// succeeded records a successful reload, r.mu must be held
+func (r *Reloader) succeeded() {
+	now := time.Now()
+	metrics.ConfigLastReloadSuccess.Set(float64(now.Unix()))
+	r.status = config.ReloadStatus{LastSuccess: now}
+}
+
+// Stop cancels a pending retry
+func (r *Reloader) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.retry != nil {
+		r.retry.Stop()
+		r.retry = nil
+	}
+}
+
+// ReloadStatus returns the outcome of the latest reloads
+func (r *Reloader) ReloadStatus() config.ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dungdev1/k8s-injector/pkg/config"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// scriptedLoader returns its results in order, repeating the last one
+type scriptedLoader struct {
+	mu      sync.Mutex
+	results []error
+	calls   int
+}
+
+func (l *scriptedLoader) Load(ctx context.Context) (*config.MergedConfigs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.results[len(l.results)-1]
+	if l.calls < len(l.results) {
+		err = l.results[l.calls]
+	}
+	l.calls++
+	if err != nil {
+		return nil, err
+	}
+	return config.MergeSources([]config.SourceConfigs{{
+		Source:     config.ConfigSource{Kind: "ConfigMap", Name: "k8s-injector"},
+		InjConfigs: map[string]*config.InjectionConfig{"/spec/hostNetwork": {}},
+	}}), nil
+}
+
+func (l *scriptedLoader) Calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.calls
+}
+
+var errNotFound = apierrs.NewNotFound(schema.GroupResource{Resource: "configmaps"}, "k8s-injector")
+
+func TestReloader_KeepsLastGoodConfigAndRetries(t *testing.T) {
+	loader := &scriptedLoader{results: []error{nil, errors.New("bad apply"), errors.New("bad apply"), nil}}
+	store := config.NewSnapshotStore()
+	r := &Reloader{
+		Load:      loader.Load,
+		Snapshots: store,
+		Backoff:   wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1, Steps: 10},
+	}
+	defer r.Stop()
+
+	if err := r.Reload(context.Background()); err != nil {
+		t.Fatalf("first reload failed: %v", err)
+	}
+	good := store.Load()
+
+	if err := r.Reload(context.Background()); err == nil {
+		t.Fatalf("second reload should have failed")
+	}
+	if store.Load() != good {
+		t.Errorf("a failed reload replaced the published snapshot")
+	}
+	status := r.ReloadStatus()
+	if status.ConsecutiveFailures != 1 || status.LastError != "bad apply" || status.FailingSince.IsZero() || !status.Ready() {
+		t.Errorf("unexpected status after a failure: %+v", status)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.ReloadStatus().ConsecutiveFailures != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("reload was not retried until it succeeded, status: %+v", r.ReloadStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if loader.Calls() != 4 {
+		t.Errorf("loader calls got = %d; want = 4", loader.Calls())
+	}
+	if store.Load().Generation != good.Generation+1 {
+		t.Errorf("generation got = %d; want = %d", store.Load().Generation, good.Generation+1)
+	}
+}
+
+func TestReloader_NotReadyUntilFirstSuccess(t *testing.T) {
+	loader := &scriptedLoader{results: []error{errors.New("bad apply")}}
+	r := &Reloader{
+		Load:      loader.Load,
+		Snapshots: config.NewSnapshotStore(),
+		Backoff:   wait.Backoff{Duration: time.Hour, Steps: 1},
+	}
+	defer r.Stop()
+
+	_ = r.Reload(context.Background())
+	if r.ReloadStatus().Ready() {
+		t.Errorf("Ready() = true before any config was loaded")
+	}
+}
+
+func TestReloader_DeletePolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantConfigs int
+	}{
+		{policy: config.ConfigMapDeleteKeep, wantConfigs: 1},
+		{policy: config.ConfigMapDeleteDisable, wantConfigs: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			loader := &scriptedLoader{results: []error{nil, errNotFound}}
+			store := config.NewSnapshotStore()
+			r := &Reloader{Load: loader.Load, Snapshots: store, DeletePolicy: tt.policy}
+			defer r.Stop()
+
+			_ = r.Reload(context.Background())
+			_ = r.Reload(context.Background())
+			if got := len(store.Load().Configs); got != tt.wantConfigs {
+				t.Errorf("injection configs got = %d; want = %d", got, tt.wantConfigs)
+			}
+			if r.ReloadStatus().ConsecutiveFailures != 0 {
+				t.Errorf("a deleted ConfigMap should not be retried: %+v", r.ReloadStatus())
+			}
+		})
+	}
+}
@@ -2,37 +2,79 @@ package watcher
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dungdev1/k8s-injector/pkg/config"
+	"github.com/dungdev1/k8s-injector/pkg/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	k8sv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type K8sWatcher struct {
 	Namespace string
 	CfmName   string
-	client    k8sv1.CoreV1Interface
+	// ResyncPeriod is how often the informers replay their whole cache, defaults to 10 minutes
+	ResyncPeriod time.Duration
+	// AllNamespaces merges the labelled ConfigMaps of every namespace instead of only those of Namespace
+	AllNamespaces bool
+	// Decoding is config.DecodingStrict or config.DecodingLenient, defaults to strict
+	Decoding string
+	// Events, when set, is told about every ConfigMap of the configs passed to ConfigsLoaded
+	Events     ConfigMapRecorder
+	client     kubernetes.Interface
+	restConfig *rest.Config
+
+	mu         sync.Mutex
+	lastEvents map[string]time.Time
+	cfmLister  corelisters.ConfigMapLister
+	// listed are the ConfigMaps of the last ListConfigMaps by source ID
+	listed map[string]*v1.ConfigMap
+}
+
+// ConfigMapRecorder is told about every ConfigMap injection configs were loaded from, with the keys that could
+// not be loaded
+type ConfigMapRecorder interface {
+	ConfigMapLoaded(cfm *v1.ConfigMap, loadErrors map[string]string)
 }
 
+const defaultResyncPeriod = 10 * time.Minute
+
+const (
+	// ConfigMapLabel selects every ConfigMap injection configs are loaded from
+	ConfigMapLabel = "app=k8s-injector"
+	// PriorityAnnotation orders ConfigMaps defining the same key, the highest priority wins
+	PriorityAnnotation = "k8s-injector/priority"
+)
+
+const (
+	NamespaceWatcher = "namespace"
+	ConfigMapWatcher = "configmap"
+)
+
 type NamespaceEvent struct {
 	Namespace string
 	Type      watch.EventType
 }
 
-var ErrWatcheChannelClosed = errors.New("watcher channel has close")
-
 func NewK8sWatcher(ns string, cfmName string, masterURL string, kubeconfig string) (*K8sWatcher, error) {
 	w := K8sWatcher{
 		CfmName:   cfmName,
@@ -70,125 +112,353 @@ func NewK8sWatcher(ns string, cfmName string, masterURL string, kubeconfig strin
 	if err != nil {
 		return nil, err
 	}
-	w.client = clientset.CoreV1()
+	w.client = clientset
+	w.restConfig = k8sConfig
 	log.Info().Msgf("Created watcher: apiserver=%s, namespace=%s", k8sConfig.Host, w.Namespace)
 	return &w, nil
 }
 
+// Client returns the Kubernetes client of the watcher
+func (w *K8sWatcher) Client() kubernetes.Interface {
+	return w.client
+}
+
+// DynamicClient creates a dynamic client talking to the same API server as the watcher
+func (w *K8sWatcher) DynamicClient() (dynamic.Interface, error) {
+	return dynamic.NewForConfig(w.restConfig)
+}
+
+// WatchNamespace sends an Added event for every namespace carrying the webhook enable label, and a Deleted
+// event once a namespace loses the label or is removed. It runs a shared informer, which relists and rewatches
+// with backoff on its own, until ctx is done. Namespaces are sent again as Added on every resync.
 func (w *K8sWatcher) WatchNamespace(ctx context.Context, webhookEnabledLabel map[string]string, ch chan<- NamespaceEvent) error {
 	log.Info().Msg("Watching for all namespace in cluster...")
 
 	labelSelector := metav1.LabelSelector{MatchLabels: webhookEnabledLabel}
-	watcher, err := w.client.Namespaces().Watch(ctx, metav1.ListOptions{
-		LabelSelector: labels.Set(labelSelector.MatchLabels).String(),
+	factory := informers.NewSharedInformerFactoryWithOptions(w.client, w.resyncPeriod(),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set(labelSelector.MatchLabels).String()
+		}),
+	)
+	informer := factory.Core().V1().Namespaces().Informer()
+
+	send := func(obj interface{}, eventType watch.EventType) {
+		w.markEvent(NamespaceWatcher)
+		namespace, ok := objectOf(obj).(*v1.Namespace)
+		if !ok {
+			log.Error().Msg("cannot parse the event")
+			return
+		}
+		if eventType == watch.Deleted {
+			log.Debug().Msgf("Deleted label or removed namespace %s", namespace.Name)
+		} else {
+			log.Debug().Msgf("Added a Namespace: %s", namespace.Name)
+		}
+		select {
+		case ch <- NamespaceEvent{Namespace: namespace.Name, Type: eventType}:
+		case <-ctx.Done():
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { send(obj, watch.Added) },
+		UpdateFunc: func(_, obj interface{}) { send(obj, watch.Added) },
+		DeleteFunc: func(obj interface{}) { send(obj, watch.Deleted) },
 	})
+
+	return w.runInformers(ctx, factory, "namespace", informer.HasSynced)
+}
+
+// ListNamespaces returns the namespaces carrying the webhook enable label, for replacing the enabled namespaces
+// at once when the label changes
+func (w *K8sWatcher) ListNamespaces(ctx context.Context, webhookEnabledLabel map[string]string) ([]string, error) {
+	list, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: labels.Set(webhookEnabledLabel).String()})
 	if err != nil {
-		return fmt.Errorf("failed to start Namespace watcher: %s", err.Error())
+		return nil, fmt.Errorf("cannot list namespaces with error: %w", err)
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	return namespaces, nil
+}
+
+// WatchConfigMap notifies every time a ConfigMap labelled app=k8s-injector is added, modified or deleted,
+// including on every resync. The ConfigMap informer also serves ListConfigMaps from its local cache once
+// synced.
+func (w *K8sWatcher) WatchConfigMap(ctx context.Context, notify chan<- interface{}) error {
+	namespace := w.Namespace
+	if w.AllNamespaces {
+		namespace = metav1.NamespaceAll
+		log.Info().Msgf("Watching for configmaps labelled %s in all namespaces", ConfigMapLabel)
+	} else {
+		log.Info().Msgf("Watching for configmaps labelled %s on namespace=%s", ConfigMapLabel, w.Namespace)
 	}
+	factory := informers.NewSharedInformerFactoryWithOptions(w.client, w.resyncPeriod(),
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = ConfigMapLabel
+		}),
+	)
+	configMaps := factory.Core().V1().ConfigMaps()
 
-	for {
+	handle := func(obj interface{}) {
+		w.markEvent(ConfigMapWatcher)
+		configmap, ok := objectOf(obj).(*v1.ConfigMap)
+		if !ok {
+			log.Error().Msg("cannot parse the event")
+			return
+		}
+		log.Debug().Msgf("Got event for configmap %s/%s", configmap.Namespace, configmap.Name)
 		select {
-		case e, ok := <-watcher.ResultChan():
-			if !ok {
-				return ErrWatcheChannelClosed
-			}
-			if e.Type == watch.Error {
-				return apierrs.FromObject(e.Object)
-			}
-			namespace, ok := e.Object.(*v1.Namespace)
-			if !ok {
-				log.Error().Msg("cannot parse the event")
-				break
-			}
-			switch e.Type {
-			case watch.Added:
-				log.Debug().Msgf("Added a Namespace: %s", namespace.Name)
-				ch <- NamespaceEvent{
-					Namespace: namespace.Name,
-					Type:      watch.Added,
-				}
-			case watch.Deleted:
-				log.Debug().Msgf("Deleted label or removed namespace %s", namespace.Name)
-				ch <- NamespaceEvent{
-					Namespace: namespace.Name,
-					Type:      watch.Deleted,
-				}
-			}
+		case notify <- struct{}{}:
 		case <-ctx.Done():
-			log.Info().Msg("stopping namespace watcher, context indicated we are done")
+		}
+	}
+	configMaps.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+		DeleteFunc: handle,
+	})
+
+	lister := configMaps.Lister()
+	return w.runInformers(ctx, factory, "configmap", func() bool {
+		if !configMaps.Informer().HasSynced() {
+			return false
+		}
+		w.mu.Lock()
+		w.cfmLister = lister
+		w.mu.Unlock()
+		return true
+	})
+}
+
+// runInformers starts the informers of factory and blocks until ctx is done. It only returns an error when
+// the caches could not be synced, in which case the caller is expected to retry with backoff.
+func (w *K8sWatcher) runInformers(ctx context.Context, factory informers.SharedInformerFactory, name string, synced cache.InformerSynced) error {
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), synced) {
+		if ctx.Err() != nil {
+			log.Info().Msgf("stopping %s watcher, context indicated we are done", name)
 			return nil
 		}
+		return fmt.Errorf("failed to sync %s informer cache", name)
 	}
+	log.Info().Msgf("%s informer cache synced", name)
+
+	<-ctx.Done()
+	log.Info().Msgf("stopping %s watcher, context indicated we are done", name)
+	return nil
 }
 
-func (w *K8sWatcher) WatchConfigMap(ctx context.Context, notify chan<- interface{}) error {
-	log.Info().Msgf("Watching for configmap=%s on namespace=%s", w.CfmName, w.Namespace)
-	labelSelector := metav1.LabelSelector{MatchLabels: map[string]string{"app": "k8s-injector"}}
-	watcher, err := w.client.ConfigMaps(w.Namespace).Watch(ctx, metav1.ListOptions{
-		LabelSelector: labels.Set(labelSelector.MatchLabels).String(),
-	})
+func (w *K8sWatcher) resyncPeriod() time.Duration {
+	if w.ResyncPeriod <= 0 {
+		return defaultResyncPeriod
+	}
+	return w.ResyncPeriod
+}
+
+// objectOf unwraps the final state of objects whose deletion was only noticed on relist
+func objectOf(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+// ListConfigMaps loads the injection configs of every ConfigMap labelled app=k8s-injector. When several
+// ConfigMaps define the same key, the one with the highest k8s-injector/priority annotation wins, then the
+// ConfigMap named CfmName in Namespace, then the first one by namespace and name. ConfigMaps of other
+// namespaces only apply to the pods of their namespace, and their annotation is ignored: they have the lowest
+// priority. A not found error is returned when there is no such ConfigMap.
+func (w *K8sWatcher) ListConfigMaps(ctx context.Context) (sources []config.SourceConfigs, err error) {
+	ctx, span := tracing.Start(ctx, "ReloadConfigMaps", trace.WithAttributes(
+		attribute.String("injector.configmap.namespace", w.Namespace),
+		attribute.Bool("injector.configmap.all_namespaces", w.AllNamespaces),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("injector.configmap.sources", len(sources)))
+		tracing.End(span, err)
+	}()
+
+	namespace := w.Namespace
+	if w.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	selector, err := labels.Parse(ConfigMapLabel)
 	if err != nil {
-		return fmt.Errorf("failed to start ConfigMap watcher: %s", err.Error())
+		return nil, err
 	}
-	for {
-		select {
-		case e, ok := <-watcher.ResultChan():
-			if !ok {
-				return ErrWatcheChannelClosed
-			}
-			if e.Type == watch.Error {
-				return apierrs.FromObject(e.Object)
-			}
-			configmap, ok := e.Object.(*v1.ConfigMap)
-			if !ok {
-				log.Error().Msg("cannot parse the event")
-				break
-			}
-			if configmap.Name != w.CfmName {
-				break
-			}
 
-			switch e.Type {
-			case watch.Added:
-				fallthrough
-			case watch.Modified:
-				fallthrough
-			case watch.Deleted:
-				notify <- struct{}{}
-			default:
-				log.Error().Msgf("go unsupported event %s for %s! skipping", e.Type, e.Object.GetObjectKind())
+	var cfms []*v1.ConfigMap
+	w.mu.Lock()
+	lister := w.cfmLister
+	w.mu.Unlock()
+	if lister != nil {
+		tracing.Logger(ctx).Debug().Msg("Listing Configmaps from the informer cache...")
+		cfms, err = lister.ConfigMaps(namespace).List(selector)
+	} else {
+		tracing.Logger(ctx).Debug().Msg("Listing Configmaps...")
+		var list *v1.ConfigMapList
+		list, err = w.client.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: ConfigMapLabel})
+		if list != nil {
+			for i := range list.Items {
+				cfms = append(cfms, &list.Items[i])
 			}
-		case <-ctx.Done():
-			log.Info().Msg("stopping configmap watcher, context indicated we are done")
-			return nil
 		}
 	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot list config maps with error: %w", err)
+	}
+	if len(cfms) == 0 {
+		return nil, apierrs.NewNotFound(v1.Resource("configmaps"), ConfigMapLabel)
+	}
+
+	w.sortByName(cfms)
+	keys, failed := 0, 0
+	listed := make(map[string]*v1.ConfigMap, len(cfms))
+	for _, cfm := range cfms {
+		loaded := LoadConfigMap(ctx, cfm, w.Decoding)
+		keys += len(cfm.Data)
+		failed += len(loaded.Source.LoadErrors)
+		listed[loaded.Source.ID()] = cfm
+		if cfm.Namespace != w.Namespace {
+			// A tenant's ConfigMap only applies to its own namespace and cannot outrank the injector's
+			loaded.Source.Tenant = true
+			loaded.Priority = config.LowestPriority
+			loaded.Selector = &config.ConfigSelector{Namespaces: []string{cfm.Namespace}}
+		}
+		sources = append(sources, loaded)
+	}
+	w.mu.Lock()
+	w.listed = listed
+	w.mu.Unlock()
+	if keys > 0 && failed == keys {
+		return nil, fmt.Errorf("none of the configmap keys could be processed")
+	}
+	return sources, nil
 }
 
-func (w *K8sWatcher) GetConfigMap(ctx context.Context) (map[string]*config.InjectionConfig, error) {
-	log.Debug().Msg("Fetching Configmaps...")
-	cfm, err := w.client.ConfigMaps(w.Namespace).Get(ctx, w.CfmName, metav1.GetOptions{})
+// ConfigsLoaded tells Events about every ConfigMap listed by ListConfigMaps that is a source of merged, with
+// the keys that could not be loaded or merged
+func (w *K8sWatcher) ConfigsLoaded(merged *config.MergedConfigs) {
+	if w.Events == nil {
+		return
+	}
+	w.mu.Lock()
+	listed := w.listed
+	w.mu.Unlock()
+	for _, source := range merged.Sources {
+		if cfm, ok := listed[source.ID()]; ok {
+			w.Events.ConfigMapLoaded(cfm, source.LoadErrors)
+		}
+	}
+}
+
+// sortByName orders cfms of the same priority: the ConfigMap named CfmName in Namespace first, then by
+// namespace and name
+func (w *K8sWatcher) sortByName(cfms []*v1.ConfigMap) {
+	own := func(cfm *v1.ConfigMap) bool {
+		return cfm.Namespace == w.Namespace && cfm.Name == w.CfmName
+	}
+	sort.SliceStable(cfms, func(i, j int) bool {
+		if oi, oj := own(cfms[i]), own(cfms[j]); oi != oj {
+			return oi
+		}
+		if cfms[i].Namespace != cfms[j].Namespace {
+			return cfms[i].Namespace < cfms[j].Namespace
+		}
+		return cfms[i].Name < cfms[j].Name
+	})
+}
+
+func priorityOf(cfm *v1.ConfigMap) int {
+	p, err := strconv.Atoi(cfm.Annotations[PriorityAnnotation])
 	if err != nil {
-		return nil, fmt.Errorf("cannot get config map with error: %s", err.Error())
+		return 0
+	}
+	return p
+}
+
+// LoadConfigMap loads the injection configs of cfm as a source with the priority of its annotation, the way
+// they are loaded from the cluster. Keys that cannot be loaded are reported in the source.
+func LoadConfigMap(ctx context.Context, cfm *v1.ConfigMap, decoding string) config.SourceConfigs {
+	source := &config.ConfigSource{
+		Kind:            "ConfigMap",
+		Namespace:       cfm.Namespace,
+		Name:            cfm.Name,
+		ResourceVersion: cfm.ResourceVersion,
+	}
+	injs, keys := loadData(ctx, source, cfm.Data, decoding)
+	return config.SourceConfigs{Source: *source, Priority: priorityOf(cfm), InjConfigs: injs, Keys: keys}
+}
+
+// loadData loads injection configs keyed like ConfigMap data, see config.KeyToPath for how keys name patch
+// paths, and returns the key of each path. Keys that cannot be loaded or do not match their path are reported
+// in source, as are the warnings of the keys that were loaded.
+func loadData(ctx context.Context, source *config.ConfigSource, data map[string]string, decoding string) (map[string]*config.InjectionConfig, map[string]string) {
+	where := source.Name
+	if source.Namespace != "" {
+		where = source.Namespace + "/" + source.Name
 	}
 	injs := map[string]*config.InjectionConfig{}
-	failedConfigMapKeyLoad := 0
-	for cfmFile, payload := range cfm.Data {
-		inj, err := config.LoadInjectionConfig([]byte(payload))
+	keys := map[string]string{}
+	for key, payload := range data {
+		path, inj, warnings, err := loadKey(key, payload, decoding != config.DecodingLenient)
 		if err != nil {
-			log.Error().Msgf("cannot load injection config from ConfigMap: %s with error: %s", cfmFile, err.Error())
-			failedConfigMapKeyLoad++
+			tracing.Logger(ctx).Error().Msgf("cannot load injection config from %s %s: %s with error: %s", source.Kind, where, key, err.Error())
+			if source.LoadErrors == nil {
+				source.LoadErrors = map[string]string{}
+			}
+			source.LoadErrors[key] = err.Error()
 			continue
 		}
-		path := strings.ReplaceAll(cfmFile, ".", "/")
+		if len(warnings) != 0 {
+			tracing.Logger(ctx).Warn().Msgf("loaded injection config from %s %s: %s with warnings: %s", source.Kind, where, key, strings.Join(warnings, "; "))
+			if source.LoadWarnings == nil {
+				source.LoadWarnings = map[string]string{}
+			}
+			source.LoadWarnings[key] = strings.Join(warnings, "; ")
+		}
 		injs[path] = inj
+		keys[path] = key
 	}
-	if len(cfm.Data) > 0 && failedConfigMapKeyLoad == len(cfm.Data) {
-		return nil, fmt.Errorf("none of the configmap keys could be processed")
+	return injs, keys
+}
+
+// loadKey loads the injection config of a key and checks that it can be injected at the path of the key
+func loadKey(key string, payload string, strict bool) (string, *config.InjectionConfig, []string, error) {
+	path, err := config.KeyToPath(key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	inj, warnings, err := config.DecodeInjectionConfig([]byte(payload), strict)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := config.ValidatePath(path, inj); err != nil {
+		return "", nil, nil, err
 	}
-	return injs, nil
+	return path, inj, warnings, nil
 }
 
-// func checkKeyFormat(k string) bool {
+// LastEventTimes returns when each watcher last received an event, keyed by watcher name
+func (w *K8sWatcher) LastEventTimes() map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-// }
+	times := make(map[string]time.Time, len(w.lastEvents))
+	for name, t := range w.lastEvents {
+		times[name] = t
+	}
+	return times
+}
+
+func (w *K8sWatcher) markEvent(watcher string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lastEvents == nil {
+		w.lastEvents = map[string]time.Time{}
+	}
+	w.lastEvents[watcher] = time.Now()
+}
@@ -2,14 +2,19 @@ package watcher
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/dungdev1/k8s-injector/pkg/config"
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	fakeclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestWatcher_WatchNamespaceWithAddEvent(t *testing.T) {
@@ -18,7 +23,7 @@ func TestWatcher_WatchNamespaceWithAddEvent(t *testing.T) {
 	w := K8sWatcher{
 		Namespace: "kube-system",
 		CfmName:   "",
-		client:    client.CoreV1(),
+		client:    client,
 	}
 	labels := map[string]string{
 		"k8s-injection": "enabled",
@@ -30,7 +35,7 @@ func TestWatcher_WatchNamespaceWithAddEvent(t *testing.T) {
 
 	go func() {
 		time.Sleep(1 * time.Second)
-		w.client.Namespaces().Create(ctx, &v1.Namespace{
+		w.client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:   "dbservice",
 				Labels: labels,
@@ -69,7 +74,7 @@ func TestWatcher_WatchNamespaceWithDeleteEvent(t *testing.T) {
 	w := K8sWatcher{
 		Namespace: "kube-system",
 		CfmName:   "k8s-injector",
-		client:    client.CoreV1(),
+		client:    client,
 	}
 
 	ch := make(chan NamespaceEvent)
@@ -79,7 +84,7 @@ func TestWatcher_WatchNamespaceWithDeleteEvent(t *testing.T) {
 	go func() {
 		time.Sleep(1 * time.Second)
 		// Create namespace
-		w.client.Namespaces().Create(ctx, &v1.Namespace{
+		w.client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: namespace,
 				Labels: map[string]string{
@@ -90,7 +95,7 @@ func TestWatcher_WatchNamespaceWithDeleteEvent(t *testing.T) {
 		log.Info().Msgf("Namespace %s is created", namespace)
 
 		// Delete namespace
-		w.client.Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+		w.client.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
 		log.Info().Msgf("Namespace %s is deleted", namespace)
 	}()
 
@@ -116,23 +121,24 @@ func TestWatcher_WatchNamespaceWithDeleteEvent(t *testing.T) {
 	}
 }
 
-func TestWatcher_GetConfigMap(t *testing.T) {
+func TestWatcher_ListConfigMaps(t *testing.T) {
 	// Create configmap
 	client := fakeclient.NewSimpleClientset()
 
 	w := K8sWatcher{
 		Namespace: "kube-system",
 		CfmName:   "k8s-injector",
-		client:    client.CoreV1(),
+		client:    client,
 	}
 
 	ctx := context.Background()
-	w.client.ConfigMaps(w.Namespace).Create(ctx, &v1.ConfigMap{
+	w.client.CoreV1().ConfigMaps(w.Namespace).Create(ctx, &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: w.CfmName,
+			Name:   w.CfmName,
+			Labels: map[string]string{"app": "k8s-injector"},
 		},
 		Data: map[string]string{
-			"abdd--dds": `containers:
+			".spec.containers.-": `containers:
 - name: healthcheck
   image: 968914998835.dkr.ecr.ap-southeast-1.amazonaws.com/devops:healthcheck-server-13
   ports:
@@ -152,8 +158,300 @@ func TestWatcher_GetConfigMap(t *testing.T) {
 		},
 	}, metav1.CreateOptions{})
 
-	_, err := w.GetConfigMap(ctx)
+	sources, err := w.ListConfigMaps(ctx)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Source.Name != w.CfmName || len(sources[0].Source.LoadErrors) != 0 {
+		t.Errorf("ListConfigMaps() sources got = %+v", sources)
+	}
+}
+
+func TestWatcher_ListConfigMapsReportsLoadErrors(t *testing.T) {
+	client := fakeclient.NewSimpleClientset()
+
+	w := K8sWatcher{
+		Namespace: "kube-system",
+		CfmName:   "k8s-injector",
+		client:    client,
+	}
+
+	ctx := context.Background()
+	w.client.CoreV1().ConfigMaps(w.Namespace).Create(ctx, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            w.CfmName,
+			Labels:          map[string]string{"app": "k8s-injector"},
+			ResourceVersion: "42",
+		},
+		Data: map[string]string{
+			".spec.hostPID": "hostPID: true",
+			".spec.broken":  "containers: [",
+		},
+	}, metav1.CreateOptions{})
+
+	sources, err := w.ListConfigMaps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("ListConfigMaps() sources got = %+v", sources)
+	}
+	if injConfigs := sources[0].InjConfigs; len(injConfigs) != 1 || injConfigs["/spec/hostPID"] == nil {
+		t.Errorf("ListConfigMaps() configs got = %v", injConfigs)
+	}
+	if source := sources[0].Source; source.ResourceVersion != "42" || len(source.LoadErrors) != 1 || source.LoadErrors[".spec.broken"] == "" {
+		t.Errorf("ListConfigMaps() source got = %+v", source)
+	}
+	if _, ok := w.LastEventTimes()[ConfigMapWatcher]; ok {
+		t.Errorf("ListConfigMaps() should not count as a watcher event")
+	}
+}
+
+type loadedRecorder map[string]map[string]string
+
+func (r loadedRecorder) ConfigMapLoaded(cfm *v1.ConfigMap, loadErrors map[string]string) {
+	r[cfm.Namespace+"/"+cfm.Name] = loadErrors
+}
+
+func TestWatcher_ConfigsLoadedReportsMergeErrors(t *testing.T) {
+	client := fakeclient.NewSimpleClientset(
+		injectorConfigMap("kube-system", "k8s-injector", nil, map[string]string{
+			".spec.hostPID":     "hostPID: true",
+			".spec.hostNetwork": "extends: missing\nhostNetwork: true",
+		}),
+	)
+	recorder := loadedRecorder{}
+	w := K8sWatcher{Namespace: "kube-system", CfmName: "k8s-injector", client: client, Events: recorder}
+
+	merged, err := MergeListers(w.ListConfigMaps)(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder) != 0 {
+		t.Errorf("ConfigMaps were recorded before their configs were merged: %v", recorder)
+	}
+	w.ConfigsLoaded(merged)
+	loadErrors, ok := recorder["kube-system/k8s-injector"]
+	if !ok || len(loadErrors) != 1 || loadErrors[".spec.hostNetwork"] == "" {
+		t.Errorf("recorded load errors got = %v; want the merge error of .spec.hostNetwork", recorder)
+	}
+}
+
+// dropFirstWatch makes the first watch on resource a fake one controlled by the test, later watches are
+// served by the fake clientset as usual
+func dropFirstWatch(client *fakeclient.Clientset, resource string) (*watch.FakeWatcher, *int32) {
+	first := watch.NewFake()
+	watches := new(int32)
+	client.PrependWatchReactor(resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+		if atomic.AddInt32(watches, 1) == 1 {
+			return true, first, nil
+		}
+		return false, nil, nil
+	})
+	return first, watches
+}
+
+func waitForWatches(t *testing.T, watches *int32, n int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(watches) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("watch was not reopened, got %d watches; want %d", atomic.LoadInt32(watches), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcher_WatchNamespaceReconnects(t *testing.T) {
+	client := fakeclient.NewSimpleClientset()
+	first, watches := dropFirstWatch(client, "namespaces")
+	labels := map[string]string{"k8s-injection": "enabled"}
+
+	w := K8sWatcher{client: client}
+	ch := make(chan NamespaceEvent, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := w.WatchNamespace(ctx, labels, ch); err != nil {
+			t.Errorf("Watch Namespace err: %v", err)
+		}
+	}()
+
+	expect := func(namespace string) {
+		select {
+		case event := <-ch:
+			if event.Namespace != namespace || event.Type != watch.Added {
+				t.Errorf("got event %+v; want %q added", event, namespace)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event received for namespace %q", namespace)
+		}
+	}
+
+	first.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dbservice", Labels: labels, ResourceVersion: "1"}})
+	expect("dbservice")
+
+	// Drop the connection, the informer has to watch again on its own
+	first.Stop()
+	waitForWatches(t, watches, 2)
+
+	client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "payment", Labels: labels},
+	}, metav1.CreateOptions{})
+	expect("payment")
+
+	if _, ok := w.LastEventTimes()[NamespaceWatcher]; !ok {
+		t.Errorf("namespace watcher last event time was not recorded")
+	}
+}
+
+func TestWatcher_WatchConfigMapReconnectsAndCaches(t *testing.T) {
+	client := fakeclient.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: "kube-system", Labels: map[string]string{"app": "k8s-injector"}},
+		Data:       map[string]string{".spec.hostPID": "hostPID: true"},
+	})
+	first, watches := dropFirstWatch(client, "configmaps")
+	var lists int32
+	client.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&lists, 1)
+		return false, nil, nil
+	})
+
+	w := K8sWatcher{Namespace: "kube-system", CfmName: "k8s-injector", client: client}
+	notify := make(chan interface{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := w.WatchConfigMap(ctx, notify); err != nil {
+			t.Errorf("Watch ConfigMap err: %v", err)
+		}
+	}()
+
+	expectNotify := func() {
+		select {
+		case <-notify:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no configmap notification received")
+		}
+	}
+
+	// Initial list
+	expectNotify()
+	first.Stop()
+	waitForWatches(t, watches, 2)
+	// The informer lists again before watching again, the loads below must not
+	informerLists := atomic.LoadInt32(&lists)
+
+	client.CoreV1().ConfigMaps("kube-system").Update(ctx, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-injector", Namespace: "kube-system", Labels: map[string]string{"app": "k8s-injector"}},
+		Data:       map[string]string{".spec.hostNetwork": "hostNetwork: true"},
+	}, metav1.UpdateOptions{})
+
+	// A relist may notify before the update is seen, keep reloading on every notification like main does
+	for {
+		expectNotify()
+		sources, err := w.ListConfigMaps(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sources) == 1 && sources[0].InjConfigs["/spec/hostNetwork"] != nil {
+			break
+		}
+	}
+	if n := atomic.LoadInt32(&lists) - informerLists; n != 0 {
+		t.Errorf("ListConfigMaps() issued %d LIST requests; want it served from the informer cache", n)
+	}
+}
+
+func injectorConfigMap(namespace, name string, annotations map[string]string, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{"app": "k8s-injector"},
+			Annotations: annotations,
+		},
+		Data: data,
+	}
+}
+
+func TestWatcher_ListConfigMapsMergesWithPrecedence(t *testing.T) {
+	client := fakeclient.NewSimpleClientset(
+		injectorConfigMap("kube-system", "k8s-injector", nil, map[string]string{
+			".spec.hostPID":     "hostPID: true",
+			".spec.hostNetwork": "hostNetwork: true",
+		}),
+		injectorConfigMap("kube-system", "a-team", nil, map[string]string{
+			".spec.hostPID":            "hostPID: false",
+			".spec.containers.0.ports": "ports: [{containerPort: 80}]",
+		}),
+		injectorConfigMap("payment", "payment", map[string]string{PriorityAnnotation: "10"}, map[string]string{
+			".spec.hostNetwork": "hostNetwork: false",
+		}),
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled", Namespace: "kube-system"}, Data: map[string]string{
+			".spec.shareProcessNamespace": "shareProcessNamespace: true",
+		}},
+	)
+	w := K8sWatcher{Namespace: "kube-system", CfmName: "k8s-injector", AllNamespaces: true, client: client}
+
+	merged, err := MergeListers(w.ListConfigMaps)(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, sources := merged.Configs, merged.Sources
+	if len(configs) != 3 {
+		t.Errorf("merged configs got = %v; want 3 paths", configs)
+	}
+	if cfgs := configs["/spec/hostNetwork"]; len(cfgs) != 1 || !*cfgs[0].Config.HostNetwork {
+		t.Errorf("the priority annotation of another namespace should be ignored for /spec/hostNetwork")
+	}
+	if cfgs := configs["/spec/hostPID"]; len(cfgs) != 1 || !*cfgs[0].Config.HostPID {
+		t.Errorf("the injector ConfigMap should win ties for /spec/hostPID")
+	}
+	want := []string{"kube-system/k8s-injector", "kube-system/a-team", "payment/payment"}
+	if len(sources) != len(want) {
+		t.Fatalf("merged sources got = %+v", sources)
+	}
+	for i, source := range sources {
+		if got := source.Namespace + "/" + source.Name; got != want[i] {
+			t.Errorf("sources[%d] got = %s; want = %s", i, got, want[i])
+		}
+	}
+	if sources[2].Overridden["/spec/hostNetwork"] != "ConfigMap/kube-system/k8s-injector" || sources[1].Overridden["/spec/hostPID"] != "ConfigMap/kube-system/k8s-injector" {
+		t.Errorf("conflicts were not reported: %+v", sources)
+	}
+
+	w.AllNamespaces = false
+	merged, err = MergeListers(w.ListConfigMaps)(context.Background())
+	if err != nil || len(merged.Sources) != 2 {
+		t.Errorf("the ConfigMaps of kube-system only should be merged the ConfigMaps of kube-system, got %+v, %v", merged, err)
+	}
+}
+
+func TestWatcher_ListConfigMapsScopesOtherNamespaces(t *testing.T) {
+	client := fakeclient.NewSimpleClientset(
+		injectorConfigMap("kube-system", "k8s-injector", map[string]string{PriorityAnnotation: "-5"}, map[string]string{".spec.hostPID": "hostPID: true"}),
+		injectorConfigMap("payment", "payment", map[string]string{PriorityAnnotation: "100"}, map[string]string{".spec.hostPID": "hostPID: false"}),
+	)
+	w := K8sWatcher{Namespace: "kube-system", CfmName: "k8s-injector", AllNamespaces: true, client: client}
+
+	sources, err := w.ListConfigMaps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, tenant := sources[0], sources[1]
+	if own.Priority != -5 || own.Selector != nil || own.Source.Tenant {
+		t.Errorf("the injector namespace ConfigMap got priority %d and selector %+v; want its annotation and every namespace", own.Priority, own.Selector)
+	}
+	if tenant.Priority != config.LowestPriority || !tenant.Source.Tenant || !tenant.Selector.Matches("payment", nil) || tenant.Selector.Matches("dbservice", nil) {
+		t.Errorf("the payment ConfigMap got priority %d and selector %+v; want the lowest priority and only its namespace", tenant.Priority, tenant.Selector)
+	}
+}
+
+func TestWatcher_ListConfigMapsNotFound(t *testing.T) {
+	client := fakeclient.NewSimpleClientset()
+	w := K8sWatcher{Namespace: "kube-system", CfmName: "k8s-injector", client: client}
+
+	if _, err := w.ListConfigMaps(context.Background()); !apierrs.IsNotFound(err) {
+		t.Errorf("ListConfigMaps() err got = %v; want not found", err)
 	}
 }